@@ -26,6 +26,8 @@ import (
 	"github.com/nooga/let-go/pkg/vm"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 func motd() {
@@ -34,12 +36,8 @@ func motd() {
 }
 
 func runForm(ctx *compiler.Context, in string) (vm.Value, error) {
-	chunk, err := ctx.Compile(in)
-	if err != nil {
-		return nil, err
-	}
-
-	val, err := vm.NewFrame(chunk, nil).Run()
+	// CompileMultiple evaluates as it goes so that namespace changes stick to ctx
+	_, val, err := ctx.CompileMultiple(strings.NewReader(in))
 	if err != nil {
 		return nil, err
 	}
@@ -54,10 +52,15 @@ func repl(ctx *compiler.Context) {
 		in := scanner.Text()
 		ctx.SetSource("REPL")
 		val, err := runForm(ctx, in)
+		if err == nil {
+			var out string
+			out, err = vm.PrintString(val)
+			if err == nil {
+				fmt.Println(out)
+			}
+		}
 		if err != nil {
 			fmt.Println(err)
-		} else {
-			fmt.Println(val.String())
 		}
 		prompt = ctx.CurrentNS().Name() + "=> "
 		fmt.Print(prompt)
@@ -91,10 +94,12 @@ func runFile(ctx *compiler.Context, filename string) error {
 
 var runREPL bool
 var expr string
+var loadPath string
 
 func init() {
 	flag.BoolVar(&runREPL, "r", false, "attach REPL after running given files")
 	flag.StringVar(&expr, "e", "", "eval given expression")
+	flag.StringVar(&loadPath, "p", "", "list of directories to require namespaces from, separated by "+string(filepath.ListSeparator))
 }
 
 func initCompiler() *compiler.Context {
@@ -110,6 +115,10 @@ func main() {
 	flag.Parse()
 	files := flag.Args()
 
+	if loadPath != "" {
+		rt.SetLoadPath(filepath.SplitList(loadPath)...)
+	}
+
 	context := initCompiler()
 
 	ranSomething := false
@@ -127,10 +136,15 @@ func main() {
 	if expr != "" {
 		context.SetSource("EXPR")
 		val, err := runForm(context, expr)
+		if err == nil {
+			var out string
+			out, err = vm.PrintString(val)
+			if err == nil {
+				fmt.Println(out)
+			}
+		}
 		if err != nil {
 			fmt.Println(err)
-		} else {
-			fmt.Println(val)
 		}
 		ranSomething = true
 	}
@@ -28,7 +28,7 @@ type cell interface {
 
 type localCell struct {
 	scope *Context
-	local int
+	local *local
 }
 
 func (c *localCell) source() cell {
@@ -36,7 +36,8 @@ func (c *localCell) source() cell {
 }
 
 func (c *localCell) emit() error {
-	c.scope.emitWithArg(vm.OPDPN, c.scope.sp-1-c.local)
+	c.local.last = lastUse{address: c.scope.currentAddress(), depth: c.scope.loopDepth()}
+	c.scope.emitWithArg(vm.OPDPN, c.scope.sp-1-c.local.slot)
 	c.scope.incSP(1)
 	return nil
 }
@@ -51,11 +52,27 @@ func (c *argCell) source() cell {
 }
 
 func (c *argCell) emit() error {
+	c.scope.argUses[c.arg] = lastUse{address: c.scope.currentAddress(), depth: c.scope.loopDepth()}
 	c.scope.emitWithArg(vm.OPLDA, c.arg)
 	c.scope.incSP(1)
 	return nil
 }
 
+// selfCell refers to the fn being compiled by its own name
+type selfCell struct {
+	scope *Context
+}
+
+func (c *selfCell) source() cell {
+	return nil
+}
+
+func (c *selfCell) emit() error {
+	c.scope.emit(vm.OPLDS)
+	c.scope.incSP(1)
+	return nil
+}
+
 // might come in handy later
 
 //type varCell struct {
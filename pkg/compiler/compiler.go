@@ -27,12 +27,13 @@ import (
 
 type Context struct {
 	parent       *Context
-	consts       *[]vm.Value
+	consts       *vm.Consts
 	chunk        *vm.CodeChunk
 	formalArgs   map[vm.Symbol]int
+	argUses      map[int]lastUse
 	source       string
 	variadric    bool
-	locals       []map[vm.Symbol]int
+	locals       []map[vm.Symbol]*local
 	sp           int
 	spMax        int
 	isFunction   bool
@@ -41,21 +42,28 @@ type Context struct {
 	closedOvers  map[vm.Symbol]*closureCell
 	recurPoints  []*recurPoint
 	tailPosition bool
+	reader       *LispReader
+	pos          vm.SourceInfo
+	hasPos       bool
+	name         string
+	self         vm.Symbol
+	defName      string
+	ns           *vm.Namespace
 }
 
 // FIXME this is unacceptable hax
-var globalConsts *[]vm.Value
+var globalConsts *vm.Consts
 
 func init() {
-	globalConsts = &[]vm.Value{}
+	globalConsts = vm.NewConsts()
 }
 
 func NewCompiler(ns *vm.Namespace) *Context {
-	rt.CurrentNS.SetRoot(ns)
 	return &Context{
+		ns:          ns,
 		consts:      globalConsts,
 		source:      "<default>",
-		locals:      []map[vm.Symbol]int{},
+		locals:      []map[vm.Symbol]*local{},
 		closedOvers: map[vm.Symbol]*closureCell{},
 	}
 }
@@ -65,16 +73,42 @@ func (c *Context) SetSource(source string) *Context {
 	return c
 }
 
+// CurrentNS returns the namespace forms are compiled in, it's changed by in-ns evaluated by CompileMultiple
 func (c *Context) CurrentNS() *vm.Namespace {
-	return rt.CurrentNS.Deref().(*vm.Namespace)
+	return c.ns
 }
 
-func (c *Context) Compile(s string) (*vm.CodeChunk, error) {
+// withNS runs f with *ns* bound to the current namespace of c so that contexts don't affect each other
+func (c *Context) withNS(f func() error) error {
+	err := vm.PushBindings(map[*vm.Var]vm.Value{rt.CurrentNS: c.ns})
+	if err != nil {
+		return err
+	}
+	err = f()
+	c.ns = rt.CurrentNS.Deref().(*vm.Namespace)
+	perr := vm.PopBindings()
+	if err != nil {
+		return err
+	}
+	return perr
+}
+
+func (c *Context) Compile(s string) (chunk *vm.CodeChunk, err error) {
+	err = c.withNS(func() error {
+		chunk, err = c.compile(s)
+		return err
+	})
+	return chunk, err
+}
+
+func (c *Context) compile(s string) (*vm.CodeChunk, error) {
 	r := NewLispReader(strings.NewReader(s), c.source)
 	o, err := r.Read()
 	if err != nil {
 		return nil, err
 	}
+	c.reader = r
+	c.hasPos = false
 	c.resetSP()
 	c.chunk = vm.NewCodeChunk(c.consts)
 	err = c.compileForm(o)
@@ -87,8 +121,18 @@ func (c *Context) Compile(s string) (*vm.CodeChunk, error) {
 	return c.chunk, nil
 }
 
-func (c *Context) CompileMultiple(reader io.Reader) (*vm.CodeChunk, vm.Value, error) {
+// CompileMultiple compiles and evaluates all forms read from reader, it returns value of the last form
+func (c *Context) CompileMultiple(reader io.Reader) (chunk *vm.CodeChunk, result vm.Value, err error) {
+	err = c.withNS(func() error {
+		chunk, result, err = c.compileMultiple(reader)
+		return err
+	})
+	return chunk, result, err
+}
+
+func (c *Context) compileMultiple(reader io.Reader) (*vm.CodeChunk, vm.Value, error) {
 	r := NewLispReader(reader, c.source)
+	c.reader = r
 	chunk := vm.NewCodeChunk(c.consts)
 	var result vm.Value = vm.NIL
 	compiledForms := 0
@@ -105,6 +149,7 @@ func (c *Context) CompileMultiple(reader io.Reader) (*vm.CodeChunk, vm.Value, er
 		}
 		formchunk := vm.NewCodeChunk(c.consts)
 		c.chunk = formchunk
+		c.hasPos = false
 		c.resetSP()
 		err = c.compileForm(o)
 		c.chunk.SetMaxStack(c.spMax)
@@ -119,6 +164,8 @@ func (c *Context) CompileMultiple(reader io.Reader) (*vm.CodeChunk, vm.Value, er
 		if err != nil {
 			return nil, result, err
 		}
+		// the form might have switched namespaces with in-ns
+		c.ns = rt.CurrentNS.Deref().(*vm.Namespace)
 		compiledForms++
 	}
 
@@ -139,13 +186,7 @@ func (c *Context) emitWithArg(op uint8, arg int) {
 }
 
 func (c *Context) constant(v vm.Value) int {
-	for i := range *c.consts {
-		if (*c.consts)[i] == v {
-			return i
-		}
-	}
-	*c.consts = append(*c.consts, v)
-	return len(*c.consts) - 1
+	return c.consts.Intern(v)
 }
 
 func (c *Context) arg(v vm.Symbol) int {
@@ -164,15 +205,20 @@ func (c *Context) enterFn(args []vm.Value) (*Context, error) {
 		consts:       c.consts,
 		chunk:        fchunk,
 		formalArgs:   make(map[vm.Symbol]int),
-		locals:       []map[vm.Symbol]int{},
+		argUses:      make(map[int]lastUse),
+		locals:       []map[vm.Symbol]*local{},
 		closedOvers:  make(map[vm.Symbol]*closureCell),
 		isFunction:   true,
 		tailPosition: true,
+		reader:       c.reader,
+		ns:           c.ns,
+	}
+	if c.hasPos {
+		fc.setSource(c.pos)
 	}
 
 	for i := range args {
-		a := args[i]
-		s, ok := a.(vm.Symbol)
+		s, ok := vm.SymbolOf(args[i])
 		if !ok {
 			return nil, NewCompileError("all fn formal arguments must be symbols")
 		}
@@ -197,7 +243,11 @@ func (c *Context) enterFn(args []vm.Value) (*Context, error) {
 func (c *Context) leaveFn(ctx *Context) {
 	fnchunk := ctx.chunk
 	fnchunk.SetMaxStack(ctx.spMax)
+	for _, use := range ctx.argUses {
+		ctx.clearAt(use, 0, vm.OPLAC)
+	}
 	f := vm.MakeFunc(len(ctx.formalArgs), ctx.variadric, fnchunk)
+	f.SetName(ctx.name)
 
 	n := c.constant(f)
 	c.emitWithArg(vm.OPLDC, n)
@@ -206,9 +256,15 @@ func (c *Context) leaveFn(ctx *Context) {
 	// if we have a closure on our hands then add closed overs
 	if ctx.isClosure {
 		c.emit(vm.OPMKC)
+		// closed overs have to be packed in the same order they were assigned indices
+		ordered := make([]*closureCell, ctx.closedOversC)
 		for _, clo := range ctx.closedOvers {
+			ordered[clo.closure] = clo
+		}
+		for _, clo := range ordered {
 			_ = clo.source().emit()
 			c.emit(vm.OPPAK)
+			c.decSP(1)
 		}
 	}
 }
@@ -221,7 +277,7 @@ func (c *Context) symbolLookup(s vm.Symbol) cell {
 		}
 	}
 	local := c.lookupLocal(s)
-	if local >= 0 {
+	if local != nil {
 		// we have a local symbol in scope
 		return &localCell{
 			scope: c,
@@ -235,6 +291,9 @@ func (c *Context) symbolLookup(s vm.Symbol) cell {
 			arg:   arg,
 		}
 	}
+	if c.self != "" && s == c.self {
+		return &selfCell{scope: c}
+	}
 	if c.parent == nil {
 		return nil
 	}
@@ -255,33 +314,47 @@ func (c *Context) symbolLookup(s vm.Symbol) cell {
 
 func (c *Context) compileForm(o vm.Value) error {
 	switch o.Type() {
-	case vm.IntType, vm.StringType, vm.NilType, vm.BooleanType, vm.KeywordType, vm.CharType, vm.VoidType, vm.FuncType:
+	case vm.IntType, vm.FloatType, vm.BigIntType, vm.RatioType, vm.StringType, vm.NilType, vm.BooleanType, vm.KeywordType, vm.CharType, vm.VoidType, vm.FuncType:
 		n := c.constant(o)
 		c.emitWithArg(vm.OPLDC, n)
 		c.incSP(1)
 	case vm.SymbolType:
-		cel := c.symbolLookup(o.(vm.Symbol))
+		sym, _ := vm.SymbolOf(o)
+		cel := c.symbolLookup(sym)
 		if cel != nil {
 			return cel.emit()
 		}
 		// when symbol not found so far we have a free variable on our hands
-		v := c.CurrentNS().Lookup(o.(vm.Symbol))
+		v, err := c.resolveVar(sym)
+		if err != nil {
+			return err
+		}
 		if v == vm.NIL {
-			return NewCompileError("Can't resolve " + string(o.(vm.Symbol)) + " in this context")
+			return NewCompileError("Can't resolve " + string(sym) + " in this context")
+		}
+		if v.(*vm.Var).IsConst() {
+			n := c.constant(v.(*vm.Var).Deref())
+			c.emitWithArg(vm.OPLDC, n)
+			c.incSP(1)
+			return nil
 		}
 		varn := c.constant(v)
 		c.emitWithArg(vm.OPLDC, varn)
 		c.emit(vm.OPLDV)
 		c.incSP(1)
-	case vm.ArrayVectorType:
+	case vm.ArrayVectorType, vm.PersistentVectorType:
+		if meta := vm.MetaOf(o); meta != vm.NIL {
+			return c.compileWithMeta(o.(vm.IObj), meta)
+		}
 		tp := c.tailPosition
 		c.tailPosition = false
-		v := o.(vm.ArrayVector)
+		v, _ := vectorForm(o)
 		// FIXME detect const vectors and push them like this
 		if len(v) == 0 {
-			n := c.constant(v)
+			n := c.constant(vm.EmptyPersistentVector)
 			c.emitWithArg(vm.OPLDC, n)
 			c.incSP(1)
+			c.tailPosition = tp
 			return nil
 		}
 		vector := c.constant(rt.CoreNS.Lookup("vector"))
@@ -297,37 +370,82 @@ func (c *Context) compileForm(o vm.Value) error {
 		c.decSP(len(v))
 		c.tailPosition = tp
 	case vm.MapType:
+		if meta := vm.MetaOf(o); meta != vm.NIL {
+			return c.compileWithMeta(o.(vm.IObj), meta)
+		}
 		tp := c.tailPosition
 		c.tailPosition = false
-		v := o.(vm.Map)
+		v := o.(*vm.Map)
 		// FIXME detect const maps and push them like this
-		if len(v) == 0 {
+		if v.RawCount() == 0 {
 			n := c.constant(v)
 			c.emitWithArg(vm.OPLDC, n)
 			c.incSP(1)
+			c.tailPosition = tp
 			return nil
 		}
-		hashMap := c.constant(rt.CoreNS.Lookup("hash-map"))
-		c.emitWithArg(vm.OPLDC, hashMap)
+		c.emitWithArg(vm.OPLDC, c.constant(mapLiteral))
 		c.incSP(1)
-		for k, val := range v {
-			err := c.compileForm(k)
+		var err error
+		v.Each(func(k vm.Value, val vm.Value) {
 			if err != nil {
-				return NewCompileError("compiling map key").Wrap(err)
+				return
+			}
+			if err = c.compileForm(k); err != nil {
+				err = NewCompileError("compiling map key").Wrap(err)
+				return
 			}
-			err = c.compileForm(val)
+			if err = c.compileForm(val); err != nil {
+				err = NewCompileError("compiling map value").Wrap(err)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		c.emitWithArg(vm.OPINV, v.RawCount()*2)
+		c.decSP(v.RawCount() * 2)
+		c.tailPosition = tp
+	case vm.SetType:
+		if meta := vm.MetaOf(o); meta != vm.NIL {
+			return c.compileWithMeta(o.(vm.IObj), meta)
+		}
+		tp := c.tailPosition
+		c.tailPosition = false
+		v := o.(*vm.Set).Unbox().([]vm.Value)
+		if len(v) == 0 {
+			n := c.constant(vm.EmptySet)
+			c.emitWithArg(vm.OPLDC, n)
+			c.incSP(1)
+			c.tailPosition = tp
+			return nil
+		}
+		c.emitWithArg(vm.OPLDC, c.constant(setLiteral))
+		c.incSP(1)
+		for i := range v {
+			err := c.compileForm(v[i])
 			if err != nil {
-				return NewCompileError("compiling map value").Wrap(err)
+				return NewCompileError("compiling set elements").Wrap(err)
 			}
 		}
-		c.emitWithArg(vm.OPINV, len(v)*2)
-		c.decSP(len(v) * 2)
+		c.emitWithArg(vm.OPINV, len(v))
+		c.decSP(len(v))
 		c.tailPosition = tp
 	case vm.ListType:
+		if o.(*vm.List).RawCount() == 0 {
+			c.emitWithArg(vm.OPLDC, c.constant(vm.EmptyList))
+			c.incSP(1)
+			return nil
+		}
+		if info, ok := c.sourceInfo(o); ok {
+			prev, hadPrev := c.pos, c.hasPos
+			c.setSource(info)
+			if hadPrev {
+				defer c.setSource(prev)
+			}
+		}
 		fn := o.(*vm.List).First()
 		// check if we're looking at a special form
-		if fn.Type() == vm.SymbolType {
-			fnsym := fn.(vm.Symbol)
+		if fnsym, ok := vm.SymbolOf(fn); ok {
 			formCompiler, ok := specialForms[fnsym]
 			if ok {
 				return formCompiler(c, o)
@@ -344,10 +462,19 @@ func (c *Context) compileForm(o vm.Value) error {
 				return c.compileForm(newform)
 			}
 
-			fvar := c.CurrentNS().Lookup(fnsym)
+			fvar, err := c.resolveVar(fnsym)
+			if err != nil {
+				return err
+			}
 			if fvar != vm.NIL && fvar.(*vm.Var).IsMacro() {
 				argvec := o.(*vm.List).Next().(*vm.List).Unbox().([]vm.Value)
-				newform := fvar.(*vm.Var).Invoke(argvec)
+				newform, err := fvar.(*vm.Var).Invoke(argvec)
+				if err == nil {
+					newform, err = c.macroForm(newform)
+				}
+				if err != nil {
+					return NewCompileError("expanding macro " + string(fnsym)).Wrap(err)
+				}
 				return c.compileForm(newform)
 			}
 		}
@@ -379,6 +506,69 @@ func (c *Context) compileForm(o vm.Value) error {
 	return nil
 }
 
+// lookupVar resolves a symbol to a var in current namespace,
+// qualified symbols fall back to namespaces which were not referred
+func (c *Context) lookupVar(s vm.Symbol) vm.Value {
+	v := c.CurrentNS().Lookup(s)
+	if v != vm.NIL {
+		return v
+	}
+	nsname, name := s.Namespaced()
+	if nsname == vm.NIL {
+		return vm.NIL
+	}
+	nss, _ := vm.SymbolOf(nsname)
+	ns := rt.FindNS(string(nss))
+	if ns == nil {
+		return vm.NIL
+	}
+	sym, _ := vm.SymbolOf(name)
+	return ns.Lookup(sym)
+}
+
+// resolveVar is lookupVar refusing private vars of other namespaces
+func (c *Context) resolveVar(s vm.Symbol) (vm.Value, error) {
+	v := c.lookupVar(s)
+	if v == vm.NIL {
+		return v, nil
+	}
+	va := v.(*vm.Var)
+	if va.IsPrivate() && va.Namespace() != c.CurrentNS().Name() {
+		return vm.NIL, NewCompileError(fmt.Sprintf("%s is not public", va))
+	}
+	return v, nil
+}
+
+// sourceInfo returns position at which form was read, if known
+func (c *Context) sourceInfo(form vm.Value) (vm.SourceInfo, bool) {
+	if c.reader == nil {
+		return vm.SourceInfo{}, false
+	}
+	return c.reader.SourceInfo(form)
+}
+
+// setSource marks code emitted from now on as coming from info
+func (c *Context) setSource(info vm.SourceInfo) {
+	c.pos = info
+	c.hasPos = true
+	c.chunk.AddSourceInfo(info)
+}
+
+// fnName picks a name for a fn being compiled in c and resets the name set by def
+func (c *Context) fnName(self vm.Symbol) string {
+	name := c.defName
+	c.defName = ""
+	switch {
+	case name != "":
+		return name
+	case self != "":
+		return string(self)
+	case c.name != "":
+		return c.name + "/fn"
+	}
+	return ""
+}
+
 func (c *Context) emitWithArgPlaceholder(inst uint8) int {
 	placeholder := c.currentAddress()
 	c.emitWithArg(inst, 0)
@@ -393,16 +583,51 @@ func (c *Context) updatePlaceholderArg(placeholder int, arg int) {
 	c.chunk.Update32(placeholder+1, arg)
 }
 
+// local is a let, loop or catch binding living in a stack slot
+type local struct {
+	slot  int
+	depth int
+	last  lastUse
+}
+
+// lastUse points at the most recent load of a local or an argument
+type lastUse struct {
+	address int
+	depth   int
+}
+
 func (c *Context) pushLocals() {
-	c.locals = append(c.locals, map[vm.Symbol]int{})
+	c.locals = append(c.locals, map[vm.Symbol]*local{})
 }
 
 func (c *Context) popLocals() {
+	for _, l := range c.locals[len(c.locals)-1] {
+		c.clearAt(l.last, l.depth, vm.OPDPC)
+	}
 	c.locals = c.locals[0 : len(c.locals)-1]
 }
 
 func (c *Context) addLocal(name vm.Symbol) {
-	c.locals[len(c.locals)-1][name] = c.sp - 1
+	c.locals[len(c.locals)-1][name] = &local{
+		slot:  c.sp - 1,
+		depth: c.loopDepth(),
+		last:  lastUse{address: -1},
+	}
+}
+
+// loopDepth is the number of loops enclosing the code being compiled
+func (c *Context) loopDepth() int {
+	return len(c.recurPoints)
+}
+
+// clearAt turns the last load of a binding into one that also clears it, so values like lazy seqs
+// can be collected while the rest of the scope runs. Code runs in the order it was emitted except
+// for recur, so loads inside loops entered after the binding are left alone.
+func (c *Context) clearAt(use lastUse, depth int, op uint8) {
+	if use.address < 0 || use.depth != depth {
+		return
+	}
+	c.chunk.Update(use.address, op)
 }
 
 func (c *Context) incSP(i int) {
@@ -421,28 +646,28 @@ func (c *Context) resetSP() {
 	c.spMax = 0
 }
 
-func (c *Context) lookupLocal(symbol vm.Symbol) int {
-	if len(c.locals) < 1 {
-		return -1
-	}
+func (c *Context) lookupLocal(symbol vm.Symbol) *local {
 	for i := len(c.locals) - 1; i >= 0; i-- {
 		local, ok := c.locals[i][symbol]
 		if ok {
 			return local
 		}
 	}
-	return -1
+	return nil
 }
 
 type recurPoint struct {
 	address int
 	argsc   int
+	sp      int
 }
 
+// pushRecurPoint marks current address as a recur target, expects argsc loop locals on top of the stack
 func (c *Context) pushRecurPoint(argsc int) {
 	c.recurPoints = append(c.recurPoints, &recurPoint{
 		address: c.currentAddress(),
 		argsc:   argsc,
+		sp:      c.sp - argsc,
 	})
 }
 
@@ -463,7 +688,16 @@ type formCompilerFunc func(*Context, vm.Value) error
 
 var specialForms map[vm.Symbol]formCompilerFunc
 
+// multiArityFn joins fns compiled for each arity of a multi-arity fn
+var multiArityFn vm.Value
+
+// mapLiteral and setLiteral build map and set literals, they fail when elements turn out equal once evaluated
+var mapLiteral, setLiteral vm.Value
+
 func compilerInit() {
+	multiArityFn, _ = vm.NativeFnType.Wrap(vm.NewMultiArityFn)
+	mapLiteral, _ = vm.NativeFnType.Wrap(vm.NewMapLiteral)
+	setLiteral, _ = vm.NativeFnType.Wrap(vm.NewSetLiteral)
 	specialForms = map[vm.Symbol]formCompilerFunc{
 		"if":    ifCompiler,
 		"do":    doCompiler,
@@ -475,6 +709,10 @@ func compilerInit() {
 		"let":   letCompiler,
 		"loop":  loopCompiler,
 		"recur": recurCompiler,
+		"throw": throwCompiler,
+		"try":   tryCompiler,
+
+		"binding": bindingCompiler,
 	}
 }
 
@@ -511,9 +749,10 @@ func recurCompiler(c *Context, form vm.Value) error {
 		args = args.Next()
 	}
 
-	if !c.isFunction && rp != nil {
+	if rp != nil {
 		c.emitWithArg(vm.OPREC, c.currentAddress()-rp.address)
 		c.chunk.Append32(argc)
+		c.chunk.Append32(c.sp - argc - rp.sp)
 	} else {
 		c.emitWithArg(vm.OPREF, argc)
 	}
@@ -524,18 +763,21 @@ func recurCompiler(c *Context, form vm.Value) error {
 
 func loopCompiler(c *Context, form vm.Value) error {
 	bindings := form.(*vm.List).Next()
-	binds, ok := bindings.First().(vm.ArrayVector)
+	binds, ok := vectorForm(bindings.First())
 	if !ok {
-		return NewCompileError("let bindings should be a vector")
+		return NewCompileError("loop bindings should be a vector")
 	}
 	body := bindings.Next()
+	if isDestructuring(binds) {
+		return loopDestructuring(c, binds, body)
+	}
 	c.pushLocals()
 	tp := c.tailPosition
 	c.tailPosition = false
 	bindn := 0
 	for i := 0; i < len(binds); i += 2 {
-		name := binds[i]
-		if name.Type() != vm.SymbolType {
+		name, ok := vm.SymbolOf(binds[i])
+		if !ok {
 			return NewCompileError("loop binding name must be a symbol")
 		}
 		if i+1 >= len(binds) {
@@ -546,10 +788,14 @@ func loopCompiler(c *Context, form vm.Value) error {
 		if err != nil {
 			return NewCompileError("compiling let binding").Wrap(err)
 		}
-		c.addLocal(name.(vm.Symbol))
+		c.addLocal(name)
 		bindn++
 	}
 	c.pushRecurPoint(bindn)
+	// recur rebinds loop locals so they belong inside the loop
+	for _, l := range c.locals[len(c.locals)-1] {
+		l.depth = c.loopDepth()
+	}
 	if body == vm.EmptyList {
 		c.emitWithArg(vm.OPLDC, c.constant(vm.NIL))
 		c.incSP(1)
@@ -576,20 +822,49 @@ func loopCompiler(c *Context, form vm.Value) error {
 	return nil
 }
 
+// loopDestructuring compiles a loop with destructured bindings as
+// (let [g v ...] (loop [g g ...] (let [binding-form g ...] body)))
+func loopDestructuring(c *Context, binds []vm.Value, body vm.Seq) error {
+	if len(binds)%2 != 0 {
+		return NewCompileError("loop bindings must have even number of forms")
+	}
+	var outer, loop, inner []vm.Value
+	for i := 0; i < len(binds); i += 2 {
+		name, value := binds[i], binds[i+1]
+		if _, ok := vm.SymbolOf(name); ok {
+			outer = append(outer, name, value)
+			loop = append(loop, name, name)
+			continue
+		}
+		g := rt.Gensym("loop__")
+		outer = append(outer, g, value)
+		loop = append(loop, g, g)
+		inner = append(inner, name, g)
+	}
+	innerLet := body.Cons(vm.ArrayVector(inner)).Cons(vm.Symbol("let"))
+	form := list(vm.Symbol("let"), vm.ArrayVector(outer),
+		list(vm.Symbol("loop"), vm.ArrayVector(loop), innerLet))
+	return c.compileForm(form)
+}
+
 func letCompiler(c *Context, form vm.Value) error {
 	bindings := form.(*vm.List).Next()
-	binds, ok := bindings.First().(vm.ArrayVector)
+	binds, ok := vectorForm(bindings.First())
 	if !ok {
 		return NewCompileError("let bindings should be a vector")
 	}
+	binds, err := destructure(binds)
+	if err != nil {
+		return NewCompileError("destructuring let bindings").Wrap(err)
+	}
 	body := bindings.Next()
 	c.pushLocals()
 	tc := c.tailPosition
 	c.tailPosition = false
 	bindn := 0
 	for i := 0; i < len(binds); i += 2 {
-		name := binds[i]
-		if name.Type() != vm.SymbolType {
+		name, ok := vm.SymbolOf(binds[i])
+		if !ok {
 			return NewCompileError("let binding name must be a symbol")
 		}
 		if i+1 >= len(binds) {
@@ -600,7 +875,7 @@ func letCompiler(c *Context, form vm.Value) error {
 		if err != nil {
 			return NewCompileError("compiling let binding").Wrap(err)
 		}
-		c.addLocal(name.(vm.Symbol))
+		c.addLocal(name)
 		bindn++
 	}
 	if body == vm.EmptyList {
@@ -638,15 +913,92 @@ func quoteCompiler(c *Context, form vm.Value) error {
 func fnCompiler(c *Context, form vm.Value) error {
 	f := form.(*vm.List).Next()
 
-	args := f.First().(vm.ArrayVector).Unbox().([]vm.Value)
+	var self vm.Symbol
+	if s, ok := vm.SymbolOf(f.First()); ok {
+		self = s
+		f = f.Next()
+	}
+	if f == vm.EmptyList {
+		return NewCompileError("fn needs a parameter vector or arity declarations")
+	}
+	name := c.fnName(self)
+
+	if _, ok := vectorForm(f.First()); ok {
+		return c.compileArity(name, self, f)
+	}
+
+	// multiple arities are compiled separately and joined at runtime
+	c.emitWithArg(vm.OPLDC, c.constant(multiArityFn))
+	c.incSP(1)
+	arities := 0
+	fixed := map[int]bool{}
+	variadic := -1
+	for a := f; a != vm.EmptyList; a = a.Next() {
+		decl, ok := a.First().(*vm.List)
+		var params vm.ArrayVector
+		if ok && decl != vm.EmptyList {
+			params, ok = vectorForm(decl.First())
+		}
+		if !ok || decl == vm.EmptyList {
+			return NewCompileError(fmt.Sprintf("fn arity must be a list starting with a parameter vector, got %s", a.First()))
+		}
+		n, rest := paramCount(params)
+		switch {
+		case rest && variadic >= 0:
+			return NewCompileError("can't have more than one variadic arity")
+		case rest:
+			variadic = n
+		case fixed[n]:
+			return NewCompileError(fmt.Sprintf("can't have two arities with %d arguments", n))
+		default:
+			fixed[n] = true
+		}
+		err := c.compileArity(name, self, decl)
+		if err != nil {
+			return err
+		}
+		arities++
+	}
+	for n := range fixed {
+		if variadic >= 0 && n > variadic {
+			return NewCompileError("can't have fixed arity function with more params than variadic function")
+		}
+	}
+	c.emitWithArg(vm.OPINV, arities)
+	c.decSP(arities)
+	return nil
+}
+
+// paramCount returns the number of fixed params and whether there's a rest param
+func paramCount(params vm.ArrayVector) (int, bool) {
+	for i := range params {
+		if params[i] == vm.Symbol("&") {
+			return i, true
+		}
+	}
+	return len(params), false
+}
+
+// compileArity compiles ([params] body...) into a fn and emits code pushing it on the stack
+func (c *Context) compileArity(name string, self vm.Symbol, decl vm.Seq) error {
+	args, ok := vectorForm(decl.First())
+	if !ok {
+		return NewCompileError("fn args should be a vector")
+	}
+	params, binds := destructureParams(args)
 
-	fc, err := c.enterFn(args)
+	fc, err := c.enterFn(params)
 	if err != nil {
 		return NewCompileError("compiling fn args").Wrap(err)
 	}
+	fc.name = name
+	fc.self = self
 	defer c.leaveFn(fc)
 
-	body := f.(*vm.List).Next().Unbox().([]vm.Value)
+	body := decl.Next().Unbox().([]vm.Value)
+	if len(binds) > 0 {
+		body = []vm.Value{decl.Next().Cons(vm.ArrayVector(binds)).Cons(vm.Symbol("let"))}
+	}
 	l := len(body)
 	if l == 0 {
 		fc.emitWithArg(vm.OPLDC, fc.constant(vm.NIL))
@@ -746,18 +1098,27 @@ func defCompiler(c *Context, form vm.Value) error {
 	c.tailPosition = false
 	args := form.(*vm.List).Next().Unbox().([]vm.Value)
 	l := len(args)
-	if l != 2 {
-		return NewCompileError(fmt.Sprintf("def: wrong number of forms (%d), need 2", l))
+	if l != 2 && l != 3 {
+		return NewCompileError(fmt.Sprintf("def: wrong number of forms (%d), need 2 or 3", l))
+	}
+	sym, ok := vm.SymbolOf(args[0])
+	val := args[l-1]
+	if !ok {
+		return NewCompileError(fmt.Sprintf("def: first argument must be a symbol, got (%v)", args[0]))
 	}
-	sym := args[0]
-	val := args[1]
-	if sym.Type() != vm.SymbolType {
-		return NewCompileError(fmt.Sprintf("def: first argument must be a symbol, got (%v)", sym))
+	if l == 3 && args[1].Type() != vm.StringType {
+		return NewCompileError(fmt.Sprintf("def: docstring must be a string, got (%v)", args[1]))
 	}
-	varr := c.constant(c.CurrentNS().LookupOrAdd(sym.(vm.Symbol)))
+	v := c.CurrentNS().LookupOrAdd(sym).(*vm.Var)
+	v.SetMeta(c.defMeta(args[0], args[1:l-1], val))
+	varr := c.constant(v)
 	c.emitWithArg(vm.OPLDC, varr)
 	c.incSP(1)
+	if isFnForm(val) {
+		c.defName = c.CurrentNS().Name() + "/" + string(sym)
+	}
 	err := c.compileForm(val)
+	c.defName = ""
 	if err != nil {
 		return NewCompileError("compiling def value").Wrap(err)
 	}
@@ -767,37 +1128,354 @@ func defCompiler(c *Context, form vm.Value) error {
 	return nil
 }
 
-// FIXME this is just def with a different name basically
+// defMeta builds metadata of a var defined with (def sym doc? val) from ^meta on sym, the docstring,
+// params of val if it's a fn form and the position of the def
+func (c *Context) defMeta(sym vm.Value, doc []vm.Value, val vm.Value) *vm.Map {
+	var ret vm.Associative = vm.EmptyMap
+	if meta, ok := vm.MetaOf(sym).(*vm.Map); ok {
+		ret = meta
+	}
+	if len(doc) == 1 {
+		ret = ret.Assoc(vm.Keyword("doc"), doc[0])
+	}
+	if arglists := fnArglists(val); arglists != nil {
+		ret = ret.Assoc(vm.Keyword("arglists"), arglists)
+	}
+	if c.hasPos {
+		ret = ret.Assoc(vm.Keyword("file"), vm.String(c.pos.File)).Assoc(vm.Keyword("line"), vm.Int(c.pos.Line))
+	}
+	return ret.(*vm.Map)
+}
+
+// fnArglists returns a list of parameter vectors of a fn form, nil if form is not a fn form
+func fnArglists(form vm.Value) vm.Value {
+	if !isFnForm(form) {
+		return nil
+	}
+	tail := form.(*vm.List).Next()
+	if _, named := vm.SymbolOf(tail.First()); named {
+		tail = tail.Next()
+	}
+	if _, ok := vectorForm(tail.First()); ok {
+		return vm.EmptyList.Cons(tail.First())
+	}
+	var arglists []vm.Value
+	for ; tail != vm.EmptyList && tail != nil; tail = tail.Next() {
+		arity, ok := tail.First().(*vm.List)
+		if !ok || arity == vm.EmptyList {
+			return nil
+		}
+		arglists = append(arglists, arity.First())
+	}
+	ret, _ := vm.ListType.Box(arglists)
+	return ret
+}
+
+// setBangCompiler compiles (set! var val) into var-set so that thread bindings are respected
 func setBangCompiler(c *Context, form vm.Value) error {
-	tc := c.tailPosition
-	c.tailPosition = false
 	args := form.(*vm.List).Next().Unbox().([]vm.Value)
 	l := len(args)
 	if l != 2 {
 		return NewCompileError(fmt.Sprintf("set!: wrong number of forms (%d), need 2", l))
 	}
-	sym := args[0]
-	val := args[1]
-	if sym.Type() != vm.SymbolType {
-		return NewCompileError(fmt.Sprintf("set!: first argument must be a symbol, got (%v)", sym))
+	sym, ok := vm.SymbolOf(args[0])
+	if !ok {
+		return NewCompileError(fmt.Sprintf("set!: first argument must be a symbol, got (%v)", args[0]))
+	}
+	v, ok := c.lookupVar(sym).(*vm.Var)
+	if !ok {
+		return NewCompileError(fmt.Sprintf("set!: can't resolve var %v", sym))
 	}
-	varr := c.constant(c.CurrentNS().Lookup(sym.(vm.Symbol)))
+	return c.compileForm(list(vm.Symbol("core/var-set"), varForm(v), args[1]))
+}
+
+// bindingCompiler compiles (binding [var val*] body*), vars are bound on the running goroutine until body finishes
+func bindingCompiler(c *Context, form vm.Value) error {
+	bindings := form.(*vm.List).Next()
+	binds, ok := vectorForm(bindings.First())
+	if !ok {
+		return NewCompileError("binding bindings should be a vector")
+	}
+	if len(binds)%2 != 0 {
+		return NewCompileError("binding bindings must have even number of forms")
+	}
+	vals := []vm.Value{vm.Symbol("core/hash-map")}
+	for i := 0; i < len(binds); i += 2 {
+		sym, ok := vm.SymbolOf(binds[i])
+		if !ok {
+			return NewCompileError(fmt.Sprintf("binding: binding name must be a symbol, got (%v)", binds[i]))
+		}
+		v, ok := c.lookupVar(sym).(*vm.Var)
+		if !ok {
+			return NewCompileError(fmt.Sprintf("binding: can't resolve var %v", sym))
+		}
+		vals = append(vals, varForm(v), binds[i+1])
+	}
+	// values are evaluated before any of the vars gets bound
+	body := []vm.Value{vm.Symbol("try")}
+	body = append(body, bindings.Next().(*vm.List).Unbox().([]vm.Value)...)
+	body = append(body, list(vm.Symbol("finally"), list(vm.Symbol("core/pop-thread-bindings"))))
+	return c.compileForm(list(vm.Symbol("do"), list(vm.Symbol("core/push-thread-bindings"), list(vals...)), list(body...)))
+}
+
+// varForm returns a form evaluating to v
+func varForm(v *vm.Var) vm.Value {
+	return list(vm.Symbol("var"), vm.Symbol(v.Namespace()+"/"+v.Name()))
+}
+
+func varCompiler(c *Context, form vm.Value) error {
+	sym, ok := vm.SymbolOf(form.(*vm.List).Next().First())
+	if !ok {
+		return NewCompileError("var: argument must be a symbol")
+	}
+	v := c.lookupVar(sym)
+	if v == vm.NIL {
+		v = c.CurrentNS().LookupOrAdd(sym)
+	}
+	varr := c.constant(v)
 	c.emitWithArg(vm.OPLDC, varr)
 	c.incSP(1)
-	err := c.compileForm(val)
+	return nil
+}
+
+func throwCompiler(c *Context, form vm.Value) error {
+	args := form.(*vm.List).Next().Unbox().([]vm.Value)
+	if len(args) != 1 {
+		return NewCompileError(fmt.Sprintf("throw: wrong number of forms (%d), need 1", len(args)))
+	}
+	tc := c.tailPosition
+	c.tailPosition = false
+	err := c.compileForm(args[0])
 	if err != nil {
-		return NewCompileError("compiling set! value").Wrap(err)
+		return NewCompileError("compiling thrown value").Wrap(err)
 	}
-	c.emit(vm.OPSTV)
-	c.decSP(1)
+	// the value stays on the stack as far as the compiler is concerned, THR never falls through
+	c.emit(vm.OPTHR)
 	c.tailPosition = tc
 	return nil
 }
 
-func varCompiler(c *Context, form vm.Value) error {
-	sym := form.(*vm.List).Next().First().(vm.Symbol)
-	varr := c.constant(c.CurrentNS().LookupOrAdd(sym))
-	c.emitWithArg(vm.OPLDC, varr)
-	c.incSP(1)
+// isFnForm checks if form is a (fn ...) special form
+func isFnForm(form vm.Value) bool {
+	l, ok := form.(*vm.List)
+	return ok && l != vm.EmptyList && l.First() == vm.Symbol("fn")
+}
+
+// compileWithMeta compiles a collection literal carrying metadata into (with-meta coll meta),
+// metadata is evaluated just like in Clojure
+func (c *Context) compileWithMeta(o vm.IObj, meta vm.Value) error {
+	return c.compileForm(list(vm.Symbol("core/with-meta"), o.WithMeta(nil), meta))
+}
+
+// macroForm turns seqs built by macros at runtime (lazy seqs, conses) into lists the compiler understands.
+// Lists which don't contain such seqs are left alone so that source positions of forms coming from the reader survive.
+func (c *Context) macroForm(form vm.Value) (vm.Value, error) {
+	ret, _, err := normalizeForm(form)
+	return ret, err
+}
+
+// normalizeForm does the work of macroForm and reports if anything had to be replaced
+func normalizeForm(form vm.Value) (vm.Value, bool, error) {
+	switch f := form.(type) {
+	case *vm.List:
+		vs := f.Unbox().([]vm.Value)
+		changed, err := normalizeForms(vs)
+		if err != nil || !changed {
+			return f, false, err
+		}
+		l, err := vm.ListType.Box(vs)
+		return l, true, err
+	case vm.ArrayVector, *vm.PersistentVector:
+		v, _ := vectorForm(f)
+		vs := make([]vm.Value, len(v))
+		copy(vs, v)
+		changed, err := normalizeForms(vs)
+		if err != nil || !changed {
+			return f, false, err
+		}
+		return vm.ArrayVector(vs), true, nil
+	case *vm.Map:
+		var ret vm.Associative = vm.EmptyMap
+		var err error
+		changed := false
+		f.Each(func(k vm.Value, v vm.Value) {
+			kv := []vm.Value{k, v}
+			c, e := normalizeForms(kv)
+			if e != nil {
+				err = e
+			}
+			changed = changed || c
+			ret = ret.Assoc(kv[0], kv[1])
+		})
+		if err != nil || !changed {
+			return f, false, err
+		}
+		return ret, true, nil
+	case *vm.Set:
+		vs := f.Unbox().([]vm.Value)
+		changed, err := normalizeForms(vs)
+		if err != nil || !changed {
+			return f, false, err
+		}
+		return vm.NewSet(vs), true, nil
+	case vm.Seq:
+		// any other seq, like lazy seqs returned by concat
+		vs, err := vm.SeqValues(f)
+		if err != nil {
+			return vm.NIL, false, err
+		}
+		if _, err = normalizeForms(vs); err != nil {
+			return vm.NIL, false, err
+		}
+		l, err := vm.ListType.Box(vs)
+		return l, true, err
+	}
+	return form, false, nil
+}
+
+// normalizeForms replaces elements of vs with their normalized forms and reports if anything changed
+func normalizeForms(vs []vm.Value) (bool, error) {
+	changed := false
+	for i := range vs {
+		f, c, err := normalizeForm(vs[i])
+		if err != nil {
+			return false, err
+		}
+		if c {
+			changed = true
+			vs[i] = f
+		}
+	}
+	return changed, nil
+}
+
+// vectorForm returns elements of a vector form, vectors built by macros at runtime are accepted as well
+func vectorForm(form vm.Value) (vm.ArrayVector, bool) {
+	switch v := form.(type) {
+	case vm.ArrayVector:
+		return v, true
+	case *vm.PersistentVector:
+		return v.Unbox().([]vm.Value), true
+	}
+	return nil, false
+}
+
+func doForm(body []vm.Value) vm.Value {
+	form, _ := vm.ListType.Box(append([]vm.Value{vm.Symbol("do")}, body...))
+	return form
+}
+
+func isClause(form vm.Value, name vm.Symbol) bool {
+	l, ok := form.(*vm.List)
+	return ok && l.RawCount() > 0 && l.First() == name
+}
+
+func tryCompiler(c *Context, form vm.Value) error {
+	args := form.(*vm.List).Next().Unbox().([]vm.Value)
+	var body, catches []vm.Value
+	var finally vm.Value
+	for i := range args {
+		switch {
+		case isClause(args[i], "catch"):
+			if finally != nil {
+				return NewCompileError("try: catch can't follow finally")
+			}
+			if args[i].(*vm.List).RawCount() < 3 {
+				return NewCompileError("try: malformed catch clause, expecting (catch type name body*)")
+			}
+			catches = append(catches, args[i])
+		case isClause(args[i], "finally"):
+			if i != len(args)-1 {
+				return NewCompileError("try: finally clause must be last")
+			}
+			finally = args[i]
+		default:
+			if len(catches) > 0 {
+				return NewCompileError("try: body forms can't follow catch clauses")
+			}
+			body = append(body, args[i])
+		}
+	}
+
+	tc := c.tailPosition
+	c.tailPosition = false
+
+	sp := c.sp
+	start := c.currentAddress()
+	err := c.compileForm(doForm(body))
+	if err != nil {
+		return NewCompileError("compiling try body").Wrap(err)
+	}
+
+	if len(catches) > 0 {
+		end := c.currentAddress()
+		exits := []int{c.emitWithArgPlaceholder(vm.OPJMP)}
+		c.chunk.AddHandler(start, end, c.currentAddress(), sp)
+		// at this point the exception sits on the stack in place of the body value
+		instance := c.constant(rt.CoreNS.Lookup("instance?"))
+		for _, clause := range catches {
+			parts := clause.(*vm.List).Next().Unbox().([]vm.Value)
+			name, ok := vm.SymbolOf(parts[1])
+			if !ok {
+				return NewCompileError("try: catch binding must be a symbol")
+			}
+			next := -1
+			if parts[0] != vm.Keyword("default") {
+				c.emitWithArg(vm.OPLDC, instance)
+				c.incSP(1)
+				err = c.compileForm(parts[0])
+				if err != nil {
+					return NewCompileError("compiling catch type").Wrap(err)
+				}
+				c.emitWithArg(vm.OPDPN, 2)
+				c.incSP(1)
+				c.emitWithArg(vm.OPINV, 2)
+				c.decSP(2)
+				next = c.emitWithArgPlaceholder(vm.OPBRF)
+				c.decSP(1)
+			}
+			c.pushLocals()
+			c.addLocal(name)
+			err = c.compileForm(doForm(parts[2:]))
+			c.popLocals()
+			if err != nil {
+				return NewCompileError("compiling catch body").Wrap(err)
+			}
+			c.emitWithArg(vm.OPPON, 1)
+			c.decSP(1)
+			exits = append(exits, c.emitWithArgPlaceholder(vm.OPJMP))
+			if next >= 0 {
+				c.updatePlaceholderArg(next, c.currentAddress()-next)
+			}
+		}
+		// nothing matched so we rethrow
+		c.emit(vm.OPTHR)
+		for _, exit := range exits {
+			c.updatePlaceholderArg(exit, c.currentAddress()-exit)
+		}
+	}
+
+	if finally != nil {
+		end := c.currentAddress()
+		fbody := finally.(*vm.List).Next().Cons(vm.Symbol("do"))
+		err = c.compileForm(fbody)
+		if err != nil {
+			return NewCompileError("compiling finally").Wrap(err)
+		}
+		c.emit(vm.OPPOP)
+		c.decSP(1)
+		exit := c.emitWithArgPlaceholder(vm.OPJMP)
+		c.chunk.AddHandler(start, end, c.currentAddress(), sp)
+		err = c.compileForm(fbody)
+		if err != nil {
+			return NewCompileError("compiling finally").Wrap(err)
+		}
+		c.emit(vm.OPPOP)
+		c.decSP(1)
+		c.emit(vm.OPTHR)
+		c.updatePlaceholderArg(exit, c.currentAddress()-exit)
+	}
+
+	c.tailPosition = tc
 	return nil
 }
@@ -18,11 +18,17 @@
 package compiler
 
 import (
+	"fmt"
 	"github.com/nooga/let-go/pkg/rt"
 	"github.com/nooga/let-go/pkg/vm"
 	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestContext_Compile(t *testing.T) {
@@ -117,3 +123,286 @@ func TestContext_CompileVar(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, v, out)
 }
+
+func TestContext_CompileErrors(t *testing.T) {
+	tests := []string{
+		"(+ 1 :a)",
+		"((fn [x] x))",
+		"((fn [x y] (+ x y)) 1 2 3)",
+		"((fn [x] (/ x 0)) 1)",
+		"(1 2)",
+		"(:a {:a 1} 2 3)",
+		"(let [f (fn [] (first 1))] (f))",
+	}
+	for _, src := range tests {
+		_, err := Eval(src)
+		assert.Error(t, err, src)
+	}
+}
+
+func TestContext_CompileFnError(t *testing.T) {
+	out, err := Eval("(fn [x] (+ x :nope))")
+	assert.NoError(t, err)
+
+	var broken func(int) (int, error)
+	out.Unbox().(func(interface{}))(&broken)
+
+	_, err = broken(1)
+	assert.Error(t, err)
+}
+
+func TestContext_CompileThrow(t *testing.T) {
+	_, err := Eval(`(throw (ex-info "boom" {:a 1}))`)
+	assert.Error(t, err)
+	rerr, ok := err.(*vm.RuntimeError)
+	assert.True(t, ok)
+	ex, ok := rerr.GetCause().(*vm.ExInfo)
+	assert.True(t, ok)
+	assert.Equal(t, "boom", ex.Message())
+
+	out, err := Eval(`(try (throw (ex-info "boom" {:a 1})) (catch ExceptionInfo e (:a (ex-data e))))`)
+	assert.NoError(t, err)
+	assert.Equal(t, vm.Int(1), out)
+}
+
+func TestContext_StackTrace(t *testing.T) {
+	src := `(def boom (fn [x]
+  (throw (ex-info "boom" {:x x}))))
+(def caller (fn [] (+ 1 (boom 2))))
+(caller)`
+	c := NewCompiler(rt.NS("user")).SetSource("trace.lg")
+	_, _, err := c.CompileMultiple(strings.NewReader(src))
+	assert.Error(t, err)
+	rerr, ok := err.(*vm.RuntimeError)
+	assert.True(t, ok)
+	trace := rerr.Trace()
+	assert.Equal(t, 3, len(trace))
+	assert.Equal(t, "user/boom", trace[0].Fn)
+	assert.Equal(t, vm.SourceInfo{File: "trace.lg", Line: 2, Column: 3}, trace[0].Source)
+	assert.Equal(t, "user/caller", trace[1].Fn)
+	assert.Equal(t, vm.SourceInfo{File: "trace.lg", Line: 3, Column: 25}, trace[1].Source)
+	assert.Equal(t, "<toplevel>", trace[2].Fn)
+	assert.Equal(t, vm.SourceInfo{File: "trace.lg", Line: 4, Column: 1}, trace[2].Source)
+	assert.Contains(t, err.Error(), "at user/boom (trace.lg:2:3)")
+}
+
+func TestContext_CompileDestructuring(t *testing.T) {
+	out, err := Eval(`(let [[a {:keys [b]}] [1 {:b 2}]] (+ a b))`)
+	assert.NoError(t, err)
+	assert.Equal(t, vm.Int(3), out)
+
+	_, err = Eval(`(let [1 2] 3)`)
+	assert.Error(t, err)
+
+	_, err = Eval(`(let [[a &] [1]] a)`)
+	assert.Error(t, err)
+}
+
+func TestContext_CompileMultiArityFn(t *testing.T) {
+	out, err := Eval(`((fn f ([] (f 1)) ([x] (+ x 1))))`)
+	assert.NoError(t, err)
+	assert.Equal(t, vm.Int(2), out)
+
+	_, err = Eval(`(fn ([x] 1) ([y] 2))`)
+	assert.Error(t, err)
+
+	_, err = Eval(`(fn ([& x] 1) ([y & z] 2))`)
+	assert.Error(t, err)
+
+	_, err = Eval(`(fn ([a b] 1) ([& z] 2))`)
+	assert.Error(t, err)
+}
+
+func TestContext_Concurrent(t *testing.T) {
+	_, err := Eval(`(do (def conc-counter (atom 0))
+                        (def conc-shared (map inc (range 1000)))
+                        (defmacro conc-twice [x] ` + "`" + `(let [y# ~x] (+ y# y#))))`)
+	assert.NoError(t, err)
+	sq, err := Eval(`(fn [x] (* x x))`)
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			src := fmt.Sprintf(`(do (def conc-x%d (fn [n] (reduce + (take n (range)))))
+                                    (swap! conc-counter inc)
+                                    [(conc-x%d 100) (reduce + conc-shared) (conc-twice %d)])`, i, i, i)
+			out, err := Eval(src)
+			assert.NoError(t, err)
+			assert.True(t, vm.Equals(vm.ArrayVector{vm.Int(4950), vm.Int(500500), vm.Int(2 * i)}, out))
+			out, err = sq.(vm.Fn).Invoke([]vm.Value{vm.Int(i)})
+			assert.NoError(t, err)
+			assert.Equal(t, vm.Int(i*i), out)
+		}(i)
+	}
+	wg.Wait()
+
+	out, err := Eval(`@conc-counter`)
+	assert.NoError(t, err)
+	assert.Equal(t, vm.Int(16), out)
+}
+
+func TestContext_NamespaceIsolation(t *testing.T) {
+	a := NewCompiler(rt.NS("user"))
+	b := NewCompiler(rt.NS("user"))
+	_, _, err := a.CompileMultiple(strings.NewReader(`(in-ns 'isolation.a) (def x 1)`))
+	assert.NoError(t, err)
+	assert.Equal(t, "isolation.a", a.CurrentNS().Name())
+	assert.Equal(t, "user", b.CurrentNS().Name())
+	assert.Equal(t, rt.CoreNS, rt.CurrentNS.Deref())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ctx := NewCompiler(rt.NS("user"))
+			name := fmt.Sprintf("isolation.n%d", i)
+			_, out, err := ctx.CompileMultiple(strings.NewReader(fmt.Sprintf(`(in-ns '%s) (def y %d) y`, name, i)))
+			assert.NoError(t, err)
+			assert.Equal(t, vm.Int(i), out)
+			assert.Equal(t, name, ctx.CurrentNS().Name())
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestContext_Require(t *testing.T) {
+	dir := t.TempDir()
+	err := os.MkdirAll(filepath.Join(dir, "req"), 0755)
+	assert.NoError(t, err)
+	src := `(ns req.some-lib) (def hidden 1) (defn f [] :f)`
+	err = os.WriteFile(filepath.Join(dir, "req", "some_lib.lg"), []byte(src), 0644)
+	assert.NoError(t, err)
+
+	old := rt.LoadPath.Deref()
+	rt.SetLoadPath(dir)
+	defer rt.LoadPath.SetRoot(old)
+
+	ctx := NewCompiler(rt.NS("user"))
+	_, out, err := ctx.CompileMultiple(strings.NewReader(`(ns req.user (:require [req.some-lib :as l])) [(l/f) ::l/k]`))
+	assert.NoError(t, err)
+	assert.Equal(t, "req.user", ctx.CurrentNS().Name())
+	assert.Equal(t, "[:f :req.some-lib/k]", out.String())
+
+	_, _, err = ctx.CompileMultiple(strings.NewReader(`hidden`))
+	assert.Error(t, err)
+	_, _, err = ctx.CompileMultiple(strings.NewReader(`(require 'req.missing)`))
+	assert.Error(t, err)
+}
+
+func TestContext_RequireConcurrently(t *testing.T) {
+	dir := t.TempDir()
+	src := `(ns slow-lib) (def total (reduce + (range 300000)))`
+	err := os.WriteFile(filepath.Join(dir, "slow_lib.lg"), []byte(src), 0644)
+	assert.NoError(t, err)
+	src = `(ns cyclic-a (:require cyclic-b))`
+	err = os.WriteFile(filepath.Join(dir, "cyclic_a.lg"), []byte(src), 0644)
+	assert.NoError(t, err)
+	src = `(ns cyclic-b (:require cyclic-a))`
+	err = os.WriteFile(filepath.Join(dir, "cyclic_b.lg"), []byte(src), 0644)
+	assert.NoError(t, err)
+
+	old := rt.LoadPath.Deref()
+	rt.SetLoadPath(dir)
+	defer rt.LoadPath.SetRoot(old)
+
+	var wg sync.WaitGroup
+	errs := make([]error, 3)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var ns *vm.Namespace
+			ns, errs[i] = rt.Require("slow-lib", false)
+			if errs[i] == nil {
+				assert.Equal(t, vm.Int(44999850000), ns.Lookup("total").(*vm.Var).Deref())
+			}
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+
+	_, err = rt.Require("cyclic-a", false)
+	assert.Error(t, err)
+}
+
+func TestContext_ClearAfterLastUse(t *testing.T) {
+	tests := []string{
+		`(fn [mk use check] ((fn [x] (use x) (check)) (mk)))`,
+		`(fn [mk use check] (let [x (mk)] (use x) (check)))`,
+		`(fn [mk use check] (let [x (mk)] (if (use x) (check) (check))))`,
+		`(fn [mk use check] (loop [x (mk) n 1] (if (= n 1) (recur x 0) (do (use x) (check)))))`,
+	}
+	for _, src := range tests {
+		f, err := Eval(src)
+		assert.NoError(t, err)
+
+		collected := make(chan struct{})
+		mk, _ := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+			a := vm.NewAtom(vm.NIL)
+			runtime.SetFinalizer(a, func(*vm.Atom) { close(collected) })
+			return a, nil
+		})
+		use, _ := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+			return vm.TRUE, nil
+		})
+		check, _ := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+			for i := 0; i < 50; i++ {
+				runtime.GC()
+				select {
+				case <-collected:
+					return vm.TRUE, nil
+				case <-time.After(10 * time.Millisecond):
+				}
+			}
+			return vm.FALSE, nil
+		})
+		out, err := f.(vm.Fn).Invoke([]vm.Value{mk, use, check})
+		assert.NoError(t, err)
+		assert.Equal(t, vm.TRUE, out, src)
+	}
+}
+
+func TestContext_LazySeqsDontHoldHead(t *testing.T) {
+	tests := []string{
+		`(fn [mk check] (first (drop 100001 (cons (mk) (map check (iterate inc 0))))))`,
+		`(fn [mk check] (first (filter check (cons (mk) (iterate inc 0)))))`,
+		`(fn [mk check] (first (drop 100001 (take-while (fn [x] (not (check x))) (cons (mk) (iterate inc 0))))))`,
+		`(fn [mk check] (first (drop 100001 (take 200000 (map identity (cons (mk) (map check (iterate inc 0))))))))`,
+	}
+	for _, src := range tests {
+		f, err := Eval(src)
+		assert.NoError(t, err)
+
+		collected := make(chan struct{})
+		mk, _ := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+			a := vm.NewAtom(vm.NIL)
+			runtime.SetFinalizer(a, func(*vm.Atom) { close(collected) })
+			return a, nil
+		})
+		// check waits for the head of the seq to be collected once the walk gets far enough
+		ok := false
+		check, _ := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+			if vs[0] != vm.Int(100000) {
+				return vm.FALSE, nil
+			}
+			for i := 0; i < 50 && !ok; i++ {
+				runtime.GC()
+				select {
+				case <-collected:
+					ok = true
+				case <-time.After(10 * time.Millisecond):
+				}
+			}
+			return vm.TRUE, nil
+		})
+		_, err = f.(vm.Fn).Invoke([]vm.Value{mk, check})
+		assert.NoError(t, err)
+		assert.True(t, ok, src)
+	}
+}
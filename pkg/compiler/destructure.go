@@ -0,0 +1,215 @@
+/*
+ * Copyright (c) 2021 Marcin Gasperowicz <xnooga@gmail.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+ * documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit
+ * persons to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+ * Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+ * WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+ * OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package compiler
+
+import (
+	"fmt"
+	"github.com/nooga/let-go/pkg/rt"
+	"github.com/nooga/let-go/pkg/vm"
+	"sort"
+)
+
+// destructure expands binding pairs which may contain vector and map binding forms
+// into equivalent pairs binding plain symbols only
+func destructure(binds []vm.Value) ([]vm.Value, error) {
+	if len(binds)%2 != 0 {
+		return nil, NewCompileError("bindings must have even number of forms")
+	}
+	var out []vm.Value
+	var err error
+	for i := 0; i < len(binds); i += 2 {
+		out, err = destructurePair(out, binds[i], binds[i+1])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// isDestructuring checks if any of the binding names is a binding form
+func isDestructuring(binds []vm.Value) bool {
+	for i := 0; i < len(binds); i += 2 {
+		if binds[i].Type() != vm.SymbolType {
+			return true
+		}
+	}
+	return false
+}
+
+func destructurePair(out []vm.Value, binding vm.Value, value vm.Value) ([]vm.Value, error) {
+	switch b := binding.(type) {
+	case vm.Symbol:
+		if b == "&" {
+			return nil, NewCompileError("& can only be used in vector binding forms")
+		}
+		return append(out, b, value), nil
+	case *vm.MetaSymbol:
+		return destructurePair(out, b.Symbol(), value)
+	case vm.ArrayVector:
+		return destructureVector(out, b, value)
+	case *vm.PersistentVector:
+		vec, _ := vectorForm(b)
+		return destructureVector(out, vec, value)
+	case *vm.Map:
+		return destructureMap(out, b, value)
+	}
+	return nil, NewCompileError(fmt.Sprintf("unsupported binding form: %s", binding))
+}
+
+func destructureVector(out []vm.Value, binding vm.ArrayVector, value vm.Value) ([]vm.Value, error) {
+	vec := rt.Gensym("vec__")
+	out = append(out, vec, value)
+	n := 0
+	var err error
+	for i := 0; i < len(binding); i++ {
+		b := binding[i]
+		switch b {
+		case vm.Symbol("&"):
+			if i+1 >= len(binding) {
+				return nil, NewCompileError("missing binding form after &")
+			}
+			i++
+			out, err = destructurePair(out, binding[i], list(vm.Symbol("core/nthnext"), vec, vm.Int(n)))
+		case vm.Keyword("as"):
+			if i+1 >= len(binding) {
+				return nil, NewCompileError("missing symbol after :as")
+			}
+			i++
+			s, ok := vm.SymbolOf(binding[i])
+			if !ok {
+				return nil, NewCompileError(fmt.Sprintf(":as must be followed by a symbol, got %s", binding[i]))
+			}
+			out = append(out, s, vec)
+		default:
+			out, err = destructurePair(out, b, list(vm.Symbol("core/nth"), vec, vm.Int(n), vm.NIL))
+			n++
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func destructureMap(out []vm.Value, binding *vm.Map, value vm.Value) ([]vm.Value, error) {
+	m := rt.Gensym("map__")
+	// seqs of keys and values, like rest args, are turned into maps first
+	out = append(out, m, value,
+		m, list(vm.Symbol("if"), list(vm.Symbol("core/seq?"), m), list(vm.Symbol("core/apply"), vm.Symbol("core/hash-map"), m), m))
+
+	defaults := vm.EmptyMap
+	if binding.Contains(vm.Keyword("or")) {
+		or := binding.ValueAt(vm.Keyword("or"))
+		d, ok := or.(*vm.Map)
+		if !ok {
+			return nil, NewCompileError(fmt.Sprintf(":or must be followed by a map, got %s", or))
+		}
+		defaults = d
+	}
+	lookup := func(key vm.Value, local vm.Value) vm.Value {
+		if s, ok := vm.SymbolOf(local); ok {
+			if defaults.Contains(s) {
+				return list(vm.Symbol("core/get"), m, key, defaults.ValueAt(s))
+			}
+		}
+		return list(vm.Symbol("core/get"), m, key)
+	}
+
+	if binding.Contains(vm.Keyword("as")) {
+		as := binding.ValueAt(vm.Keyword("as"))
+		s, ok := vm.SymbolOf(as)
+		if !ok {
+			return nil, NewCompileError(fmt.Sprintf(":as must be followed by a symbol, got %s", as))
+		}
+		out = append(out, s, m)
+	}
+
+	for _, kind := range []vm.Keyword{"keys", "strs", "syms"} {
+		if !binding.Contains(kind) {
+			continue
+		}
+		names := binding.ValueAt(kind)
+		vec, ok := vectorForm(names)
+		if !ok {
+			return nil, NewCompileError(fmt.Sprintf(":%s must be followed by a vector, got %s", kind, names))
+		}
+		for _, n := range vec {
+			var name string
+			switch nv := n.(type) {
+			case vm.Symbol, *vm.MetaSymbol:
+				name = nv.String()
+			case vm.Keyword:
+				name = string(nv)
+			default:
+				return nil, NewCompileError(fmt.Sprintf(":%s names must be symbols, got %s", kind, n))
+			}
+			_, local := vm.Symbol(name).Namespaced()
+			var key vm.Value
+			switch kind {
+			case "keys":
+				key = vm.Keyword(name)
+			case "strs":
+				key = vm.String(name)
+			case "syms":
+				key = list(vm.Symbol("quote"), vm.Symbol(name))
+			}
+			out = append(out, local, lookup(key, local))
+		}
+	}
+
+	// remaining entries are binding forms mapped to keys, sort them to keep expansion stable
+	var forms []vm.Value
+	binding.Each(func(k vm.Value, _ vm.Value) {
+		switch k {
+		case vm.Keyword("or"), vm.Keyword("as"), vm.Keyword("keys"), vm.Keyword("strs"), vm.Keyword("syms"):
+			return
+		}
+		forms = append(forms, k)
+	})
+	sort.Slice(forms, func(i, j int) bool { return forms[i].String() < forms[j].String() })
+	var err error
+	for _, f := range forms {
+		out, err = destructurePair(out, f, lookup(binding.ValueAt(f), f))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// destructureParams replaces fn parameters which are binding forms with fresh symbols
+// and returns let bindings destructuring them
+func destructureParams(params []vm.Value) ([]vm.Value, []vm.Value) {
+	var binds []vm.Value
+	out := make([]vm.Value, len(params))
+	for i, p := range params {
+		if s, ok := vm.SymbolOf(p); ok {
+			out[i] = s
+			continue
+		}
+		s := rt.Gensym("p__")
+		out[i] = s
+		binds = append(binds, p, s)
+	}
+	return out, binds
+}
+
+func list(vs ...vm.Value) vm.Value {
+	l, _ := vm.ListType.Box(vs)
+	return l
+}
@@ -20,6 +20,7 @@ package compiler
 import (
 	"github.com/nooga/let-go/pkg/rt"
 	"github.com/nooga/let-go/pkg/vm"
+	"io"
 	"strings"
 )
 
@@ -41,7 +42,16 @@ func Eval(src string) (vm.Value, error) {
 	return out, nil
 }
 
+// loadSource compiles and evaluates forms read from r in the current namespace, rt uses it to load files
+func loadSource(source string, r io.Reader) error {
+	ctx := NewCompiler(rt.CurrentNS.Deref().(*vm.Namespace))
+	ctx.SetSource(source)
+	_, _, err := ctx.CompileMultiple(r)
+	return err
+}
+
 func evalInit() {
+	rt.Loader = loadSource
 	_, err := Eval(rt.CoreSrc)
 	if err != nil {
 		panic(err)
@@ -23,6 +23,9 @@ import (
 	"github.com/nooga/let-go/pkg/errors"
 	"github.com/nooga/let-go/pkg/rt"
 	"io"
+	"math"
+	"math/big"
+	"regexp"
 	"strconv"
 	"strings"
 	"unicode"
@@ -39,15 +42,28 @@ type LispReader struct {
 	lastCol   int
 	lastRune  rune
 	r         *bufio.Reader
+	positions map[*vm.List]vm.SourceInfo
+	gensyms   map[vm.Symbol]vm.Symbol
 }
 
 func NewLispReader(r io.Reader, inputName string) *LispReader {
 	return &LispReader{
 		inputName: inputName,
 		r:         bufio.NewReader(r),
+		positions: map[*vm.List]vm.SourceInfo{},
 	}
 }
 
+// SourceInfo returns the position at which a list form was read
+func (r *LispReader) SourceInfo(form vm.Value) (vm.SourceInfo, bool) {
+	l, ok := form.(*vm.List)
+	if !ok {
+		return vm.SourceInfo{}, false
+	}
+	info, ok := r.positions[l]
+	return info, ok
+}
+
 func (r *LispReader) next() (rune, error) {
 	c, _, err := r.r.ReadRune()
 	if err == nil {
@@ -142,13 +158,21 @@ func interpretToken(r *LispReader, t vm.Value) (vm.Value, error) {
 	if ss[0] == ':' {
 		nom := ss[1:]
 		if nom[0] == ':' {
-			// we've got a namespaced keyword
+			// we've got a namespaced keyword, ::kw is qualified with current namespace and ::alias/kw with the aliased one
 			onom := nom[1:]
-			if strings.ContainsAny(onom, ":/") {
+			cns := rt.CurrentNS.Deref().(*vm.Namespace)
+			parts := strings.SplitN(onom, "/", 2)
+			if len(parts) == 2 {
+				ns := cns.Alias(vm.Symbol(parts[0]))
+				if ns == nil {
+					return vm.NIL, NewReaderError(r, fmt.Sprintf("invalid token: %s, no namespace aliased as %s", ss, parts[0]))
+				}
+				cns, onom = ns, parts[1]
+			}
+			if onom == "" || strings.ContainsAny(onom, ":/") {
 				return vm.NIL, NewReaderError(r, fmt.Sprintf("invalid token: %s", ss))
 			}
-			// FIXME figure out if we want this here or rather  in the compiler
-			nom = rt.CurrentNS.Deref().(*vm.Namespace).Name() + "/" + onom
+			nom = cns.Name() + "/" + onom
 		}
 		if strings.ContainsAny(nom, ":") {
 			return vm.NIL, NewReaderError(r, fmt.Sprintf("invalid token: %s", ss))
@@ -344,14 +368,93 @@ func readNumber(r *LispReader, ru rune) (vm.Value, error) {
 		s.WriteRune(ch)
 	}
 	sn := s.String()
-	i, err := strconv.Atoi(sn)
+	return parseNumber(r, sn)
+}
+
+var (
+	intPattern   = regexp.MustCompile(`^([-+]?)(?:(0)|([1-9][0-9]*)|0[xX]([0-9A-Fa-f]+)|0([0-7]+)|([1-9][0-9]?)[rR]([0-9A-Za-z]+)|0[0-9]+)(N)?$`)
+	floatPattern = regexp.MustCompile(`^[-+]?[0-9]+(\.[0-9]*)?([eE][-+]?[0-9]+)?$`)
+	ratioPattern = regexp.MustCompile(`^([-+]?[0-9]+)/([0-9]+)$`)
+)
+
+// parseNumber parses integer, float and ratio literals, integers which don't fit in an Int are read as BigInts
+func parseNumber(r *LispReader, s string) (vm.Value, error) {
+	if m := intPattern.FindStringSubmatch(s); m != nil {
+		digits, base := "", 10
+		switch {
+		case m[2] != "":
+			digits = "0"
+		case m[3] != "":
+			digits = m[3]
+		case m[4] != "":
+			digits, base = m[4], 16
+		case m[5] != "":
+			digits, base = m[5], 8
+		case m[6] != "":
+			base, _ = strconv.Atoi(m[6])
+			digits = m[7]
+		default:
+			return vm.NIL, NewReaderError(r, fmt.Sprintf("invalid number %s: octal digits expected", s))
+		}
+		if base < 2 || base > 36 {
+			return vm.NIL, NewReaderError(r, fmt.Sprintf("invalid number %s: radix %d out of range", s, base))
+		}
+		n, ok := new(big.Int).SetString(digits, base)
+		if !ok {
+			return vm.NIL, NewReaderError(r, fmt.Sprintf("invalid number %s: bad digits for radix %d", s, base))
+		}
+		if m[1] == "-" {
+			n.Neg(n)
+		}
+		if m[8] != "" {
+			return vm.NewBigInt(n), nil
+		}
+		if n.IsInt64() && n.Int64() == int64(int(n.Int64())) {
+			return vm.Int(n.Int64()), nil
+		}
+		return vm.NewBigInt(n), nil
+	}
+	if floatPattern.MatchString(s) {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return vm.NIL, NewReaderError(r, fmt.Sprintf("invalid number %s", s)).Wrap(err)
+		}
+		return vm.Float(f), nil
+	}
+	if m := ratioPattern.FindStringSubmatch(s); m != nil {
+		num, _ := new(big.Int).SetString(m[1], 10)
+		den, _ := new(big.Int).SetString(m[2], 10)
+		if den.Sign() == 0 {
+			return vm.NIL, NewReaderError(r, fmt.Sprintf("invalid number %s: divide by zero", s))
+		}
+		return vm.NewRatio(new(big.Rat).SetFrac(num, den)), nil
+	}
+	return vm.NIL, NewReaderError(r, fmt.Sprintf("invalid number %s", s))
+}
+
+func readSymbolicValue(r *LispReader, _ rune) (vm.Value, error) {
+	ch, err := r.next()
 	if err != nil {
-		return vm.NIL, NewReaderError(r, "unexpected error").Wrap(err)
+		return vm.NIL, NewReaderError(r, "reading symbolic value").Wrap(err)
+	}
+	token, err := readToken(r, ch)
+	if err != nil {
+		return vm.NIL, err
 	}
-	return vm.Int(i), nil
+	switch token {
+	case vm.Symbol("Inf"):
+		return vm.Float(math.Inf(1)), nil
+	case vm.Symbol("-Inf"):
+		return vm.Float(math.Inf(-1)), nil
+	case vm.Symbol("NaN"):
+		return vm.Float(math.NaN()), nil
+	}
+	return vm.NIL, NewReaderError(r, fmt.Sprintf("unknown symbolic value ##%s", token))
 }
 
 func readList(r *LispReader, _ rune) (vm.Value, error) {
+	// opening paren was already consumed so column points at it
+	info := vm.SourceInfo{File: r.inputName, Line: r.line + 1, Column: r.column}
 	var ret []vm.Value
 	for {
 		ch2, err := r.eatWhitespace()
@@ -370,7 +473,14 @@ func readList(r *LispReader, _ rune) (vm.Value, error) {
 		}
 		ret = appendNonVoid(ret, form)
 	}
-	return vm.ListType.Box(ret)
+	l, err := vm.ListType.Box(ret)
+	if err != nil {
+		return vm.NIL, NewReaderError(r, "unexpected error").Wrap(err)
+	}
+	if l != vm.EmptyList {
+		r.positions[l.(*vm.List)] = info
+	}
+	return l, nil
 }
 
 func readVector(r *LispReader, _ rune) (vm.Value, error) {
@@ -417,7 +527,37 @@ func readMap(r *LispReader, _ rune) (vm.Value, error) {
 	if len(ret)%2 != 0 {
 		return vm.NIL, NewReaderError(r, "map literal must contain even number of forms")
 	}
-	return vm.NewMap(ret), nil
+	m, err := vm.NewMapLiteral(ret)
+	if err != nil {
+		return vm.NIL, NewReaderError(r, err.Error())
+	}
+	return m, nil
+}
+
+func readSet(r *LispReader, _ rune) (vm.Value, error) {
+	ret := make([]vm.Value, 0)
+	for {
+		ch2, err := r.eatWhitespace()
+		if err != nil {
+			return vm.NIL, NewReaderError(r, "unexpected error").Wrap(err)
+		}
+		if ch2 == '}' {
+			break
+		}
+		if err = r.unread(); err != nil {
+			return vm.NIL, NewReaderError(r, "unexpected error").Wrap(err)
+		}
+		form, err := r.Read()
+		if err != nil {
+			return vm.NIL, NewReaderError(r, "unexpected error").Wrap(err)
+		}
+		ret = appendNonVoid(ret, form)
+	}
+	s, err := vm.NewSetLiteral(ret)
+	if err != nil {
+		return vm.NIL, NewReaderError(r, err.Error())
+	}
+	return s, nil
 }
 
 func readQuote(r *LispReader, _ rune) (vm.Value, error) {
@@ -433,6 +573,201 @@ func readQuote(r *LispReader, _ rune) (vm.Value, error) {
 	return ret, nil
 }
 
+func readSyntaxQuote(r *LispReader, _ rune) (vm.Value, error) {
+	form, err := r.Read()
+	if err != nil {
+		return vm.NIL, NewReaderError(r, "reading syntax-quoted form").Wrap(err)
+	}
+	// auto-gensyms are consistent within a single syntax-quote
+	prev := r.gensyms
+	r.gensyms = map[vm.Symbol]vm.Symbol{}
+	defer func() { r.gensyms = prev }()
+	ret, err := syntaxQuote(r, form)
+	if err != nil {
+		return vm.NIL, NewReaderError(r, "expanding syntax-quote").Wrap(err)
+	}
+	return ret, nil
+}
+
+func readUnquote(r *LispReader, _ rune) (vm.Value, error) {
+	ch, err := r.next()
+	if err != nil {
+		return vm.NIL, NewReaderError(r, "reading unquote").Wrap(err)
+	}
+	unquote := vm.Symbol("unquote")
+	if ch == '@' {
+		unquote = "unquote-splicing"
+	} else if err = r.unread(); err != nil {
+		return vm.NIL, NewReaderError(r, "unexpected error").Wrap(err)
+	}
+	form, err := r.Read()
+	if err != nil {
+		return vm.NIL, NewReaderError(r, "reading unquoted form").Wrap(err)
+	}
+	return list(unquote, form), nil
+}
+
+func readDeref(r *LispReader, _ rune) (vm.Value, error) {
+	form, err := r.Read()
+	if err != nil {
+		return vm.NIL, NewReaderError(r, "reading dereferenced form").Wrap(err)
+	}
+	return list(vm.Symbol("core/deref"), form), nil
+}
+
+// syntaxQuote expands a syntax-quoted form into code constructing it
+func syntaxQuote(r *LispReader, form vm.Value) (vm.Value, error) {
+	switch f := form.(type) {
+	case vm.Symbol:
+		return list(vm.Symbol("quote"), syntaxQuoteSymbol(r, f)), nil
+	case *vm.MetaSymbol:
+		meta, err := syntaxQuote(r, f.Meta())
+		if err != nil {
+			return vm.NIL, err
+		}
+		return list(vm.Symbol("core/with-meta"), list(vm.Symbol("quote"), syntaxQuoteSymbol(r, f.Symbol())), meta), nil
+	case *vm.List:
+		if f == vm.EmptyList {
+			return list(vm.Symbol("core/list")), nil
+		}
+		if isUnquote(f, "unquote") {
+			return f.Next().First(), nil
+		}
+		if isUnquote(f, "unquote-splicing") {
+			return vm.NIL, NewReaderError(r, "unquote-splicing used outside of a collection")
+		}
+		items, err := syntaxQuoteItems(r, f.Unbox().([]vm.Value))
+		if err != nil {
+			return vm.NIL, err
+		}
+		return list(vm.Symbol("core/seq"), items), nil
+	case vm.ArrayVector:
+		items, err := syntaxQuoteItems(r, f)
+		if err != nil {
+			return vm.NIL, err
+		}
+		return list(vm.Symbol("core/apply"), vm.Symbol("core/vector"), items), nil
+	case *vm.Map:
+		kvs := make([]vm.Value, 0, f.RawCount()*2)
+		f.Each(func(k vm.Value, v vm.Value) {
+			kvs = append(kvs, k, v)
+		})
+		items, err := syntaxQuoteItems(r, kvs)
+		if err != nil {
+			return vm.NIL, err
+		}
+		return list(vm.Symbol("core/apply"), vm.Symbol("core/hash-map"), items), nil
+	case *vm.Set:
+		items, err := syntaxQuoteItems(r, f.Unbox().([]vm.Value))
+		if err != nil {
+			return vm.NIL, err
+		}
+		return list(vm.Symbol("core/apply"), vm.Symbol("core/hash-set"), items), nil
+	}
+	// everything else evaluates to itself
+	return form, nil
+}
+
+// syntaxQuoteItems builds a concat form out of syntax-quoted collection elements
+func syntaxQuoteItems(r *LispReader, forms []vm.Value) (vm.Value, error) {
+	items := []vm.Value{vm.Symbol("core/concat")}
+	for _, form := range forms {
+		if l, ok := form.(*vm.List); ok && isUnquote(l, "unquote-splicing") {
+			items = append(items, l.Next().First())
+			continue
+		}
+		item, err := syntaxQuote(r, form)
+		if err != nil {
+			return vm.NIL, err
+		}
+		items = append(items, list(vm.Symbol("core/list"), item))
+	}
+	return list(items...), nil
+}
+
+func isUnquote(l *vm.List, kind vm.Symbol) bool {
+	return l.RawCount() == 2 && l.First() == kind
+}
+
+// syntaxQuoteSymbol resolves a symbol to its namespace-qualified form,
+// special forms stay as they are and symbols ending with # become auto-gensyms
+func syntaxQuoteSymbol(r *LispReader, s vm.Symbol) vm.Symbol {
+	name := string(s)
+	if _, ok := specialForms[s]; ok {
+		return s
+	}
+	switch {
+	case name == "&" || name == "catch" || name == "finally":
+		return s
+	case strings.HasSuffix(name, "#") && !strings.ContainsRune(name, '/'):
+		g, ok := r.gensyms[s]
+		if !ok {
+			g = rt.Gensym(name[:len(name)-1]+"__") + "__auto__"
+			r.gensyms[s] = g
+		}
+		return g
+	case strings.HasPrefix(name, ".") || strings.HasSuffix(name, "."):
+		return s
+	}
+	cns := rt.CurrentNS.Deref().(*vm.Namespace)
+	if ns, sym := s.Namespaced(); ns != vm.NIL {
+		if aliased := cns.Alias(ns.(vm.Symbol)); aliased != nil {
+			return vm.Symbol(aliased.Name() + "/" + string(sym.(vm.Symbol)))
+		}
+		return s
+	}
+	if v, ok := cns.Lookup(s).(*vm.Var); ok {
+		return vm.Symbol(v.Namespace() + "/" + v.Name())
+	}
+	return vm.Symbol(cns.Name() + "/" + name)
+}
+
+// readMeta reads ^meta form and attaches the metadata to form, symbols with metadata become MetaSymbols
+func readMeta(r *LispReader, _ rune) (vm.Value, error) {
+	meta, err := r.Read()
+	if err != nil {
+		return vm.NIL, NewReaderError(r, "reading metadata").Wrap(err)
+	}
+	switch meta.Type() {
+	case vm.KeywordType:
+		meta, err = vm.NewMap([]vm.Value{meta, vm.TRUE})
+	case vm.SymbolType, vm.StringType:
+		meta, err = vm.NewMap([]vm.Value{vm.Keyword("tag"), meta})
+	case vm.MapType:
+	default:
+		return vm.NIL, NewReaderError(r, "metadata must be a symbol, keyword, string or map")
+	}
+	if err != nil {
+		return vm.NIL, NewReaderError(r, "reading metadata").Wrap(err)
+	}
+	form, err := r.Read()
+	if err != nil {
+		return vm.NIL, NewReaderError(r, "reading form with metadata").Wrap(err)
+	}
+	return withReadMeta(r, form, meta.(*vm.Map))
+}
+
+// withReadMeta attaches meta to a form which can carry metadata, metadata from nested ^ is merged
+func withReadMeta(r *LispReader, form vm.Value, meta *vm.Map) (vm.Value, error) {
+	o, ok := form.(vm.IObj)
+	if !ok {
+		return vm.NIL, NewReaderError(r, fmt.Sprintf("metadata can't be applied to %v", form))
+	}
+	var merged vm.Associative = meta
+	if prev := o.Meta(); prev != nil {
+		prev.Each(func(k vm.Value, v vm.Value) {
+			merged = merged.Assoc(k, v)
+		})
+	}
+	ret := o.WithMeta(merged.(*vm.Map))
+	if l, ok := form.(*vm.List); ok {
+		if info, ok := r.positions[l]; ok {
+			r.positions[ret.(*vm.List)] = info
+		}
+	}
+	return ret, nil
+}
+
 func readVarQuote(r *LispReader, _ rune) (vm.Value, error) {
 	form, err := r.Read()
 	if err != nil {
@@ -501,6 +836,10 @@ func readerInit() {
 		'"':  readString,
 		'\\': readChar,
 		'\'': readQuote,
+		'`':  readSyntaxQuote,
+		'~':  readUnquote,
+		'@':  readDeref,
+		'^':  readMeta,
 		';':  readLineComment,
 		'#':  readHashMacro,
 	}
@@ -508,6 +847,8 @@ func readerInit() {
 	hashMacros = map[rune]readerFunc{
 		'\'': readVarQuote,
 		'_':  readFormComment,
+		'#':  readSymbolicValue,
+		'{':  readSet,
 	}
 }
 
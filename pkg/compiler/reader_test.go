@@ -21,6 +21,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/nooga/let-go/pkg/rt"
 	"github.com/nooga/let-go/pkg/vm"
 	"github.com/stretchr/testify/assert"
 )
@@ -51,6 +52,7 @@ func TestReaderBasic(t *testing.T) {
 		"[]":                   vm.ArrayVector{},
 		"[1 :foo true]":        vm.ArrayVector{vm.Int(1), vm.Keyword("foo"), vm.TRUE},
 		"'foo":                 vm.EmptyList.Cons(vm.Symbol("foo")).Cons(vm.Symbol("quote")),
+		"@foo":                 vm.EmptyList.Cons(vm.Symbol("foo")).Cons(vm.Symbol("core/deref")),
 	}
 
 	for p, e := range cases {
@@ -61,6 +63,40 @@ func TestReaderBasic(t *testing.T) {
 	}
 }
 
+func TestReaderNumbers(t *testing.T) {
+	cases := map[string]string{
+		"1.5":                  "1.5",
+		"-2.0":                 "-2.0",
+		"1e3":                  "1000.0",
+		"1.5e-3":               "0.0015",
+		"0xff":                 "255",
+		"-0x10":                "-16",
+		"017":                  "15",
+		"2r1010":               "10",
+		"36rZZ":                "1295",
+		"1N":                   "1N",
+		"99999999999999999999": "99999999999999999999N",
+		"1/3":                  "1/3",
+		"-2/4":                 "-1/2",
+		"4/2":                  "2",
+		"##Inf":                "##Inf",
+		"##-Inf":               "##-Inf",
+		"##NaN":                "##NaN",
+	}
+	for p, e := range cases {
+		r := NewLispReader(strings.NewReader(p), "<reader>")
+		o, err := r.Read()
+		assert.NoError(t, err, p)
+		assert.Equal(t, e, o.String(), p)
+	}
+
+	for _, p := range []string{"1.2.3", "09", "1/0", "40r1", "2r3", "1x", "##Foo"} {
+		r := NewLispReader(strings.NewReader(p), "<reader>")
+		_, err := r.Read()
+		assert.Error(t, err, p)
+	}
+}
+
 func TestSimpleCall(t *testing.T) {
 	p := "(+ 40 2)"
 	r := NewLispReader(strings.NewReader(p), "<reader>")
@@ -76,3 +112,92 @@ func TestSimpleCall(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, out, o)
 }
+
+func TestReaderSyntaxQuote(t *testing.T) {
+	cases := map[string]string{
+		"`1":          "1",
+		"`:a":         ":a",
+		"`if":         "(quote if)",
+		"`~x":         "x",
+		"`()":         "(core/list)",
+		"`(a ~b ~@c)": "(core/seq (core/concat (core/list (quote user/a)) (core/list b) c))",
+		"`[~@c]":      "(core/apply core/vector (core/concat c))",
+		"`first":      "(quote core/first)",
+		"`foo/bar":    "(quote foo/bar)",
+		"`.method":    "(quote .method)",
+	}
+
+	// *ns* is dynamic, readers outside of compilation see its thread binding
+	assert.NoError(t, vm.PushBindings(map[*vm.Var]vm.Value{rt.CurrentNS: rt.NS("user")}))
+	defer func() { assert.NoError(t, vm.PopBindings()) }()
+	for p, e := range cases {
+		r := NewLispReader(strings.NewReader(p), "<reader>")
+		o, err := r.Read()
+		assert.NoError(t, err)
+		assert.Equal(t, e, o.String())
+	}
+
+	r := NewLispReader(strings.NewReader("`(x# x#)"), "<reader>")
+	o, err := r.Read()
+	assert.NoError(t, err)
+	items := o.(*vm.List).Next().First().(*vm.List).Next()
+	a := items.First().(*vm.List).Next().First()
+	b := items.Next().First().(*vm.List).Next().First()
+	assert.Equal(t, a, b)
+
+	r = NewLispReader(strings.NewReader("`~@x"), "<reader>")
+	_, err = r.Read()
+	assert.Error(t, err)
+}
+
+func TestReaderSymbolMeta(t *testing.T) {
+	r := NewLispReader(strings.NewReader("(def ^:dynamic ^{:doc \"x\"} *x* ^String y)"), "<reader>")
+	o, err := r.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, "(def *x* y)", o.String())
+	vs := o.(*vm.List).Unbox().([]vm.Value)
+	meta := vm.MetaOf(vs[1]).(vm.Lookup)
+	assert.Equal(t, vm.TRUE, meta.ValueAt(vm.Keyword("dynamic")))
+	assert.Equal(t, vm.String("x"), meta.ValueAt(vm.Keyword("doc")))
+	assert.Equal(t, vm.Symbol("String"), vm.MetaOf(vs[2]).(vm.Lookup).ValueAt(vm.Keyword("tag")))
+	assert.Equal(t, vm.NIL, vm.MetaOf(vs[0]))
+	assert.True(t, vm.Equals(vs[1], vm.Symbol("*x*")))
+}
+
+func TestReaderCollectionMeta(t *testing.T) {
+	r := NewLispReader(strings.NewReader("(f ^:a ^{:b 1} [x] ^Foo {:k :v} ^:c (g))"), "<reader>")
+	o, err := r.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, "(f [x] {:k :v} (g))", o.String())
+	vs := o.(*vm.List).Unbox().([]vm.Value)
+	meta := vs[1].(vm.IMeta).Meta()
+	assert.Equal(t, vm.TRUE, meta.ValueAt(vm.Keyword("a")))
+	assert.Equal(t, vm.Int(1), meta.ValueAt(vm.Keyword("b")))
+	assert.Equal(t, vm.Symbol("Foo"), vs[2].(vm.IMeta).Meta().ValueAt(vm.Keyword("tag")))
+	assert.Equal(t, vm.TRUE, vs[3].(vm.IMeta).Meta().ValueAt(vm.Keyword("c")))
+	_, ok := r.SourceInfo(vs[3])
+	assert.True(t, ok)
+
+	_, err = NewLispReader(strings.NewReader("^:a 1"), "<reader>").Read()
+	assert.Error(t, err)
+}
+
+func TestReaderSet(t *testing.T) {
+	o, err := NewLispReader(strings.NewReader("#{1 :a #{}}"), "<reader>").Read()
+	assert.NoError(t, err)
+	s, ok := o.(*vm.Set)
+	assert.True(t, ok)
+	assert.Equal(t, 3, s.RawCount())
+	assert.True(t, s.Contains(vm.Keyword("a")))
+	assert.True(t, s.Contains(vm.EmptySet))
+
+	_, err = NewLispReader(strings.NewReader("#{1 2 1}"), "<reader>").Read()
+	assert.Error(t, err)
+}
+
+func TestReaderDuplicateKeys(t *testing.T) {
+	for _, src := range []string{"#{1 1}", "#{[1] (1)}", "{1 :a 1 :b}", "{[1] :a (1) :b}"} {
+		_, err := NewLispReader(strings.NewReader(src), "<reader>").Read()
+		assert.Error(t, err, src)
+	}
+}
@@ -0,0 +1,215 @@
+/*
+ * Copyright (c) 2021 Marcin Gasperowicz <xnooga@gmail.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+ * documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit
+ * persons to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+ * Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+ * WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+ * OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package rt
+
+import (
+	"fmt"
+	"github.com/nooga/let-go/pkg/vm"
+	"time"
+)
+
+func chanArg(name string, v vm.Value) (*vm.Chan, error) {
+	c, ok := v.(*vm.Chan)
+	if !ok {
+		return nil, vm.NewTypeError(v, "is not a channel in "+name, vm.ChanType)
+	}
+	return c, nil
+}
+
+func bufferFn(name string, policy vm.BufferPolicy) (vm.Value, error) {
+	return vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError(name, len(vs))
+		}
+		n, err := countArg(name, vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		return vm.NewBuffer(n, policy), nil
+	})
+}
+
+// chanOps turns alts!! ports into channel ops, ports are channels to take from or [channel value] pairs to put
+func chanOps(ports vm.Value) ([]vm.ChanOp, error) {
+	vs, err := seqValues(ports)
+	if err != nil {
+		return nil, err
+	}
+	ops := make([]vm.ChanOp, len(vs))
+	for i := range vs {
+		if c, ok := vs[i].(*vm.Chan); ok {
+			ops[i] = vm.ChanOp{Chan: c}
+			continue
+		}
+		pv, err := seqValues(vs[i])
+		if err != nil || len(pv) != 2 {
+			return nil, vm.NewTypeError(vs[i], "is not a valid alts!! port", nil)
+		}
+		c, err := chanArg("alts!!", pv[0])
+		if err != nil {
+			return nil, err
+		}
+		ops[i] = vm.ChanOp{Chan: c, Put: true, Val: pv[1]}
+	}
+	return ops, nil
+}
+
+// installAsyncFns defines channels and functions operating on them in the core namespace
+//
+//nolint
+func installAsyncFns(ns *vm.Namespace) {
+	chanf, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		switch len(vs) {
+		case 0:
+			return vm.NewChan(nil), nil
+		case 1:
+			if b, ok := vs[0].(*vm.Buffer); ok {
+				return vm.NewChan(b), nil
+			}
+			if vs[0] == vm.NIL {
+				return vm.NewChan(nil), nil
+			}
+			n, err := countArg("chan", vs[0])
+			if err != nil {
+				return vm.NIL, err
+			}
+			return vm.NewChan(vm.NewBuffer(n, vm.BlockingBuffer)), nil
+		}
+		return vm.NIL, arityError("chan", len(vs))
+	})
+
+	buffer, err := bufferFn("buffer", vm.BlockingBuffer)
+	droppingBuffer, err := bufferFn("dropping-buffer", vm.DroppingBuffer)
+	slidingBuffer, err := bufferFn("sliding-buffer", vm.SlidingBuffer)
+
+	put, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 2 {
+			return vm.NIL, arityError(">!!", len(vs))
+		}
+		c, err := chanArg(">!!", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		ok, err := c.Put(vs[1])
+		return vm.Boolean(ok), err
+	})
+
+	take, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("<!!", len(vs))
+		}
+		c, err := chanArg("<!!", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		return c.Take()
+	})
+
+	closef, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("close!", len(vs))
+		}
+		c, err := chanArg("close!", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		return vm.NIL, c.Close()
+	})
+
+	timeout, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("timeout", len(vs))
+		}
+		ms, ok := vs[0].(vm.Int)
+		if !ok {
+			return vm.NIL, vm.NewTypeError(vs[0], "can't be used as milliseconds in timeout", vm.IntType)
+		}
+		return vm.NewTimeout(time.Duration(ms) * time.Millisecond), nil
+	})
+
+	alts, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) < 1 || len(vs)%2 != 1 {
+			return vm.NIL, arityError("alts!!", len(vs))
+		}
+		ops, err := chanOps(vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		priority := false
+		var dflt vm.Value
+		for i := 1; i < len(vs); i += 2 {
+			switch vs[i] {
+			case vm.Keyword("priority"):
+				priority = vm.IsTruthy(vs[i+1])
+			case vm.Keyword("default"):
+				dflt = vs[i+1]
+			}
+		}
+		val, idx, err := vm.Alts(ops, priority, dflt == nil)
+		if err != nil {
+			return vm.NIL, err
+		}
+		if idx < 0 {
+			return vm.NewPersistentVector([]vm.Value{dflt, vm.Keyword("default")}), nil
+		}
+		return vm.NewPersistentVector([]vm.Value{val, ops[idx].Chan}), nil
+	})
+
+	gof, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("go*", len(vs))
+		}
+		f, err := fnArg("go*", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		c := vm.NewChan(vm.NewBuffer(1, vm.BlockingBuffer))
+		body := vm.BoundFn(f)
+		go func() {
+			defer func() { _ = c.Close() }()
+			ret, err := body.Invoke([]vm.Value{})
+			if err != nil {
+				// there is nobody to catch errors thrown in go blocks
+				if w, werr := writer(Err); werr == nil {
+					fmt.Fprintln(w, "exception in go block:", err)
+				}
+				return
+			}
+			if ret != vm.NIL {
+				_, _ = c.Put(ret)
+			}
+		}()
+		return c, nil
+	})
+
+	if err != nil {
+		panic("async fns init failed")
+	}
+
+	ns.Def("Chan", vm.ChanType)
+	ns.Def("chan", chanf)
+	ns.Def("buffer", buffer)
+	ns.Def("dropping-buffer", droppingBuffer)
+	ns.Def("sliding-buffer", slidingBuffer)
+	ns.Def(">!!", put)
+	ns.Def("<!!", take)
+	ns.Def("close!", closef)
+	ns.Def("timeout", timeout)
+	ns.Def("alts!!", alts)
+	ns.Def("go*", gof)
+}
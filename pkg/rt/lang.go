@@ -21,11 +21,16 @@ import (
 	_ "embed"
 	"fmt"
 	"github.com/nooga/let-go/pkg/vm"
+	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// nsRegistry holds all namespaces by name, it is guarded by nsRegistryMu
 var nsRegistry map[string]*vm.Namespace
+var nsRegistryMu sync.RWMutex
 
 func init() {
 	nsRegistry = make(map[string]*vm.Namespace)
@@ -38,18 +43,33 @@ func NS(name string) *vm.Namespace {
 }
 
 func RegisterNS(namespace *vm.Namespace) *vm.Namespace {
+	nsRegistryMu.Lock()
+	defer nsRegistryMu.Unlock()
 	nsRegistry[namespace.Name()] = namespace
 	return namespace
 }
 
+// FindNS returns a registered namespace or nil if there is none with given name
+func FindNS(name string) *vm.Namespace {
+	nsRegistryMu.RLock()
+	defer nsRegistryMu.RUnlock()
+	return nsRegistry[name]
+}
+
 func LookupOrRegisterNS(name string) *vm.Namespace {
+	if e := FindNS(name); e != nil {
+		return e
+	}
+	nsRegistryMu.Lock()
+	defer nsRegistryMu.Unlock()
 	e := nsRegistry[name]
 	if e != nil {
 		return e
 	}
-	nsRegistry[name] = vm.NewNamespace(name)
-	nsRegistry[name].Refer(CoreNS, "", true)
-	return nsRegistry[name]
+	e = vm.NewNamespace(name)
+	e.Refer(CoreNS, "", true)
+	nsRegistry[name] = e
+	return e
 }
 
 //go:embed core/core.lg
@@ -58,337 +78,706 @@ var CoreSrc string
 const NameCoreNS = "core"
 
 var CoreNS *vm.Namespace
+
+// CurrentNS, Out and Err are dynamic vars holding the current namespace and writers used for printing
 var CurrentNS *vm.Var
+var Out *vm.Var
+var Err *vm.Var
 
-var gensymID = 0
+var gensymID int64
 
 func nextID() int {
-	gensymID++
-	return gensymID
+	return int(atomic.AddInt64(&gensymID, 1))
+}
+
+// seqValues collects elements of a sequential value, nil is treated as empty
+func seqValues(v vm.Value) ([]vm.Value, error) {
+	switch coll := v.(type) {
+	case vm.ArrayVector:
+		return coll, nil
+	case *vm.PersistentVector:
+		return coll.Unbox().([]vm.Value), nil
+	}
+	return vm.SeqValues(v)
+}
+
+// nameParts joins optional namespace and name given to symbol or keyword
+func nameParts(fname string, vs []vm.Value) (string, error) {
+	parts := make([]string, 0, len(vs))
+	for i := range vs {
+		switch v := vs[i].(type) {
+		case vm.String:
+			parts = append(parts, string(v))
+		case vm.Symbol, *vm.MetaSymbol:
+			sym, _ := vm.SymbolOf(v)
+			parts = append(parts, string(sym))
+		case vm.Keyword:
+			parts = append(parts, string(v))
+		case *vm.Nil:
+			if i == 0 && len(vs) == 2 {
+				continue
+			}
+			return "", vm.NewTypeError(v, "can't be used as a name in "+fname, vm.StringType)
+		default:
+			return "", vm.NewTypeError(v, "can't be used as a name in "+fname, vm.StringType)
+		}
+	}
+	return strings.Join(parts, "/"), nil
+}
+
+// Gensym returns a fresh symbol starting with prefix
+func Gensym(prefix string) vm.Symbol {
+	return vm.Symbol(fmt.Sprintf("%s%d", prefix, nextID()))
+}
+
+func arityError(name string, n int) error {
+	return vm.NewExecutionError(fmt.Sprintf("wrong number of arguments (%d) passed to %s", n, name))
+}
+
+// foldNumbers applies op to acc and each of vs from left to right
+func foldNumbers(acc vm.Value, vs []vm.Value, op func(vm.Value, vm.Value) (vm.Value, error)) (vm.Value, error) {
+	for i := range vs {
+		var err error
+		acc, err = op(acc, vs[i])
+		if err != nil {
+			return vm.NIL, err
+		}
+	}
+	return acc, nil
+}
+
+// compareNumbers makes a variadic comparison fn checking pred for each pair of consecutive args
+func compareNumbers(name string, pred func(int) bool) (vm.Value, error) {
+	return vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) < 1 {
+			return vm.NIL, arityError(name, len(vs))
+		}
+		for i := 0; i < len(vs)-1; i++ {
+			c, ok, err := vm.Compare(vs[i], vs[i+1])
+			if err != nil {
+				return vm.NIL, err
+			}
+			if !ok || !pred(c) {
+				return vm.FALSE, nil
+			}
+		}
+		return vm.TRUE, nil
+	})
 }
 
 //nolint
 func installLangNS() {
-	plus, err := vm.NativeFnType.Wrap(func(vs []vm.Value) vm.Value {
-		n := 0
-		for i := range vs {
-			n += vs[i].Unbox().(int)
-		}
-		return vm.Int(n)
+	plus, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		return foldNumbers(vm.Int(0), vs, vm.Add)
 	})
 
-	mul, err := vm.NativeFnType.Wrap(func(vs []vm.Value) vm.Value {
-		n := 1
-		for i := range vs {
-			n *= vs[i].Unbox().(int)
-		}
-		return vm.Int(n)
+	plusP, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		return foldNumbers(vm.Int(0), vs, vm.AddP)
+	})
+
+	mul, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		return foldNumbers(vm.Int(1), vs, vm.Mul)
 	})
 
-	sub, err := vm.NativeFnType.Wrap(func(vs []vm.Value) vm.Value {
+	mulP, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		return foldNumbers(vm.Int(1), vs, vm.MulP)
+	})
+
+	sub, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
 		if len(vs) < 1 {
-			// FIXME error out
-			return vm.NIL
+			return vm.NIL, arityError("-", len(vs))
 		}
-		n := vs[0].Unbox().(int)
 		if len(vs) == 1 {
-			// FIXME error out
-			return vm.Int(-n)
+			return vm.Sub(vm.Int(0), vs[0])
 		}
-		for i := 1; i < len(vs); i++ {
-			n -= vs[i].Unbox().(int)
+		return foldNumbers(vs[0], vs[1:], vm.Sub)
+	})
+
+	subP, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) < 1 {
+			return vm.NIL, arityError("-'", len(vs))
+		}
+		if len(vs) == 1 {
+			return vm.SubP(vm.Int(0), vs[0])
 		}
-		return vm.Int(n)
+		return foldNumbers(vs[0], vs[1:], vm.SubP)
 	})
 
-	div, err := vm.NativeFnType.Wrap(func(vs []vm.Value) vm.Value {
-		n := 0
+	div, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
 		if len(vs) < 1 {
-			// FIXME error out
-			return vm.NIL
+			return vm.NIL, arityError("/", len(vs))
 		}
-		for i := range vs {
-			n /= vs[i].Unbox().(int)
+		if len(vs) == 1 {
+			return vm.Div(vm.Int(1), vs[0])
 		}
-		return vm.Int(n)
+		return foldNumbers(vs[0], vs[1:], vm.Div)
 	})
 
-	equals, err := vm.NativeFnType.Wrap(func(vs []vm.Value) vm.Value {
+	equals, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
 		length := len(vs)
 		if length < 1 {
-			// FIXME error out
-			return vm.NIL
+			return vm.NIL, arityError("=", length)
 		}
 
 		for i := 1; i < length; i++ {
-			if vs[0] != vs[i] {
-				return vm.FALSE
+			if !vm.Equals(vs[0], vs[i]) {
+				return vm.FALSE, nil
 			}
 		}
-		return vm.TRUE
+		return vm.TRUE, nil
 	})
 
-	gt, err := vm.NativeFnType.Wrap(func(vs []vm.Value) vm.Value {
-		if len(vs) != 2 {
-			// FIXME error out
-			return vm.NIL
+	notEquals, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		length := len(vs)
+		if length < 1 {
+			return vm.NIL, arityError("not=", length)
 		}
-		ret, err := vm.BooleanType.Box(vs[0].Unbox().(int) > vs[1].Unbox().(int))
-		if err != nil {
-			// FIXME error out
-			return vm.NIL
+		for i := 1; i < length; i++ {
+			if !vm.Equals(vs[0], vs[i]) {
+				return vm.TRUE, nil
+			}
 		}
-		return ret
+		return vm.FALSE, nil
 	})
 
-	lt, err := vm.NativeFnType.Wrap(func(vs []vm.Value) vm.Value {
-		if len(vs) != 2 {
-			// FIXME error out
-			return vm.NIL
+	hash, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("hash", len(vs))
 		}
-		ret, err := vm.BooleanType.Box(vs[0].Unbox().(int) < vs[1].Unbox().(int))
-		if err != nil {
-			// FIXME error out
-			return vm.NIL
+		return vm.Int(vm.Hash(vs[0])), nil
+	})
+
+	gt, err := compareNumbers(">", func(c int) bool { return c > 0 })
+	gte, err := compareNumbers(">=", func(c int) bool { return c >= 0 })
+	lt, err := compareNumbers("<", func(c int) bool { return c < 0 })
+	lte, err := compareNumbers("<=", func(c int) bool { return c <= 0 })
+	numEquals, err := compareNumbers("==", func(c int) bool { return c == 0 })
+
+	isNumber, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("number?", len(vs))
+		}
+		return vm.Boolean(vm.IsNumber(vs[0])), nil
+	})
+
+	isInteger, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("integer?", len(vs))
 		}
-		return ret
+		t := vs[0].Type()
+		return vm.Boolean(t == vm.IntType || t == vm.BigIntType), nil
 	})
 
-	and, err := vm.NativeFnType.Wrap(func(vs []vm.Value) vm.Value {
+	isFloat, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("float?", len(vs))
+		}
+		return vm.Boolean(vs[0].Type() == vm.FloatType), nil
+	})
+
+	isRatio, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("ratio?", len(vs))
+		}
+		return vm.Boolean(vs[0].Type() == vm.RatioType), nil
+	})
+
+	double, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("double", len(vs))
+		}
+		f, ok := vm.ToFloat(vs[0])
+		if !ok {
+			return vm.NIL, vm.NewTypeError(vs[0], "can't be converted to", vm.FloatType)
+		}
+		return vm.Float(f), nil
+	})
+
+	bigint, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("bigint", len(vs))
+		}
+		n, ok := vm.ToBigInt(vs[0])
+		if !ok {
+			return vm.NIL, vm.NewTypeError(vs[0], "can't be converted to", vm.BigIntType)
+		}
+		return n, nil
+	})
+
+	numerator, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("numerator", len(vs))
+		}
+		r, ok := vs[0].(*vm.Ratio)
+		if !ok {
+			return vm.NIL, vm.NewTypeError(vs[0], "is not a", vm.RatioType)
+		}
+		return r.Numerator(), nil
+	})
+
+	denominator, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("denominator", len(vs))
+		}
+		r, ok := vs[0].(*vm.Ratio)
+		if !ok {
+			return vm.NIL, vm.NewTypeError(vs[0], "is not a", vm.RatioType)
+		}
+		return r.Denominator(), nil
+	})
+
+	and, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
 		var ret vm.Value = vm.TRUE
 		if len(vs) == 1 {
-			return vs[0]
+			return vs[0], nil
 		}
 		for i := range vs {
 			if !vm.IsTruthy(vs[i]) {
-				return vs[i]
+				return vs[i], nil
 			}
 			ret = vs[i]
 		}
-		return ret
+		return ret, nil
 	})
 
-	or, err := vm.NativeFnType.Wrap(func(vs []vm.Value) vm.Value {
+	or, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
 		var ret vm.Value = vm.NIL
 		if len(vs) == 1 {
-			return vs[0]
+			return vs[0], nil
 		}
 		for i := range vs {
 			if vm.IsTruthy(vs[i]) {
-				return vs[i]
+				return vs[i], nil
 			}
 			ret = vs[i]
 		}
-		return ret
+		return ret, nil
 	})
 
-	not, err := vm.NativeFnType.Wrap(func(vs []vm.Value) vm.Value {
+	not, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
 		if len(vs) != 1 {
-			// FIXME this is an error
-			return vm.NIL
+			return vm.NIL, arityError("not", len(vs))
 		}
-		return vm.Boolean(!vm.IsTruthy(vs[0]))
+		return vm.Boolean(!vm.IsTruthy(vs[0])), nil
 	})
 
-	setMacro, err := vm.NativeFnType.Wrap(func(vs []vm.Value) vm.Value {
+	setMacro, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
 		if len(vs) != 1 {
-			// FIXME error out
-			return vm.NIL
+			return vm.NIL, arityError("set-macro!", len(vs))
+		}
+		m, ok := vs[0].(*vm.Var)
+		if !ok {
+			return vm.NIL, vm.NewTypeError(vs[0], "is not a Var", nil)
 		}
-		m := vs[0].(*vm.Var)
 		m.SetMacro()
-		return m
+		return m, nil
 	})
 
-	gensym, err := vm.NativeFnType.Wrap(func(vs []vm.Value) vm.Value {
+	str, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		b := &strings.Builder{}
+		for i := range vs {
+			switch v := vs[i].(type) {
+			case vm.String:
+				b.WriteString(string(v))
+			case vm.Char:
+				b.WriteRune(rune(v))
+			case *vm.Nil:
+			default:
+				b.WriteString(v.String())
+			}
+		}
+		return vm.String(b.String()), nil
+	})
+
+	symbol, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) < 1 || len(vs) > 2 {
+			return vm.NIL, arityError("symbol", len(vs))
+		}
+		parts, err := nameParts("symbol", vs)
+		if err != nil {
+			return vm.NIL, err
+		}
+		return vm.Symbol(parts), nil
+	})
+
+	keyword, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) < 1 || len(vs) > 2 {
+			return vm.NIL, arityError("keyword", len(vs))
+		}
+		parts, err := nameParts("keyword", vs)
+		if err != nil {
+			return vm.NIL, err
+		}
+		return vm.Keyword(parts), nil
+	})
+
+	name, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("name", len(vs))
+		}
+		var n string
+		switch v := vs[0].(type) {
+		case vm.String:
+			return v, nil
+		case vm.Symbol, *vm.MetaSymbol:
+			sym, _ := vm.SymbolOf(v)
+			n = string(sym)
+		case vm.Keyword:
+			n = string(v)
+		default:
+			return vm.NIL, vm.NewTypeError(vs[0], "has no name", nil)
+		}
+		if i := strings.IndexByte(n, '/'); i > 0 && i < len(n)-1 {
+			n = n[i+1:]
+		}
+		return vm.String(n), nil
+	})
+
+	gensym, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
 		prefix := "G__"
+		if len(vs) > 1 {
+			return vm.NIL, arityError("gensym", len(vs))
+		}
 		if len(vs) == 1 {
 			arg, ok := vs[0].(vm.String)
 			if !ok {
-				// FIXME :P
-				return vm.NIL
+				return vm.NIL, vm.NewTypeError(vs[0], "can't be used as gensym prefix", vm.StringType)
 			}
 			prefix = string(arg)
 		}
-		return vm.Symbol(fmt.Sprintf("%s%d", prefix, nextID()))
+		return Gensym(prefix), nil
+	})
+
+	vector, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		return vm.NewPersistentVector(vs), nil
+	})
+
+	vec, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("vec", len(vs))
+		}
+		if v, ok := vs[0].(*vm.PersistentVector); ok {
+			return v, nil
+		}
+		elems, err := seqValues(vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		return vm.NewPersistentVector(elems), nil
 	})
 
-	vector, err := vm.NativeFnType.Wrap(vm.NewArrayVector)
+	conj, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) == 0 {
+			return vm.EmptyPersistentVector, nil
+		}
+		var ret vm.Value = vs[0]
+		for _, x := range vs[1:] {
+			switch coll := ret.(type) {
+			case *vm.PersistentVector:
+				ret = coll.Conj(x)
+			case vm.ArrayVector:
+				ret = vm.NewPersistentVector(coll).Conj(x)
+			case *vm.Map:
+				entry, ok := x.(vm.Seq)
+				if !ok {
+					return vm.NIL, vm.NewTypeError(x, "can't be conjoined to a map", nil)
+				}
+				ret = coll.Assoc(entry.First(), entry.Next().First())
+			case *vm.Set:
+				ret = coll.Conj(x)
+			case vm.Seq:
+				ret = coll.Cons(x)
+			default:
+				if ret != vm.NIL {
+					return vm.NIL, vm.NewTypeError(ret, "conj not supported on this type", nil)
+				}
+				ret = vm.EmptyList.Cons(x)
+			}
+		}
+		return ret, nil
+	})
+
+	pop, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("pop", len(vs))
+		}
+		switch coll := vs[0].(type) {
+		case *vm.PersistentVector:
+			return coll.Pop()
+		case vm.ArrayVector:
+			return vm.NewPersistentVector(coll).Pop()
+		case *vm.List:
+			if coll == vm.EmptyList {
+				return vm.NIL, vm.NewExecutionError("can't pop empty list")
+			}
+			return coll.More(), nil
+		}
+		if vs[0] == vm.NIL {
+			return vm.NIL, nil
+		}
+		return vm.NIL, vm.NewTypeError(vs[0], "pop not supported on this type", nil)
+	})
+
+	peek, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("peek", len(vs))
+		}
+		switch coll := vs[0].(type) {
+		case *vm.PersistentVector:
+			return coll.Peek(), nil
+		case vm.ArrayVector:
+			if len(coll) == 0 {
+				return vm.NIL, nil
+			}
+			return coll[len(coll)-1], nil
+		case *vm.List:
+			return coll.First(), nil
+		}
+		if vs[0] == vm.NIL {
+			return vm.NIL, nil
+		}
+		return vm.NIL, vm.NewTypeError(vs[0], "peek not supported on this type", nil)
+	})
 	list, err := vm.NativeFnType.Wrap(vm.NewList)
 	hashMap, err := vm.NativeFnType.Wrap(vm.NewMap)
 
-	assoc, err := vm.NativeFnType.Wrap(func(vs []vm.Value) vm.Value {
+	assoc, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
 		if len(vs) != 3 {
-			// FIXME error out
-			return vm.NIL
+			return vm.NIL, arityError("assoc", len(vs))
+		}
+		switch coll := vs[0].(type) {
+		case *vm.PersistentVector, vm.ArrayVector:
+			i, ok := vs[1].(vm.Int)
+			if !ok {
+				return vm.NIL, vm.NewTypeError(vs[1], "can't be used as a vector index", vm.IntType)
+			}
+			if av, ok := coll.(vm.ArrayVector); ok {
+				return vm.NewPersistentVector(av).AssocN(int(i), vs[2])
+			}
+			return coll.(*vm.PersistentVector).AssocN(int(i), vs[2])
+		case *vm.Nil:
+			return vm.EmptyMap.Assoc(vs[1], vs[2]), nil
 		}
 		seq, ok := vs[0].(vm.Associative)
 		if !ok {
-			// FIXME make this an error (we need to handle exceptions first)
-			return vm.NIL
+			return vm.NIL, vm.NewTypeError(vs[0], "is not associative", nil)
 		}
-		return seq.Assoc(vs[1], vs[2])
+		return seq.Assoc(vs[1], vs[2]), nil
 	})
 
-	dissoc, err := vm.NativeFnType.Wrap(func(vs []vm.Value) vm.Value {
+	dissoc, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
 		if len(vs) != 2 {
-			// FIXME error out
-			return vm.NIL
+			return vm.NIL, arityError("dissoc", len(vs))
 		}
 		seq, ok := vs[0].(vm.Associative)
 		if !ok {
-			// FIXME make this an error (we need to handle exceptions first)
-			return vm.NIL
+			return vm.NIL, vm.NewTypeError(vs[0], "is not associative", nil)
 		}
 		key := vs[1]
-		return seq.Dissoc(key)
+		return seq.Dissoc(key), nil
 	})
 
-	cons, err := vm.NativeFnType.Wrap(func(vs []vm.Value) vm.Value {
+	cons, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
 		if len(vs) != 2 {
-			// FIXME error out
-			return vm.NIL
+			return vm.NIL, arityError("cons", len(vs))
 		}
 		elem := vs[0]
+		if vs[1] == vm.NIL {
+			return vm.EmptyList.Cons(elem), nil
+		}
 		seq, ok := vs[1].(vm.Seq)
 		if !ok {
-			// FIXME make this an error (we need to handle exceptions first)
-			return vm.NIL
+			return vm.NIL, vm.NewTypeError(vs[1], "is not a sequence", nil)
 		}
-		return seq.Cons(elem)
+		return seq.Cons(elem), nil
 	})
 
-	first, err := vm.NativeFnType.Wrap(func(vs []vm.Value) vm.Value {
+	first, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
 		if len(vs) != 1 {
-			// FIXME error out
-			return vm.NIL
+			return vm.NIL, arityError("first", len(vs))
 		}
-		seq, ok := vs[0].(vm.Seq)
-		if !ok {
-			// FIXME make this an error (we need to handle exceptions first)
-			return vm.NIL
+		seq, err := vm.ToSeq(vs[0])
+		if err != nil {
+			return vm.NIL, err
 		}
-		return seq.First()
+		return seq.First(), nil
 	})
 
-	second, err := vm.NativeFnType.Wrap(func(vs []vm.Value) vm.Value {
+	second, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
 		if len(vs) != 1 {
-			// FIXME error out
-			return vm.NIL
+			return vm.NIL, arityError("second", len(vs))
 		}
-		seq, ok := vs[0].(vm.Seq)
-		if !ok {
-			// FIXME make this an error (we need to handle exceptions first)
-			return vm.NIL
+		seq, err := vm.ToSeq(vs[0])
+		if err != nil {
+			return vm.NIL, err
 		}
-		return seq.Next().First()
+		return seq.Next().First(), nil
 	})
 
-	next, err := vm.NativeFnType.Wrap(func(vs []vm.Value) vm.Value {
+	next, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
 		if len(vs) != 1 {
-			// FIXME error out
-			return vm.NIL
+			return vm.NIL, arityError("next", len(vs))
 		}
-		seq, ok := vs[0].(vm.Seq)
-		if !ok {
-			// FIXME make this an error (we need to handle exceptions first)
-			return vm.NIL
+		if vs[0] == vm.NIL {
+			return vm.NIL, nil
+		}
+		seq, err := vm.ToSeq(vs[0])
+		if err != nil {
+			return vm.NIL, err
 		}
-
 		n := seq.Next()
+		if n == vm.EmptyList {
+			return vm.NIL, nil
+		}
+		return n, nil
+	})
 
-		// FIXME move that to Seq.Next()
-		if n.(vm.Collection).Count().(vm.Int) == 0 {
-			return vm.NIL
+	rest, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("rest", len(vs))
 		}
-		return n
+		seq, err := vm.ToSeq(vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		return seq.More(), nil
 	})
 
-	get, err := vm.NativeFnType.Wrap(func(vs []vm.Value) vm.Value {
+	nth, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
 		vl := len(vs)
 		if vl < 2 || vl > 3 {
-			// FIXME return error
-			return vm.NIL
+			return vm.NIL, arityError("nth", vl)
 		}
-		key := vs[1]
-		as, ok := vs[0].(vm.Lookup)
+		n, ok := vs[1].(vm.Int)
 		if !ok {
-			// FIXME return error
-			return vm.NIL
+			return vm.NIL, vm.NewTypeError(vs[1], "can't be used as an index", vm.IntType)
 		}
-		if vl == 2 {
-			return as.ValueAt(key)
+		notFound := func() (vm.Value, error) {
+			if vl == 3 {
+				return vs[2], nil
+			}
+			return vm.NIL, vm.NewExecutionError(fmt.Sprintf("index %d out of bounds", n))
+		}
+		if n < 0 {
+			return notFound()
 		}
-		return as.ValueAtOr(key, vs[2])
+		switch coll := vs[0].(type) {
+		case vm.ArrayVector:
+			if int(n) >= len(coll) {
+				return notFound()
+			}
+			return coll[n], nil
+		case *vm.PersistentVector:
+			if v, ok := coll.Nth(int(n)); ok {
+				return v, nil
+			}
+			return notFound()
+		case vm.Seq:
+			coll, err := vm.ToSeq(coll)
+			if err != nil {
+				return vm.NIL, err
+			}
+			for i := 0; i < int(n); i++ {
+				coll = coll.Next()
+				if coll == vm.EmptyList {
+					return notFound()
+				}
+			}
+			if coll == vm.EmptyList {
+				return notFound()
+			}
+			return coll.First(), nil
+		}
+		if vs[0] == vm.NIL {
+			return notFound()
+		}
+		return vm.NIL, vm.NewTypeError(vs[0], "nth not supported on this type", nil)
 	})
 
-	count, err := vm.NativeFnType.Wrap(func(vs []vm.Value) vm.Value {
-		if len(vs) != 1 {
-			// FIXME error out
-			return vm.NIL
+	nthnext, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 2 {
+			return vm.NIL, arityError("nthnext", len(vs))
 		}
-		seq, ok := vs[0].(vm.Collection)
+		n, ok := vs[1].(vm.Int)
 		if !ok {
-			// FIXME make this an error (we need to handle exceptions first)
-			return vm.NIL
+			return vm.NIL, vm.NewTypeError(vs[1], "can't be used as an index", vm.IntType)
 		}
-		return seq.Count()
+		switch coll := vs[0].(type) {
+		case vm.ArrayVector:
+			if int(n) >= len(coll) {
+				return vm.NIL, nil
+			}
+			if n < 0 {
+				n = 0
+			}
+			return vm.ListType.Box([]vm.Value(coll[n:]))
+		case vm.Seq:
+			coll, err := vm.ToSeq(coll)
+			if err != nil {
+				return vm.NIL, err
+			}
+			for i := 0; i < int(n) && coll != vm.EmptyList; i++ {
+				coll = coll.Next()
+			}
+			if coll == vm.EmptyList {
+				return vm.NIL, nil
+			}
+			return coll, nil
+		}
+		if vs[0] == vm.NIL {
+			return vm.NIL, nil
+		}
+		return vm.NIL, vm.NewTypeError(vs[0], "is not a sequence", nil)
 	})
 
-	// FIXME write real ones later because this is naiiiiive
-	mapf, err := vm.NativeFnType.Wrap(func(vs []vm.Value) vm.Value {
-		if len(vs) != 2 {
-			// FIXME error out
-			return vm.NIL
+	get, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		vl := len(vs)
+		if vl < 2 || vl > 3 {
+			return vm.NIL, arityError("get", vl)
 		}
-		mfn, ok := vs[0].(vm.Fn)
+		key := vs[1]
+		as, ok := vs[0].(vm.Lookup)
 		if !ok {
-			// FIXME make this an error (we need to handle exceptions first)
-			return vm.NIL
+			if vl == 3 {
+				return vs[2], nil
+			}
+			return vm.NIL, nil
 		}
-		seq, ok := vs[1].(vm.Seq)
+		if vl == 2 {
+			return as.ValueAt(key), nil
+		}
+		return as.ValueAtOr(key, vs[2]), nil
+	})
+
+	count, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("count", len(vs))
+		}
+		seq, ok := vs[0].(vm.Collection)
 		if !ok {
-			// FIXME make this an error (we need to handle exceptions first)
-			return vm.NIL
-		}
-		length := 0
-		col, ok := vs[1].(vm.Collection)
-		if ok {
-			length = col.RawCount()
-		}
-		if length > 0 {
-			newseq := make([]vm.Value, length)
-			i := 0
-			for seq != vm.EmptyList {
-				newseq[i] = mfn.Invoke([]vm.Value{seq.First()})
-				seq = seq.Next()
-				i++
-			}
-			ret, _ := vm.ListType.Box(newseq)
-			return ret
+			return vm.NIL, vm.NewTypeError(vs[0], "count not supported on this type", nil)
 		}
-		return vm.EmptyList
+		return seq.Count(), nil
 	})
 
-	reduce, err := vm.NativeFnType.Wrap(func(vs []vm.Value) vm.Value {
+	reduce, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
 		if len(vs) < 2 || len(vs) > 3 {
-			// FIXME error out
-			return vm.NIL
+			return vm.NIL, arityError("reduce", len(vs))
 		}
 		mfn, ok := vs[0].(vm.Fn)
 		if !ok {
-			// FIXME make this an error (we need to handle exceptions first)
-			return vm.NIL
+			return vm.NIL, vm.NewTypeError(vs[0], "is not a function", nil)
 		}
 		sidx := 1
 		if len(vs) == 3 {
 			sidx = 2
 		}
-		seq, ok := vs[sidx].(vm.Seq)
-		if !ok {
-			// FIXME make this an error (we need to handle exceptions first)
-			return vm.NIL
+		seq, err := vm.ToSeq(vs[sidx])
+		if err != nil {
+			return vm.NIL, err
 		}
+		// don't hold on to the head of a possibly huge lazy seq
+		vs[sidx] = vm.NIL
 		var acc vm.Value
 		if len(vs) == 3 {
 			acc = vs[1]
@@ -397,14 +786,50 @@ func installLangNS() {
 			seq = seq.Next()
 		}
 		for seq != vm.EmptyList {
-			acc = mfn.Invoke([]vm.Value{seq.First(), acc})
+			var err error
+			acc, err = mfn.Invoke([]vm.Value{seq.First(), acc})
+			if err != nil {
+				return vm.NIL, err
+			}
 			seq = seq.Next()
 		}
 
-		return acc
+		return acc, nil
+	})
+
+	seq, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("seq", len(vs))
+		}
+		seq, err := vm.ToSeq(vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		if seq == vm.EmptyList {
+			return vm.NIL, nil
+		}
+		return seq, nil
 	})
 
-	printlnf, err := vm.NativeFnType.Wrap(func(vs []vm.Value) vm.Value {
+	apply, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) < 2 {
+			return vm.NIL, arityError("apply", len(vs))
+		}
+		f, ok := vs[0].(vm.Fn)
+		if !ok {
+			return vm.NIL, vm.NewTypeError(vs[0], "is not a function", nil)
+		}
+		rest, err := seqValues(vs[len(vs)-1])
+		if err != nil {
+			return vm.NIL, err
+		}
+		args := make([]vm.Value, 0, len(vs)-2+len(rest))
+		args = append(args, vs[1:len(vs)-1]...)
+		args = append(args, rest...)
+		return f.Invoke(args)
+	})
+
+	printlnf, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
 		b := &strings.Builder{}
 		for i := range vs {
 			if i > 0 {
@@ -416,78 +841,107 @@ func installLangNS() {
 			}
 			b.WriteString(vs[i].String())
 		}
-		fmt.Println(b)
-		return vm.NIL
+		w, err := writer(Out)
+		if err != nil {
+			return vm.NIL, err
+		}
+		fmt.Fprintln(w, b)
+		return vm.NIL, nil
 	})
 
-	typef, err := vm.NativeFnType.Wrap(func(vs []vm.Value) vm.Value {
+	typef, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
 		if len(vs) != 1 {
-			//FIXME this is an error
-			return vm.NIL
+			return vm.NIL, arityError("type", len(vs))
 		}
 		t := vs[0].Type()
 		if t == vm.NilType {
-			return vm.NIL
+			return vm.NIL, nil
 		}
-		return t
+		return t, nil
 	})
 
-	inNs, err := vm.NativeFnType.Wrap(func(vs []vm.Value) vm.Value {
+	inNs, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
 		if len(vs) != 1 {
-			// FIXME handle error
-			return vm.NIL
-		}
-		sym := vs[0]
-		if sym.Type() != vm.SymbolType {
-			// FIXME handle error
-			return vm.NIL
-		}
-		nns := LookupOrRegisterNS(string(sym.(vm.Symbol)))
-		CurrentNS.SetRoot(nns)
-		return nns
-	})
-
-	use, err := vm.NativeFnType.Wrap(func(vs []vm.Value) vm.Value {
-		if len(vs) < 1 {
-			// FIXME handle error
-			return vm.NIL
+			return vm.NIL, arityError("in-ns", len(vs))
 		}
-		cns := CurrentNS.Deref().(*vm.Namespace)
-		for i := range vs {
-			s, ok := vs[i].(vm.Symbol)
-			if !ok {
-				return vm.NIL
-			}
-			cns.Refer(NS(string(s)), "", true)
+		sym, ok := vm.SymbolOf(vs[0])
+		if !ok {
+			return vm.NIL, vm.NewTypeError(vs[0], "can't be used as namespace name", vm.SymbolType)
 		}
-		return vm.NIL
+		nns := LookupOrRegisterNS(string(sym))
+		CurrentNS.Set(nns)
+		return nns, nil
 	})
 
-	now, err := vm.NativeFnType.Wrap(func(vs []vm.Value) vm.Value {
-		return vm.NewBoxed(time.Now())
+	now, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		return vm.NewBoxed(time.Now()), nil
 	})
 
-	methodInvoke, err := vm.NativeFnType.Wrap(func(vs []vm.Value) vm.Value {
+	methodInvoke, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
 		if len(vs) < 2 {
-			// FIXME handle error
-			fmt.Println("not enough args")
-			return vm.NIL
+			return vm.NIL, arityError(".", len(vs))
 		}
 		rec, ok := vs[0].(vm.Receiver)
 		if !ok {
-			// FIXME handle error
-			fmt.Println("expected Receiver")
-			return vm.NIL
+			return vm.NIL, vm.NewTypeError(vs[0], "has no methods", nil)
 		}
-		name, ok := vs[1].(vm.Symbol)
+		name, ok := vm.SymbolOf(vs[1])
 		if !ok {
-			// FIXME handle error
-			fmt.Println("expected Symbol")
-			return vm.NIL
+			return vm.NIL, vm.NewTypeError(vs[1], "can't be used as method name", vm.SymbolType)
 		}
 		return rec.InvokeMethod(name, vs[2:])
 	})
 
+	exInfo, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) < 2 || len(vs) > 3 {
+			return vm.NIL, arityError("ex-info", len(vs))
+		}
+		msg, ok := vs[0].(vm.String)
+		if !ok {
+			return vm.NIL, vm.NewTypeError(vs[0], "can't be used as exception message", vm.StringType)
+		}
+		if vs[1].Type() != vm.MapType {
+			return vm.NIL, vm.NewTypeError(vs[1], "can't be used as exception data", vm.MapType)
+		}
+		var cause error
+		if len(vs) == 3 && vs[2] != vm.NIL {
+			cause = vm.Throwable(vs[2])
+		}
+		return vm.NewExInfo(string(msg), vs[1], cause), nil
+	})
+
+	exData, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("ex-data", len(vs))
+		}
+		ex, ok := vs[0].(*vm.ExInfo)
+		if !ok {
+			return vm.NIL, nil
+		}
+		return ex.Data(), nil
+	})
+
+	exMessage, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("ex-message", len(vs))
+		}
+		return vm.ExceptionMessage(vs[0]), nil
+	})
+
+	exCause, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("ex-cause", len(vs))
+		}
+		return vm.ExceptionCause(vs[0]), nil
+	})
+
+	instance, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 2 {
+			return vm.NIL, arityError("instance?", len(vs))
+		}
+		return vm.Boolean(vm.IsInstance(vs[0], vs[1])), nil
+	})
+
 	if err != nil {
 		panic("lang NS init failed")
 	}
@@ -495,7 +949,9 @@ func installLangNS() {
 	ns := vm.NewNamespace(NameCoreNS)
 
 	// vars
-	CurrentNS = ns.Def("*ns*", ns)
+	CurrentNS = ns.Def("*ns*", ns).SetDynamic()
+	Out = ns.Def("*out*", vm.NewBoxed(os.Stdout)).SetDynamic()
+	Err = ns.Def("*err*", vm.NewBoxed(os.Stderr)).SetDynamic()
 
 	// FIXME implement the primitives in let-go later on and clean up this mess
 	// primitive fns
@@ -505,19 +961,43 @@ func installLangNS() {
 	ns.Def("/", div)
 
 	ns.Def("=", equals)
+	ns.Def("not=", notEquals)
+	ns.Def("hash", hash)
 	ns.Def("gt", gt)
+	ns.Def("gte", gte)
 	ns.Def("lt", lt)
+	ns.Def("lte", lte)
+	ns.Def("==", numEquals)
+	ns.Def("+'", plusP)
+	ns.Def("-'", subP)
+	ns.Def("*'", mulP)
+
+	ns.Def("number?", isNumber)
+	ns.Def("integer?", isInteger)
+	ns.Def("float?", isFloat)
+	ns.Def("ratio?", isRatio)
+	ns.Def("double", double)
+	ns.Def("bigint", bigint)
+	ns.Def("numerator", numerator)
+	ns.Def("denominator", denominator)
 
 	ns.Def("and", and)
 	ns.Def("or", or)
 	ns.Def("not", not)
 
 	ns.Def("set-macro!", setMacro)
+	ns.Def("str", str)
+	ns.Def("symbol", symbol)
+	ns.Def("keyword", keyword)
+	ns.Def("name", name)
 	ns.Def("gensym", gensym)
 	ns.Def("in-ns", inNs)
-	ns.Def("use", use)
 
 	ns.Def("vector", vector)
+	ns.Def("vec", vec)
+	ns.Def("conj", conj)
+	ns.Def("pop", pop)
+	ns.Def("peek", peek)
 	ns.Def("hash-map", hashMap)
 	ns.Def("list", list)
 
@@ -527,15 +1007,46 @@ func installLangNS() {
 	ns.Def("first", first)
 	ns.Def("second", second)
 	ns.Def("next", next)
+	ns.Def("rest", rest)
+	ns.Def("nth", nth)
+	ns.Def("seq", seq)
+	ns.Def("apply", apply)
+	ns.Def("nthnext", nthnext)
 	ns.Def("get", get)
 	ns.Def("count", count)
 
-	ns.Def("map", mapf)
 	ns.Def("reduce", reduce)
+	installSeqFns(ns)
+	installRefFns(ns)
+	installAsyncFns(ns)
+	installVarFns(ns)
+	installProtocolFns(ns)
+	installRecordFns(ns)
+	installMultiFns(ns)
+	installLoadFns(ns)
+	installMetaFns(ns)
+	installSetFns(ns)
 
 	ns.Def("println", printlnf)
 
 	ns.Def("type", typef)
+	ns.Def("instance?", instance)
+
+	ns.Def("Exception", vm.ExceptionType)
+	ns.Def("ExceptionInfo", vm.ExceptionInfoType)
+	ns.Def("Error", vm.ErrorType)
+
+	// core types are named like their let-go.lang counterparts so that protocols can be extended to them
+	for _, t := range []vm.ValueType{vm.BooleanType, vm.CharType, vm.IntType, vm.FloatType, vm.BigIntType,
+		vm.RatioType, vm.StringType, vm.KeywordType, vm.SymbolType, vm.ListType, vm.ArrayVectorType,
+		vm.PersistentVectorType, vm.MapType, vm.SetType, vm.LazySeqType, vm.ConsType, vm.FuncType, vm.NativeFnType,
+		vm.NamespaceType, vm.TypeType} {
+		ns.Def(strings.TrimPrefix(t.Name(), "let-go.lang."), t)
+	}
+	ns.Def("ex-info", exInfo)
+	ns.Def("ex-data", exData)
+	ns.Def("ex-message", exMessage)
+	ns.Def("ex-cause", exCause)
 
 	// FIXME move this later outside the core
 	ns.Def("now", now)
@@ -546,4 +1057,5 @@ func installLangNS() {
 	CoreNS = ns
 
 	RegisterNS(ns)
+	installSetNS()
 }
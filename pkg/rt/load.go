@@ -0,0 +1,347 @@
+/*
+ * Copyright (c) 2021 Marcin Gasperowicz <xnooga@gmail.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+ * documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit
+ * persons to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+ * Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+ * WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+ * OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package rt
+
+import (
+	"fmt"
+	"github.com/nooga/let-go/pkg/vm"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// LoadPath is a dynamic var holding directories searched by require and load
+var LoadPath *vm.Var
+
+// Loader compiles and evaluates source read from a reader, it's installed by the compiler
+var Loader func(source string, r io.Reader) error
+
+// loadedLibs marks namespaces loaded by require, loadingLibs holds channels closed when a load in progress finishes
+var loadedLibs = map[string]bool{}
+var loadingLibs = map[string]chan struct{}{}
+var loadedLibsMu sync.Mutex
+
+// pendingLibs is bound to the set of namespaces being loaded by the current load chain, it's used to detect cycles
+var pendingLibs = vm.NewVar(nil, NameCoreNS, "*pending-libs*").SetRoot(vm.EmptySet).SetDynamic()
+
+// SetLoadPath replaces the root value of *load-path*
+func SetLoadPath(dirs ...string) {
+	vs := make([]vm.Value, len(dirs))
+	for i := range dirs {
+		vs[i] = vm.String(dirs[i])
+	}
+	LoadPath.SetRoot(vm.NewPersistentVector(vs))
+}
+
+// defaultLoadPath is the working directory followed by directories listed in LETGO_PATH
+func defaultLoadPath() []string {
+	dirs := []string{"."}
+	if env := os.Getenv("LETGO_PATH"); env != "" {
+		dirs = append(dirs, filepath.SplitList(env)...)
+	}
+	return dirs
+}
+
+// libPath turns namespace name into a path relative to the load path, foo.bar-baz becomes foo/bar_baz
+func libPath(name string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(name, "-", "_"), ".", "/")
+}
+
+// findSource returns the first file named path.lg found on the load path
+func findSource(path string) (string, bool) {
+	dirs, err := seqValues(LoadPath.Deref())
+	if err != nil {
+		return "", false
+	}
+	for _, d := range dirs {
+		dir, ok := d.(vm.String)
+		if !ok {
+			continue
+		}
+		file := filepath.Join(string(dir), filepath.FromSlash(path)+".lg")
+		if info, err := os.Stat(file); err == nil && !info.IsDir() {
+			return file, true
+		}
+	}
+	return "", false
+}
+
+// LoadFile compiles and evaluates given file, the current namespace is restored afterwards
+func LoadFile(filename string) error {
+	if Loader == nil {
+		return vm.NewExecutionError("no loader installed")
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return vm.NewExecutionError("can't load " + filename).Wrap(err)
+	}
+	defer f.Close()
+	return Loader(filename, f)
+}
+
+// Load finds path.lg on the load path and loads it
+func Load(path string) error {
+	file, ok := findSource(strings.TrimPrefix(path, "/"))
+	if !ok {
+		return vm.NewExecutionError(fmt.Sprintf("could not locate %s.lg on load path", path))
+	}
+	return LoadFile(file)
+}
+
+// Require returns namespace with given name loading it from the load path first unless it was loaded already,
+// namespaces defined without a source file are returned as they are.
+// Goroutines requiring a namespace which is being loaded elsewhere wait for the load to finish.
+func Require(name string, reload bool) (*vm.Namespace, error) {
+	pending, _ := pendingLibs.Deref().(*vm.Set)
+	if pending.Contains(vm.String(name)) {
+		return nil, vm.NewExecutionError(fmt.Sprintf("cyclic load dependency on %s", name))
+	}
+	loadedLibsMu.Lock()
+	for {
+		done, loading := loadingLibs[name]
+		if !loading {
+			break
+		}
+		loadedLibsMu.Unlock()
+		<-done
+		loadedLibsMu.Lock()
+	}
+	if loadedLibs[name] && !reload {
+		loadedLibsMu.Unlock()
+		return LookupOrRegisterNS(name), nil
+	}
+	file, ok := findSource(libPath(name))
+	if !ok {
+		loadedLibsMu.Unlock()
+		if ns := FindNS(name); ns != nil {
+			return ns, nil
+		}
+		return nil, vm.NewExecutionError(fmt.Sprintf("could not locate %s.lg on load path", libPath(name)))
+	}
+	done := make(chan struct{})
+	loadingLibs[name] = done
+	loadedLibsMu.Unlock()
+
+	err := loadLib(file, pending.Conj(vm.String(name)))
+
+	loadedLibsMu.Lock()
+	delete(loadingLibs, name)
+	if err == nil {
+		loadedLibs[name] = true
+	}
+	close(done)
+	loadedLibsMu.Unlock()
+	if err != nil {
+		return nil, vm.NewExecutionError("can't require " + name).Wrap(err)
+	}
+	ns := FindNS(name)
+	if ns == nil {
+		return nil, vm.NewExecutionError(fmt.Sprintf("namespace %s not found after loading %s", name, file))
+	}
+	return ns, nil
+}
+
+// loadLib loads file with *pending-libs* bound to pending
+func loadLib(file string, pending *vm.Set) error {
+	err := vm.PushBindings(map[*vm.Var]vm.Value{pendingLibs: pending})
+	if err != nil {
+		return err
+	}
+	err = LoadFile(file)
+	perr := vm.PopBindings()
+	if err != nil {
+		return err
+	}
+	return perr
+}
+
+// requireSpec requires a lib given as a symbol or [name & opts] and refers it in ns,
+// recognized opts are :as alias and :refer [syms] or :refer :all
+func requireSpec(ns *vm.Namespace, spec vm.Value, reload bool, all bool) error {
+	var opts []vm.Value
+	name, ok := vm.SymbolOf(spec)
+	if !ok {
+		vs, err := seqValues(spec)
+		if err != nil || len(vs) == 0 {
+			return vm.NewTypeError(spec, "is not a valid lib spec", nil)
+		}
+		name, ok = vm.SymbolOf(vs[0])
+		if !ok {
+			return vm.NewTypeError(vs[0], "can't be used as namespace name", vm.SymbolType)
+		}
+		opts = vs[1:]
+	}
+	if len(opts)%2 != 0 {
+		return vm.NewExecutionError(fmt.Sprintf("odd number of options in lib spec for %s", name))
+	}
+	alias := ""
+	var refer []vm.Symbol
+	for i := 0; i < len(opts); i += 2 {
+		switch opts[i] {
+		case vm.Keyword("as"):
+			a, ok := vm.SymbolOf(opts[i+1])
+			if !ok {
+				return vm.NewTypeError(opts[i+1], "can't be used as alias", vm.SymbolType)
+			}
+			alias = string(a)
+		case vm.Keyword("refer"):
+			if opts[i+1] == vm.Keyword("all") {
+				all = true
+				continue
+			}
+			syms, err := seqValues(opts[i+1])
+			if err != nil {
+				return vm.NewTypeError(opts[i+1], "can't be referred", nil).Wrap(err)
+			}
+			for _, s := range syms {
+				sym, ok := vm.SymbolOf(s)
+				if !ok {
+					return vm.NewTypeError(s, "can't be referred", vm.SymbolType)
+				}
+				refer = append(refer, sym)
+			}
+		default:
+			return vm.NewExecutionError(fmt.Sprintf("unsupported option %s in lib spec for %s", opts[i], name))
+		}
+	}
+	lib, err := Require(string(name), reload)
+	if err != nil {
+		return err
+	}
+	ns.Refer(lib, "", all)
+	if alias != "" {
+		ns.Refer(lib, alias, all)
+	}
+	return ns.ReferVars(lib, refer)
+}
+
+// requireFn makes require and use natives, use refers all vars of required namespaces
+func requireFn(all bool) (vm.Value, error) {
+	return vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		cns := CurrentNS.Deref().(*vm.Namespace)
+		reload := false
+		specs := make([]vm.Value, 0, len(vs))
+		for i := range vs {
+			if vs[i] == vm.Keyword("reload") {
+				reload = true
+				continue
+			}
+			specs = append(specs, vs[i])
+		}
+		for i := range specs {
+			if err := requireSpec(cns, specs[i], reload, all); err != nil {
+				return vm.NIL, err
+			}
+		}
+		return vm.NIL, nil
+	})
+}
+
+// installLoadFns defines functions loading code and namespaces in the core namespace
+//
+//nolint
+func installLoadFns(ns *vm.Namespace) {
+	require, err := requireFn(false)
+	use, err := requireFn(true)
+
+	load, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		for i := range vs {
+			path, ok := vs[i].(vm.String)
+			if !ok {
+				return vm.NIL, vm.NewTypeError(vs[i], "can't be loaded", vm.StringType)
+			}
+			if err := Load(string(path)); err != nil {
+				return vm.NIL, err
+			}
+		}
+		return vm.NIL, nil
+	})
+
+	loadFile, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("load-file", len(vs))
+		}
+		path, ok := vs[0].(vm.String)
+		if !ok {
+			return vm.NIL, vm.NewTypeError(vs[0], "can't be loaded", vm.StringType)
+		}
+		return vm.NIL, LoadFile(string(path))
+	})
+
+	alias, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 2 {
+			return vm.NIL, arityError("alias", len(vs))
+		}
+		a, ok := vm.SymbolOf(vs[0])
+		if !ok {
+			return vm.NIL, vm.NewTypeError(vs[0], "can't be used as alias", vm.SymbolType)
+		}
+		name, ok := vm.SymbolOf(vs[1])
+		if !ok {
+			return vm.NIL, vm.NewTypeError(vs[1], "can't be used as namespace name", vm.SymbolType)
+		}
+		target := FindNS(string(name))
+		if target == nil {
+			return vm.NIL, vm.NewExecutionError(fmt.Sprintf("no namespace %s found", name))
+		}
+		CurrentNS.Deref().(*vm.Namespace).Refer(target, string(a), false)
+		return vm.NIL, nil
+	})
+
+	findNs, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("find-ns", len(vs))
+		}
+		name, ok := vm.SymbolOf(vs[0])
+		if !ok {
+			return vm.NIL, vm.NewTypeError(vs[0], "can't be used as namespace name", vm.SymbolType)
+		}
+		if found := FindNS(string(name)); found != nil {
+			return found, nil
+		}
+		return vm.NIL, nil
+	})
+
+	nsName, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("ns-name", len(vs))
+		}
+		n, ok := vs[0].(*vm.Namespace)
+		if !ok {
+			return vm.NIL, vm.NewTypeError(vs[0], "is not a namespace", vm.NamespaceType)
+		}
+		return vm.Symbol(n.Name()), nil
+	})
+
+	if err != nil {
+		panic("load fns init failed")
+	}
+
+	LoadPath = ns.Def("*load-path*", vm.NIL).SetDynamic()
+	SetLoadPath(defaultLoadPath()...)
+
+	ns.Def("require", require)
+	ns.Def("use", use)
+	ns.Def("load", load)
+	ns.Def("load-file", loadFile)
+	ns.Def("alias", alias)
+	ns.Def("find-ns", findNs)
+	ns.Def("ns-name", nsName)
+}
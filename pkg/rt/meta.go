@@ -0,0 +1,123 @@
+/*
+ * Copyright (c) 2021 Marcin Gasperowicz <xnooga@gmail.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+ * documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit
+ * persons to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+ * Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+ * WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+ * OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package rt
+
+import (
+	"github.com/nooga/let-go/pkg/vm"
+)
+
+// installMetaFns defines functions reading and changing metadata in the core namespace
+//
+//nolint
+func installMetaFns(ns *vm.Namespace) {
+	meta, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("meta", len(vs))
+		}
+		return vm.MetaOf(vs[0]), nil
+	})
+
+	withMeta, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 2 {
+			return vm.NIL, arityError("with-meta", len(vs))
+		}
+		m, err := metaArg(vs[1])
+		if err != nil {
+			return vm.NIL, err
+		}
+		return vm.WithMeta(vs[0], m)
+	})
+
+	varyMeta, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) < 2 {
+			return vm.NIL, arityError("vary-meta", len(vs))
+		}
+		m, err := applyMetaFn("vary-meta", vs[1], vm.MetaOf(vs[0]), vs[2:])
+		if err != nil {
+			return vm.NIL, err
+		}
+		return vm.WithMeta(vs[0], m)
+	})
+
+	alterMeta, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) < 2 {
+			return vm.NIL, arityError("alter-meta!", len(vs))
+		}
+		v, ok := vs[0].(*vm.Var)
+		if !ok {
+			return vm.NIL, vm.NewTypeError(vs[0], "can't have its metadata altered", nil)
+		}
+		return v.AlterMeta(func(m *vm.Map) (*vm.Map, error) {
+			return applyMetaFn("alter-meta!", vs[1], m, vs[2:])
+		})
+	})
+
+	resetMeta, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 2 {
+			return vm.NIL, arityError("reset-meta!", len(vs))
+		}
+		v, ok := vs[0].(*vm.Var)
+		if !ok {
+			return vm.NIL, vm.NewTypeError(vs[0], "can't have its metadata reset", nil)
+		}
+		m, err := metaArg(vs[1])
+		if err != nil {
+			return vm.NIL, err
+		}
+		if m == nil {
+			m = vm.EmptyMap
+		}
+		v.SetMeta(m)
+		return m, nil
+	})
+
+	if err != nil {
+		panic("meta fns init failed")
+	}
+
+	ns.Def("meta", meta)
+	ns.Def("with-meta", withMeta)
+	ns.Def("vary-meta", varyMeta)
+	ns.Def("alter-meta!", alterMeta)
+	ns.Def("reset-meta!", resetMeta)
+}
+
+// metaArg accepts a map or nil as metadata
+func metaArg(v vm.Value) (*vm.Map, error) {
+	if v == vm.NIL {
+		return nil, nil
+	}
+	m, ok := v.(*vm.Map)
+	if !ok {
+		return nil, vm.NewTypeError(v, "can't be used as metadata", vm.MapType)
+	}
+	return m, nil
+}
+
+// applyMetaFn calls (f meta & args) and checks that it returned new metadata
+func applyMetaFn(name string, f vm.Value, meta vm.Value, args []vm.Value) (*vm.Map, error) {
+	fn, err := fnArg(name, f)
+	if err != nil {
+		return nil, err
+	}
+	out, err := fn.Invoke(append([]vm.Value{meta}, args...))
+	if err != nil {
+		return nil, err
+	}
+	return metaArg(out)
+}
@@ -0,0 +1,220 @@
+/*
+ * Copyright (c) 2021 Marcin Gasperowicz <xnooga@gmail.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+ * documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit
+ * persons to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+ * Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+ * WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+ * OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package rt
+
+import (
+	"github.com/nooga/let-go/pkg/vm"
+)
+
+// installMultiFns defines multimethods and hierarchies in the core namespace
+//
+//nolint
+func installMultiFns(ns *vm.Namespace) {
+	multiFn, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 4 {
+			return vm.NIL, arityError("multi-fn*", len(vs))
+		}
+		name, ok := vm.SymbolOf(vs[0])
+		if !ok {
+			return vm.NIL, vm.NewTypeError(vs[0], "can't be used as multimethod name", vm.SymbolType)
+		}
+		dispatch, err := fnArg("multi-fn*", vs[1])
+		if err != nil {
+			return vm.NIL, err
+		}
+		var h vm.Reference
+		if vs[3] != vm.NIL {
+			h, ok = vs[3].(vm.Reference)
+			if !ok {
+				return vm.NIL, vm.NewTypeError(vs[3], "can't be used as hierarchy reference", nil)
+			}
+		}
+		cns := CurrentNS.Deref().(*vm.Namespace)
+		return vm.NewMultiFn(cns.Name()+"/"+string(name), dispatch, vs[2], h), nil
+	})
+
+	addMethod, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 3 {
+			return vm.NIL, arityError("add-method", len(vs))
+		}
+		m, err := multiFnArg("add-method", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		f, err := fnArg("add-method", vs[2])
+		if err != nil {
+			return vm.NIL, err
+		}
+		m.AddMethod(vs[1], f)
+		return m, nil
+	})
+
+	removeMethod, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 2 {
+			return vm.NIL, arityError("remove-method", len(vs))
+		}
+		m, err := multiFnArg("remove-method", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		m.RemoveMethod(vs[1])
+		return m, nil
+	})
+
+	removeAllMethods, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("remove-all-methods", len(vs))
+		}
+		m, err := multiFnArg("remove-all-methods", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		m.RemoveAllMethods()
+		return m, nil
+	})
+
+	preferMethod, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 3 {
+			return vm.NIL, arityError("prefer-method", len(vs))
+		}
+		m, err := multiFnArg("prefer-method", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		return m, m.PreferMethod(vs[1], vs[2])
+	})
+
+	methods, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("methods", len(vs))
+		}
+		m, err := multiFnArg("methods", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		return m.Methods(), nil
+	})
+
+	getMethod, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 2 {
+			return vm.NIL, arityError("get-method", len(vs))
+		}
+		m, err := multiFnArg("get-method", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		f, err := m.Method(vs[1])
+		if err != nil || f == nil {
+			return vm.NIL, err
+		}
+		return f, nil
+	})
+
+	prefers, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("prefers", len(vs))
+		}
+		m, err := multiFnArg("prefers", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		return m.Prefers(), nil
+	})
+
+	makeHierarchy, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 0 {
+			return vm.NIL, arityError("make-hierarchy", len(vs))
+		}
+		return vm.MakeHierarchy(), nil
+	})
+
+	derive, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		switch len(vs) {
+		case 2:
+			return vm.NIL, vm.DeriveGlobal(vs[0], vs[1])
+		case 3:
+			h, ok := vs[0].(*vm.Map)
+			if !ok {
+				return vm.NIL, vm.NewTypeError(vs[0], "is not a hierarchy", vm.MapType)
+			}
+			return vm.Derive(h, vs[1], vs[2])
+		}
+		return vm.NIL, arityError("derive", len(vs))
+	})
+
+	isa, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		switch len(vs) {
+		case 2:
+			return vm.Boolean(vm.Isa(vm.GlobalHierarchy.Deref(), vs[0], vs[1])), nil
+		case 3:
+			return vm.Boolean(vm.Isa(vs[0], vs[1], vs[2])), nil
+		}
+		return vm.NIL, arityError("isa?", len(vs))
+	})
+
+	parents, err := hierarchyFn("parents", vm.HierarchyParents)
+	ancestors, err := hierarchyFn("ancestors", vm.HierarchyAncestors)
+	descendants, err := hierarchyFn("descendants", vm.HierarchyDescendants)
+
+	if err != nil {
+		panic("multi fns init failed")
+	}
+
+	ns.Def("MultiFn", vm.MultiFnType)
+	ns.Def("multi-fn*", multiFn)
+	ns.Def("add-method", addMethod)
+	ns.Def("remove-method", removeMethod)
+	ns.Def("remove-all-methods", removeAllMethods)
+	ns.Def("prefer-method", preferMethod)
+	ns.Def("methods", methods)
+	ns.Def("get-method", getMethod)
+	ns.Def("prefers", prefers)
+	ns.Def("make-hierarchy", makeHierarchy)
+	ns.Def("derive", derive)
+	ns.Def("isa?", isa)
+	ns.Def("parents", parents)
+	ns.Def("ancestors", ancestors)
+	ns.Def("descendants", descendants)
+}
+
+func multiFnArg(name string, v vm.Value) (*vm.MultiFn, error) {
+	m, ok := v.(*vm.MultiFn)
+	if !ok {
+		return nil, vm.NewTypeError(v, "is not a multimethod in "+name, vm.MultiFnType)
+	}
+	return m, nil
+}
+
+// hierarchyFn makes a native querying the global hierarchy or the one given as the first argument
+func hierarchyFn(name string, query func(vm.Value, vm.Value) *vm.Set) (vm.Value, error) {
+	return vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		var tags *vm.Set
+		switch len(vs) {
+		case 1:
+			tags = query(vm.GlobalHierarchy.Deref(), vs[0])
+		case 2:
+			tags = query(vs[0], vs[1])
+		default:
+			return vm.NIL, arityError(name, len(vs))
+		}
+		if tags.RawCount() == 0 {
+			return vm.NIL, nil
+		}
+		return tags, nil
+	})
+}
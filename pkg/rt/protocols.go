@@ -0,0 +1,202 @@
+/*
+ * Copyright (c) 2021 Marcin Gasperowicz <xnooga@gmail.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+ * documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit
+ * persons to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+ * Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+ * WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+ * OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package rt
+
+import (
+	"github.com/nooga/let-go/pkg/vm"
+)
+
+// installProtocolFns defines functions creating and extending protocols in the core namespace
+//
+//nolint
+func installProtocolFns(ns *vm.Namespace) {
+	protocol, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 2 {
+			return vm.NIL, arityError("protocol*", len(vs))
+		}
+		name, ok := vm.SymbolOf(vs[0])
+		if !ok {
+			return vm.NIL, vm.NewTypeError(vs[0], "can't be used as protocol name", vm.SymbolType)
+		}
+		ms, err := seqValues(vs[1])
+		if err != nil {
+			return vm.NIL, err
+		}
+		methods := make([]vm.Symbol, len(ms))
+		for i := range ms {
+			m, ok := vm.SymbolOf(ms[i])
+			if !ok {
+				return vm.NIL, vm.NewTypeError(ms[i], "can't be used as method name", vm.SymbolType)
+			}
+			methods[i] = m
+		}
+		cns := CurrentNS.Deref().(*vm.Namespace)
+		return vm.NewProtocol(cns.Name()+"/"+string(name), methods), nil
+	})
+
+	protocolMethod, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 2 {
+			return vm.NIL, arityError("protocol-method", len(vs))
+		}
+		p, err := protocolArg("protocol-method", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		name, ok := vm.SymbolOf(vs[1])
+		if !ok {
+			return vm.NIL, vm.NewTypeError(vs[1], "can't be used as method name", vm.SymbolType)
+		}
+		return p.Method(name)
+	})
+
+	extend, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) < 3 || len(vs)%2 != 1 {
+			return vm.NIL, arityError("extend", len(vs))
+		}
+		t, err := typeArg("extend", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		for i := 1; i < len(vs); i += 2 {
+			p, err := protocolArg("extend", vs[i])
+			if err != nil {
+				return vm.NIL, err
+			}
+			m, ok := vs[i+1].(*vm.Map)
+			if !ok {
+				return vm.NIL, vm.NewTypeError(vs[i+1], "can't be used as method map", vm.MapType)
+			}
+			impls := map[vm.Symbol]vm.Fn{}
+			m.Each(func(k vm.Value, v vm.Value) {
+				var name vm.Symbol
+				switch k := k.(type) {
+				case vm.Symbol, *vm.MetaSymbol:
+					name, _ = vm.SymbolOf(k)
+				case vm.Keyword:
+					name = vm.Symbol(k)
+				default:
+					err = vm.NewTypeError(k, "can't be used as method name", vm.SymbolType)
+					return
+				}
+				f, ok := v.(vm.Fn)
+				if !ok {
+					err = vm.NewTypeError(v, "is not a function", nil)
+					return
+				}
+				impls[name] = f
+			})
+			if err != nil {
+				return vm.NIL, err
+			}
+			if err = p.Extend(t, impls); err != nil {
+				return vm.NIL, err
+			}
+		}
+		return vm.NIL, nil
+	})
+
+	satisfies, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 2 {
+			return vm.NIL, arityError("satisfies?", len(vs))
+		}
+		p, err := protocolArg("satisfies?", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		return vm.Boolean(p.Satisfies(vs[1])), nil
+	})
+
+	extends, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 2 {
+			return vm.NIL, arityError("extends?", len(vs))
+		}
+		p, err := protocolArg("extends?", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		t, err := typeArg("extends?", vs[1])
+		if err != nil {
+			return vm.NIL, err
+		}
+		return vm.Boolean(p.Extends(t)), nil
+	})
+
+	extenders, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("extenders", len(vs))
+		}
+		p, err := protocolArg("extenders", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		ts := p.Extenders()
+		ret := make([]vm.Value, len(ts))
+		for i := range ts {
+			ret[i] = ts[i]
+		}
+		return vm.ListType.Box(ret)
+	})
+
+	boxedType, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("boxed-type", len(vs))
+		}
+		name, ok := vs[0].(vm.String)
+		if !ok {
+			return vm.NIL, vm.NewTypeError(vs[0], "can't be used as type name", vm.StringType)
+		}
+		t, ok := vm.FindBoxedType(string(name))
+		if !ok {
+			return vm.NIL, nil
+		}
+		return t, nil
+	})
+
+	if err != nil {
+		panic("protocol fns init failed")
+	}
+
+	ns.Def("Protocol", vm.ProtocolType)
+	ns.Def("protocol*", protocol)
+	ns.Def("protocol-method", protocolMethod)
+	ns.Def("extend", extend)
+	ns.Def("satisfies?", satisfies)
+	ns.Def("extends?", extends)
+	ns.Def("extenders", extenders)
+	ns.Def("boxed-type", boxedType)
+}
+
+func protocolArg(name string, v vm.Value) (*vm.Protocol, error) {
+	p, ok := v.(*vm.Protocol)
+	if !ok {
+		return nil, vm.NewTypeError(v, "is not a protocol in "+name, vm.ProtocolType)
+	}
+	return p, nil
+}
+
+// typeArg accepts types and nil which stands for the type of nil
+func typeArg(name string, v vm.Value) (vm.ValueType, error) {
+	if v == vm.NIL {
+		return vm.NilType, nil
+	}
+	t, ok := v.(vm.ValueType)
+	if !ok || v.Type() != vm.TypeType {
+		return nil, vm.NewTypeError(v, "is not a type in "+name, vm.TypeType)
+	}
+	return t, nil
+}
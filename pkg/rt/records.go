@@ -0,0 +1,96 @@
+/*
+ * Copyright (c) 2021 Marcin Gasperowicz <xnooga@gmail.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+ * documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit
+ * persons to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+ * Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+ * WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+ * OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package rt
+
+import (
+	"github.com/nooga/let-go/pkg/vm"
+)
+
+// installRecordFns defines functions backing defrecord and deftype in the core namespace
+//
+//nolint
+func installRecordFns(ns *vm.Namespace) {
+	recordType, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 3 {
+			return vm.NIL, arityError("record-type*", len(vs))
+		}
+		name, ok := vm.SymbolOf(vs[0])
+		if !ok {
+			return vm.NIL, vm.NewTypeError(vs[0], "can't be used as type name", vm.SymbolType)
+		}
+		fs, err := seqValues(vs[1])
+		if err != nil {
+			return vm.NIL, err
+		}
+		fields := make([]vm.Symbol, len(fs))
+		for i := range fs {
+			f, ok := vm.SymbolOf(fs[i])
+			if !ok {
+				return vm.NIL, vm.NewTypeError(fs[i], "can't be used as field name", vm.SymbolType)
+			}
+			fields[i] = f
+		}
+		cns := CurrentNS.Deref().(*vm.Namespace)
+		return vm.NewRecordType(cns.Name()+"."+string(name), fields, vm.IsTruthy(vs[2])), nil
+	})
+
+	newf, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) < 1 {
+			return vm.NIL, arityError("new", len(vs))
+		}
+		t, ok := vs[0].(*vm.RecordType)
+		if !ok {
+			return vm.NIL, vm.NewTypeError(vs[0], "can't be instantiated with new", nil)
+		}
+		return t.New(vs[1:])
+	})
+
+	mapToRecord, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 2 {
+			return vm.NIL, arityError("map->record*", len(vs))
+		}
+		t, ok := vs[0].(*vm.RecordType)
+		if !ok {
+			return vm.NIL, vm.NewTypeError(vs[0], "is not a record type", nil)
+		}
+		switch m := vs[1].(type) {
+		case *vm.Map:
+			return t.FromMap(m)
+		case *vm.Record:
+			return t.FromMap(m.ToMap())
+		}
+		return vm.NIL, vm.NewTypeError(vs[1], "can't be turned into a record", vm.MapType)
+	})
+
+	isRecord, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("record?", len(vs))
+		}
+		_, ok := vs[0].(*vm.Record)
+		return vm.Boolean(ok), nil
+	})
+
+	if err != nil {
+		panic("record fns init failed")
+	}
+
+	ns.Def("record-type*", recordType)
+	ns.Def("new", newf)
+	ns.Def("map->record*", mapToRecord)
+	ns.Def("record?", isRecord)
+}
@@ -0,0 +1,687 @@
+/*
+ * Copyright (c) 2021 Marcin Gasperowicz <xnooga@gmail.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+ * documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit
+ * persons to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+ * Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+ * WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+ * OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package rt
+
+import (
+	"github.com/nooga/let-go/pkg/vm"
+	"time"
+)
+
+func atomArg(name string, v vm.Value) (*vm.Atom, error) {
+	a, ok := v.(*vm.Atom)
+	if !ok {
+		return nil, vm.NewTypeError(v, "is not an atom in "+name, vm.AtomType)
+	}
+	return a, nil
+}
+
+func refArg(name string, v vm.Value) (*vm.Ref, error) {
+	r, ok := v.(*vm.Ref)
+	if !ok {
+		return nil, vm.NewTypeError(v, "is not a ref in "+name, vm.RefType)
+	}
+	return r, nil
+}
+
+func agentArg(name string, v vm.Value) (*vm.Agent, error) {
+	a, ok := v.(*vm.Agent)
+	if !ok {
+		return nil, vm.NewTypeError(v, "is not an agent in "+name, vm.AgentType)
+	}
+	return a, nil
+}
+
+func agentArgs(name string, vs []vm.Value) ([]*vm.Agent, error) {
+	agents := make([]*vm.Agent, len(vs))
+	for i := range vs {
+		a, err := agentArg(name, vs[i])
+		if err != nil {
+			return nil, err
+		}
+		agents[i] = a
+	}
+	return agents, nil
+}
+
+func errorModeArg(name string, v vm.Value) (vm.ErrorMode, error) {
+	switch v {
+	case vm.Keyword("fail"):
+		return vm.ErrorModeFail, nil
+	case vm.Keyword("continue"):
+		return vm.ErrorModeContinue, nil
+	}
+	return vm.ErrorModeFail, vm.NewTypeError(v, "is not an error mode in "+name, vm.KeywordType)
+}
+
+// sendFn makes a native dispatching agent actions to pool
+func sendFn(name string, pool vm.AgentPool) (vm.Value, error) {
+	return vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) < 2 {
+			return vm.NIL, arityError(name, len(vs))
+		}
+		a, err := agentArg(name, vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		f, err := fnArg(name, vs[1])
+		if err != nil {
+			return vm.NIL, err
+		}
+		return a, a.Send(pool, vm.BoundFn(f), vs[2:])
+	})
+}
+
+func futureArg(name string, v vm.Value) (*vm.Future, error) {
+	f, ok := v.(*vm.Future)
+	if !ok {
+		return nil, vm.NewTypeError(v, "is not a future in "+name, vm.FutureType)
+	}
+	return f, nil
+}
+
+func watchableArg(name string, v vm.Value) (vm.Watchable, error) {
+	w, ok := v.(vm.Watchable)
+	if !ok {
+		return nil, vm.NewTypeError(v, "is not a reference in "+name, nil)
+	}
+	return w, nil
+}
+
+// installRefFns defines reference types and functions operating on them in the core namespace
+//
+//nolint
+func installRefFns(ns *vm.Namespace) {
+	atom, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) < 1 || len(vs)%2 != 1 {
+			return vm.NIL, arityError("atom", len(vs))
+		}
+		a := vm.NewAtom(vs[0])
+		for i := 1; i < len(vs); i += 2 {
+			if vs[i] != vm.Keyword("validator") {
+				continue
+			}
+			if err := a.SetValidator(vs[i+1]); err != nil {
+				return vm.NIL, err
+			}
+		}
+		return a, nil
+	})
+
+	deref, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 && len(vs) != 3 {
+			return vm.NIL, arityError("deref", len(vs))
+		}
+		if p, ok := vs[0].(vm.Pending); ok {
+			timeout := time.Duration(-1)
+			if len(vs) == 3 {
+				ms, ok := vs[1].(vm.Int)
+				if !ok {
+					return vm.NIL, vm.NewTypeError(vs[1], "can't be used as milliseconds in deref", vm.IntType)
+				}
+				timeout = time.Duration(ms) * time.Millisecond
+			}
+			val, ok, err := p.Await(timeout)
+			if !ok {
+				return vs[2], nil
+			}
+			return val, err
+		}
+		r, ok := vs[0].(vm.Reference)
+		if !ok {
+			return vm.NIL, vm.NewTypeError(vs[0], "can't be dereferenced", nil)
+		}
+		if len(vs) == 3 {
+			return vm.NIL, vm.NewTypeError(vs[0], "can't be dereferenced with a timeout", nil)
+		}
+		// reading refs in transactions can fail
+		if ref, ok := r.(*vm.Ref); ok {
+			return ref.Get()
+		}
+		return r.Deref(), nil
+	})
+
+	ref, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) < 1 || len(vs)%2 != 1 {
+			return vm.NIL, arityError("ref", len(vs))
+		}
+		r := vm.NewRef(vs[0])
+		minHistory, maxHistory := 0, vm.DefaultMaxHistory
+		for i := 1; i < len(vs); i += 2 {
+			var err error
+			switch vs[i] {
+			case vm.Keyword("validator"):
+				err = r.SetValidator(vs[i+1])
+			case vm.Keyword("min-history"):
+				minHistory, err = countArg("ref", vs[i+1])
+			case vm.Keyword("max-history"):
+				maxHistory, err = countArg("ref", vs[i+1])
+			}
+			if err != nil {
+				return vm.NIL, err
+			}
+		}
+		r.SetHistoryLimits(minHistory, maxHistory)
+		return r, nil
+	})
+
+	syncf, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("sync*", len(vs))
+		}
+		f, err := fnArg("sync*", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		return vm.RunInTransaction(f)
+	})
+
+	alter, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) < 2 {
+			return vm.NIL, arityError("alter", len(vs))
+		}
+		r, err := refArg("alter", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		f, err := fnArg("alter", vs[1])
+		if err != nil {
+			return vm.NIL, err
+		}
+		return r.Alter(f, vs[2:])
+	})
+
+	commute, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) < 2 {
+			return vm.NIL, arityError("commute", len(vs))
+		}
+		r, err := refArg("commute", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		f, err := fnArg("commute", vs[1])
+		if err != nil {
+			return vm.NIL, err
+		}
+		return r.Commute(f, vs[2:])
+	})
+
+	refSet, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 2 {
+			return vm.NIL, arityError("ref-set", len(vs))
+		}
+		r, err := refArg("ref-set", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		return r.Set(vs[1])
+	})
+
+	ensure, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("ensure", len(vs))
+		}
+		r, err := refArg("ensure", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		return r.Ensure()
+	})
+
+	refHistoryCount, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("ref-history-count", len(vs))
+		}
+		r, err := refArg("ref-history-count", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		return vm.Int(r.HistoryCount()), nil
+	})
+
+	agent, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) < 1 || len(vs)%2 != 1 {
+			return vm.NIL, arityError("agent", len(vs))
+		}
+		a := vm.NewAgent(vs[0])
+		var mode vm.Value = vm.NIL
+		for i := 1; i < len(vs); i += 2 {
+			switch vs[i] {
+			case vm.Keyword("validator"):
+				if err := a.SetValidator(vs[i+1]); err != nil {
+					return vm.NIL, err
+				}
+			case vm.Keyword("error-handler"):
+				f, err := fnArg("agent", vs[i+1])
+				if err != nil {
+					return vm.NIL, err
+				}
+				a.SetErrorHandler(f)
+				// agents with error handlers continue by default
+				a.SetErrorMode(vm.ErrorModeContinue)
+			case vm.Keyword("error-mode"):
+				mode = vs[i+1]
+			}
+		}
+		if mode != vm.NIL {
+			m, err := errorModeArg("agent", mode)
+			if err != nil {
+				return vm.NIL, err
+			}
+			a.SetErrorMode(m)
+		}
+		return a, nil
+	})
+
+	send, err := sendFn("send", vm.SendPool)
+	sendOff, err := sendFn("send-off", vm.SendOffPool)
+
+	await, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		agents, err := agentArgs("await", vs)
+		if err != nil {
+			return vm.NIL, err
+		}
+		_, err = vm.AwaitAgents(agents, -1)
+		return vm.NIL, err
+	})
+
+	awaitFor, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) < 1 {
+			return vm.NIL, arityError("await-for", len(vs))
+		}
+		ms, ok := vs[0].(vm.Int)
+		if !ok {
+			return vm.NIL, vm.NewTypeError(vs[0], "can't be used as milliseconds in await-for", vm.IntType)
+		}
+		agents, err := agentArgs("await-for", vs[1:])
+		if err != nil {
+			return vm.NIL, err
+		}
+		ok, err = vm.AwaitAgents(agents, time.Duration(ms)*time.Millisecond)
+		return vm.Boolean(ok), err
+	})
+
+	agentError, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("agent-error", len(vs))
+		}
+		a, err := agentArg("agent-error", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		if err := a.Error(); err != nil {
+			return vm.ExceptionValue(err), nil
+		}
+		return vm.NIL, nil
+	})
+
+	restartAgent, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) < 2 || len(vs)%2 != 0 {
+			return vm.NIL, arityError("restart-agent", len(vs))
+		}
+		a, err := agentArg("restart-agent", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		clear := false
+		for i := 2; i < len(vs); i += 2 {
+			if vs[i] == vm.Keyword("clear-actions") {
+				clear = vm.IsTruthy(vs[i+1])
+			}
+		}
+		if err := a.Restart(vs[1], clear); err != nil {
+			return vm.NIL, err
+		}
+		return vs[1], nil
+	})
+
+	setErrorHandler, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 2 {
+			return vm.NIL, arityError("set-error-handler!", len(vs))
+		}
+		a, err := agentArg("set-error-handler!", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		if vs[1] == vm.NIL {
+			a.SetErrorHandler(nil)
+			return vm.NIL, nil
+		}
+		f, err := fnArg("set-error-handler!", vs[1])
+		if err != nil {
+			return vm.NIL, err
+		}
+		a.SetErrorHandler(f)
+		return vm.NIL, nil
+	})
+
+	errorHandler, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("error-handler", len(vs))
+		}
+		a, err := agentArg("error-handler", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		return a.ErrorHandler(), nil
+	})
+
+	setErrorMode, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 2 {
+			return vm.NIL, arityError("set-error-mode!", len(vs))
+		}
+		a, err := agentArg("set-error-mode!", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		m, err := errorModeArg("set-error-mode!", vs[1])
+		if err != nil {
+			return vm.NIL, err
+		}
+		a.SetErrorMode(m)
+		return vm.NIL, nil
+	})
+
+	errorMode, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("error-mode", len(vs))
+		}
+		a, err := agentArg("error-mode", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		if a.ErrorMode() == vm.ErrorModeContinue {
+			return vm.Keyword("continue"), nil
+		}
+		return vm.Keyword("fail"), nil
+	})
+
+	realized, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("realized?", len(vs))
+		}
+		r, ok := vs[0].(vm.Realizable)
+		if !ok {
+			return vm.NIL, vm.NewTypeError(vs[0], "realized? not supported on this type", nil)
+		}
+		return vm.Boolean(r.IsRealized()), nil
+	})
+
+	futureCall, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("future-call", len(vs))
+		}
+		f, err := fnArg("future-call", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		return vm.NewFuture(vm.BoundFn(f)), nil
+	})
+
+	futureCancel, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("future-cancel", len(vs))
+		}
+		f, err := futureArg("future-cancel", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		return vm.Boolean(f.Cancel()), nil
+	})
+
+	futureCancelled, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("future-cancelled?", len(vs))
+		}
+		f, err := futureArg("future-cancelled?", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		return vm.Boolean(f.IsCancelled()), nil
+	})
+
+	futureDone, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("future-done?", len(vs))
+		}
+		f, err := futureArg("future-done?", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		return vm.Boolean(f.IsRealized()), nil
+	})
+
+	promise, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 0 {
+			return vm.NIL, arityError("promise", len(vs))
+		}
+		return vm.NewPromise(), nil
+	})
+
+	deliver, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 2 {
+			return vm.NIL, arityError("deliver", len(vs))
+		}
+		p, ok := vs[0].(*vm.Promise)
+		if !ok {
+			return vm.NIL, vm.NewTypeError(vs[0], "is not a promise in deliver", vm.PromiseType)
+		}
+		if !p.Deliver(vs[1]) {
+			return vm.NIL, nil
+		}
+		return p, nil
+	})
+
+	delay, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("delay*", len(vs))
+		}
+		f, err := fnArg("delay*", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		return vm.NewDelay(f), nil
+	})
+
+	force, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("force", len(vs))
+		}
+		if d, ok := vs[0].(*vm.Delay); ok {
+			return d.Force()
+		}
+		return vs[0], nil
+	})
+
+	swap, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) < 2 {
+			return vm.NIL, arityError("swap!", len(vs))
+		}
+		a, err := atomArg("swap!", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		f, err := fnArg("swap!", vs[1])
+		if err != nil {
+			return vm.NIL, err
+		}
+		_, val, err := a.Swap(f, vs[2:])
+		return val, err
+	})
+
+	swapVals, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) < 2 {
+			return vm.NIL, arityError("swap-vals!", len(vs))
+		}
+		a, err := atomArg("swap-vals!", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		f, err := fnArg("swap-vals!", vs[1])
+		if err != nil {
+			return vm.NIL, err
+		}
+		old, val, err := a.Swap(f, vs[2:])
+		if err != nil {
+			return vm.NIL, err
+		}
+		return vm.NewPersistentVector([]vm.Value{old, val}), nil
+	})
+
+	reset, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 2 {
+			return vm.NIL, arityError("reset!", len(vs))
+		}
+		a, err := atomArg("reset!", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		if _, err := a.Reset(vs[1]); err != nil {
+			return vm.NIL, err
+		}
+		return vs[1], nil
+	})
+
+	resetVals, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 2 {
+			return vm.NIL, arityError("reset-vals!", len(vs))
+		}
+		a, err := atomArg("reset-vals!", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		old, err := a.Reset(vs[1])
+		if err != nil {
+			return vm.NIL, err
+		}
+		return vm.NewPersistentVector([]vm.Value{old, vs[1]}), nil
+	})
+
+	compareAndSet, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 3 {
+			return vm.NIL, arityError("compare-and-set!", len(vs))
+		}
+		a, err := atomArg("compare-and-set!", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		ok, err := a.CompareAndSet(vs[1], vs[2])
+		return vm.Boolean(ok), err
+	})
+
+	addWatch, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 3 {
+			return vm.NIL, arityError("add-watch", len(vs))
+		}
+		w, err := watchableArg("add-watch", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		f, err := fnArg("add-watch", vs[2])
+		if err != nil {
+			return vm.NIL, err
+		}
+		w.AddWatch(vs[1], f)
+		return w, nil
+	})
+
+	removeWatch, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 2 {
+			return vm.NIL, arityError("remove-watch", len(vs))
+		}
+		w, err := watchableArg("remove-watch", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		w.RemoveWatch(vs[1])
+		return w, nil
+	})
+
+	setValidator, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 2 {
+			return vm.NIL, arityError("set-validator!", len(vs))
+		}
+		w, err := watchableArg("set-validator!", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		return vm.NIL, w.SetValidator(vs[1])
+	})
+
+	getValidator, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("get-validator", len(vs))
+		}
+		w, err := watchableArg("get-validator", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		return w.Validator(), nil
+	})
+
+	if err != nil {
+		panic("ref fns init failed")
+	}
+
+	ns.Def("Atom", vm.AtomType)
+	ns.Def("atom", atom)
+	ns.Def("deref", deref)
+	ns.Def("realized?", realized)
+	ns.Def("swap!", swap)
+	ns.Def("swap-vals!", swapVals)
+	ns.Def("reset!", reset)
+	ns.Def("reset-vals!", resetVals)
+	ns.Def("compare-and-set!", compareAndSet)
+	ns.Def("add-watch", addWatch)
+	ns.Def("remove-watch", removeWatch)
+	ns.Def("set-validator!", setValidator)
+	ns.Def("get-validator", getValidator)
+
+	ns.Def("Ref", vm.RefType)
+	ns.Def("ref", ref)
+	ns.Def("sync*", syncf)
+	ns.Def("alter", alter)
+	ns.Def("commute", commute)
+	ns.Def("ref-set", refSet)
+	ns.Def("ensure", ensure)
+	ns.Def("ref-history-count", refHistoryCount)
+
+	ns.Def("Agent", vm.AgentType)
+	ns.Def("agent", agent)
+	ns.Def("send", send)
+	ns.Def("send-off", sendOff)
+	ns.Def("await", await)
+	ns.Def("await-for", awaitFor)
+	ns.Def("agent-error", agentError)
+	ns.Def("restart-agent", restartAgent)
+	ns.Def("set-error-handler!", setErrorHandler)
+	ns.Def("error-handler", errorHandler)
+	ns.Def("set-error-mode!", setErrorMode)
+	ns.Def("error-mode", errorMode)
+
+	ns.Def("Future", vm.FutureType)
+	ns.Def("Promise", vm.PromiseType)
+	ns.Def("Delay", vm.DelayType)
+	ns.Def("future-call", futureCall)
+	ns.Def("future-cancel", futureCancel)
+	ns.Def("future-cancelled?", futureCancelled)
+	ns.Def("future-done?", futureDone)
+	ns.Def("promise", promise)
+	ns.Def("deliver", deliver)
+	ns.Def("delay*", delay)
+	ns.Def("force", force)
+}
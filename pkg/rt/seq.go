@@ -0,0 +1,437 @@
+/*
+ * Copyright (c) 2021 Marcin Gasperowicz <xnooga@gmail.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+ * documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit
+ * persons to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+ * Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+ * WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+ * OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package rt
+
+import (
+	"github.com/nooga/let-go/pkg/vm"
+)
+
+// chunkSize is the number of elements realized at once by chunked lazy seqs
+const chunkSize = 32
+
+// takeColl returns the collection a lazy seq fn closed over and clears the captured variable,
+// otherwise the closure would keep the head of coll alive while the fn walks it
+func takeColl(coll *vm.Value) vm.Value {
+	c := *coll
+	*coll = nil
+	return c
+}
+
+func lazyMap(f vm.Fn, coll vm.Value) vm.Seq {
+	return vm.NewLazySeq(func() (vm.Value, error) {
+		s, err := vm.ToSeq(takeColl(&coll))
+		if err != nil || s == vm.EmptyList {
+			return vm.EmptyList, err
+		}
+		if c, ok := s.(vm.Chunked); ok {
+			chunk := c.ChunkFirst()
+			out := make([]vm.Value, len(chunk))
+			for i := range chunk {
+				out[i], err = f.Invoke([]vm.Value{chunk[i]})
+				if err != nil {
+					return vm.NIL, err
+				}
+			}
+			return vm.NewChunkedSeq(out, lazyMap(f, c.ChunkMore())), nil
+		}
+		v, err := f.Invoke([]vm.Value{s.First()})
+		if err != nil {
+			return vm.NIL, err
+		}
+		return vm.NewCons(v, lazyMap(f, s.More())), nil
+	})
+}
+
+func lazyMapN(f vm.Fn, colls []vm.Value) vm.Seq {
+	return vm.NewLazySeq(func() (vm.Value, error) {
+		firsts := make([]vm.Value, len(colls))
+		mores := make([]vm.Value, len(colls))
+		for i := range colls {
+			s, err := vm.ToSeq(colls[i])
+			if err != nil || s == vm.EmptyList {
+				return vm.EmptyList, err
+			}
+			firsts[i] = s.First()
+			mores[i] = s.More()
+		}
+		v, err := f.Invoke(firsts)
+		if err != nil {
+			return vm.NIL, err
+		}
+		return vm.NewCons(v, lazyMapN(f, mores)), nil
+	})
+}
+
+func lazyFilter(pred vm.Fn, coll vm.Value) vm.Seq {
+	return vm.NewLazySeq(func() (vm.Value, error) {
+		s, err := vm.ToSeq(takeColl(&coll))
+		if err != nil {
+			return vm.NIL, err
+		}
+		for s != vm.EmptyList {
+			if c, ok := s.(vm.Chunked); ok {
+				var out []vm.Value
+				for _, x := range c.ChunkFirst() {
+					keep, err := pred.Invoke([]vm.Value{x})
+					if err != nil {
+						return vm.NIL, err
+					}
+					if vm.IsTruthy(keep) {
+						out = append(out, x)
+					}
+				}
+				if len(out) > 0 {
+					return vm.NewChunkedSeq(out, lazyFilter(pred, c.ChunkMore())), nil
+				}
+				if s, err = vm.ToSeq(c.ChunkMore()); err != nil {
+					return vm.NIL, err
+				}
+				continue
+			}
+			x := s.First()
+			keep, err := pred.Invoke([]vm.Value{x})
+			if err != nil {
+				return vm.NIL, err
+			}
+			if vm.IsTruthy(keep) {
+				return vm.NewCons(x, lazyFilter(pred, s.More())), nil
+			}
+			if s, err = vm.ToSeq(s.More()); err != nil {
+				return vm.NIL, err
+			}
+		}
+		return vm.EmptyList, nil
+	})
+}
+
+func lazyTake(n int, coll vm.Value) vm.Seq {
+	return vm.NewLazySeq(func() (vm.Value, error) {
+		if n <= 0 {
+			return vm.EmptyList, nil
+		}
+		s, err := vm.ToSeq(takeColl(&coll))
+		if err != nil || s == vm.EmptyList {
+			return vm.EmptyList, err
+		}
+		if c, ok := s.(vm.Chunked); ok {
+			chunk := c.ChunkFirst()
+			if len(chunk) >= n {
+				return vm.NewChunkedSeq(chunk[:n], vm.EmptyList), nil
+			}
+			return vm.NewChunkedSeq(chunk, lazyTake(n-len(chunk), c.ChunkMore())), nil
+		}
+		return vm.NewCons(s.First(), lazyTake(n-1, s.More())), nil
+	})
+}
+
+func lazyDrop(n int, coll vm.Value) vm.Seq {
+	return vm.NewLazySeq(func() (vm.Value, error) {
+		s, err := vm.ToSeq(takeColl(&coll))
+		if err != nil {
+			return vm.NIL, err
+		}
+		for i := 0; i < n && s != vm.EmptyList; i++ {
+			s = s.Next()
+		}
+		return s, nil
+	})
+}
+
+func lazyTakeWhile(pred vm.Fn, coll vm.Value) vm.Seq {
+	return vm.NewLazySeq(func() (vm.Value, error) {
+		s, err := vm.ToSeq(takeColl(&coll))
+		if err != nil || s == vm.EmptyList {
+			return vm.EmptyList, err
+		}
+		x := s.First()
+		keep, err := pred.Invoke([]vm.Value{x})
+		if err != nil {
+			return vm.NIL, err
+		}
+		if !vm.IsTruthy(keep) {
+			return vm.EmptyList, nil
+		}
+		return vm.NewCons(x, lazyTakeWhile(pred, s.More())), nil
+	})
+}
+
+// lazyRange produces numbers from start by step in chunks, end of nil makes the range infinite
+func lazyRange(start vm.Value, end vm.Value, step vm.Value) vm.Seq {
+	return vm.NewLazySeq(func() (vm.Value, error) {
+		dir, _, err := vm.Compare(step, vm.Int(0))
+		if err != nil {
+			return vm.NIL, err
+		}
+		chunk := make([]vm.Value, 0, chunkSize)
+		n := start
+		for len(chunk) < chunkSize {
+			if end != nil && dir != 0 {
+				c, ok, err := vm.Compare(n, end)
+				if err != nil {
+					return vm.NIL, err
+				}
+				if !ok || c*dir >= 0 {
+					break
+				}
+			}
+			chunk = append(chunk, n)
+			if n, err = vm.AddP(n, step); err != nil {
+				return vm.NIL, err
+			}
+		}
+		if len(chunk) == 0 {
+			return vm.EmptyList, nil
+		}
+		if len(chunk) < chunkSize {
+			return vm.NewChunkedSeq(chunk, vm.EmptyList), nil
+		}
+		return vm.NewChunkedSeq(chunk, lazyRange(n, end, step)), nil
+	})
+}
+
+func lazyIterate(f vm.Fn, x vm.Value) vm.Seq {
+	return vm.NewCons(x, vm.NewLazySeq(func() (vm.Value, error) {
+		next, err := f.Invoke([]vm.Value{x})
+		if err != nil {
+			return vm.NIL, err
+		}
+		return lazyIterate(f, next), nil
+	}))
+}
+
+// lazyRepeat is an infinite seq of x, the seq loops back on itself so it takes constant memory
+func lazyRepeat(x vm.Value) vm.Seq {
+	chunk := make([]vm.Value, chunkSize)
+	for i := range chunk {
+		chunk[i] = x
+	}
+	var self *vm.LazySeq
+	self = vm.NewLazySeq(func() (vm.Value, error) {
+		return vm.NewChunkedSeq(chunk, self), nil
+	})
+	return self
+}
+
+func lazyCycle(coll vm.Value) vm.Seq {
+	return vm.NewLazySeq(func() (vm.Value, error) {
+		s, err := vm.ToSeq(coll)
+		if err != nil || s == vm.EmptyList {
+			return vm.EmptyList, err
+		}
+		return lazyConcat([]vm.Value{s, lazyCycle(coll)}), nil
+	})
+}
+
+func lazyConcat(colls []vm.Value) vm.Seq {
+	return vm.NewLazySeq(func() (vm.Value, error) {
+		for len(colls) > 0 {
+			s, err := vm.ToSeq(colls[0])
+			if err != nil {
+				return vm.NIL, err
+			}
+			if s == vm.EmptyList {
+				colls = colls[1:]
+				continue
+			}
+			if c, ok := s.(vm.Chunked); ok {
+				return vm.NewChunkedSeq(c.ChunkFirst(), lazyConcat(append([]vm.Value{c.ChunkMore()}, colls[1:]...))), nil
+			}
+			return vm.NewCons(s.First(), lazyConcat(append([]vm.Value{s.More()}, colls[1:]...))), nil
+		}
+		return vm.EmptyList, nil
+	})
+}
+
+func fnArg(name string, v vm.Value) (vm.Fn, error) {
+	f, ok := v.(vm.Fn)
+	if !ok {
+		return nil, vm.NewTypeError(v, "is not a function in "+name, nil)
+	}
+	return f, nil
+}
+
+func countArg(name string, v vm.Value) (int, error) {
+	n, ok := v.(vm.Int)
+	if !ok {
+		return 0, vm.NewTypeError(v, "can't be used as a count in "+name, vm.IntType)
+	}
+	return int(n), nil
+}
+
+// installSeqFns defines lazy sequence functions in the core namespace
+//
+//nolint
+func installSeqFns(ns *vm.Namespace) {
+	lazySeq, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("lazy-seq*", len(vs))
+		}
+		f, err := fnArg("lazy-seq*", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		return vm.NewLazySeq(func() (vm.Value, error) {
+			return f.Invoke([]vm.Value{})
+		}), nil
+	})
+
+	mapf, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) < 2 {
+			return vm.NIL, arityError("map", len(vs))
+		}
+		f, err := fnArg("map", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		if len(vs) == 2 {
+			return lazyMap(f, vs[1]), nil
+		}
+		return lazyMapN(f, vs[1:]), nil
+	})
+
+	filter, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 2 {
+			return vm.NIL, arityError("filter", len(vs))
+		}
+		f, err := fnArg("filter", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		return lazyFilter(f, vs[1]), nil
+	})
+
+	take, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 2 {
+			return vm.NIL, arityError("take", len(vs))
+		}
+		n, err := countArg("take", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		return lazyTake(n, vs[1]), nil
+	})
+
+	drop, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 2 {
+			return vm.NIL, arityError("drop", len(vs))
+		}
+		n, err := countArg("drop", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		return lazyDrop(n, vs[1]), nil
+	})
+
+	takeWhile, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 2 {
+			return vm.NIL, arityError("take-while", len(vs))
+		}
+		f, err := fnArg("take-while", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		return lazyTakeWhile(f, vs[1]), nil
+	})
+
+	rangef, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		var start, end, step vm.Value = vm.Int(0), nil, vm.Int(1)
+		switch len(vs) {
+		case 0:
+		case 1:
+			end = vs[0]
+		case 2:
+			start, end = vs[0], vs[1]
+		case 3:
+			start, end, step = vs[0], vs[1], vs[2]
+		default:
+			return vm.NIL, arityError("range", len(vs))
+		}
+		for _, v := range vs {
+			if !vm.IsNumber(v) {
+				return vm.NIL, vm.NewTypeError(v, "can't be used as a number in range", nil)
+			}
+		}
+		return lazyRange(start, end, step), nil
+	})
+
+	iterate, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 2 {
+			return vm.NIL, arityError("iterate", len(vs))
+		}
+		f, err := fnArg("iterate", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		return lazyIterate(f, vs[1]), nil
+	})
+
+	repeat, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		switch len(vs) {
+		case 1:
+			return lazyRepeat(vs[0]), nil
+		case 2:
+			n, err := countArg("repeat", vs[0])
+			if err != nil {
+				return vm.NIL, err
+			}
+			return lazyTake(n, lazyRepeat(vs[1])), nil
+		}
+		return vm.NIL, arityError("repeat", len(vs))
+	})
+
+	cycle, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("cycle", len(vs))
+		}
+		return lazyCycle(vs[0]), nil
+	})
+
+	concat, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		colls := make([]vm.Value, len(vs))
+		copy(colls, vs)
+		return lazyConcat(colls), nil
+	})
+
+	isSeq, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("seq?", len(vs))
+		}
+		switch vs[0].Type() {
+		case vm.ListType, vm.ConsType, vm.LazySeqType, vm.ChunkedSeqType, vm.VectorSeqType:
+			return vm.TRUE, nil
+		}
+		return vm.FALSE, nil
+	})
+
+	if err != nil {
+		panic("seq fns init failed")
+	}
+
+	ns.Def("lazy-seq*", lazySeq)
+	ns.Def("seq?", isSeq)
+	ns.Def("map", mapf)
+	ns.Def("filter", filter)
+	ns.Def("take", take)
+	ns.Def("drop", drop)
+	ns.Def("take-while", takeWhile)
+	ns.Def("range", rangef)
+	ns.Def("iterate", iterate)
+	ns.Def("repeat", repeat)
+	ns.Def("cycle", cycle)
+	ns.Def("concat", concat)
+}
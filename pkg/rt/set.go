@@ -0,0 +1,305 @@
+/*
+ * Copyright (c) 2021 Marcin Gasperowicz <xnooga@gmail.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+ * documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit
+ * persons to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+ * Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+ * WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+ * OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package rt
+
+import (
+	"github.com/nooga/let-go/pkg/vm"
+)
+
+// installSetFns defines functions creating and querying sets in the core namespace
+//
+//nolint
+func installSetFns(ns *vm.Namespace) {
+	hashSet, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		return vm.NewSet(vs), nil
+	})
+
+	set, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("set", len(vs))
+		}
+		if s, ok := vs[0].(*vm.Set); ok {
+			return s, nil
+		}
+		elems, err := seqValues(vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		return vm.NewSet(elems), nil
+	})
+
+	isSet, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("set?", len(vs))
+		}
+		_, ok := vs[0].(*vm.Set)
+		return vm.Boolean(ok), nil
+	})
+
+	disj, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) < 1 {
+			return vm.NIL, arityError("disj", len(vs))
+		}
+		if vs[0] == vm.NIL {
+			return vm.NIL, nil
+		}
+		s, err := setArg("disj", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		for _, k := range vs[1:] {
+			s = s.Disj(k)
+		}
+		return s, nil
+	})
+
+	contains, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 2 {
+			return vm.NIL, arityError("contains?", len(vs))
+		}
+		if coll, ok := vs[0].(vm.Keyed); ok {
+			return vm.Boolean(coll.Contains(vs[1])), nil
+		}
+		if vs[0] == vm.NIL {
+			return vm.FALSE, nil
+		}
+		return vm.NIL, vm.NewTypeError(vs[0], "contains? not supported on this type", nil)
+	})
+
+	if err != nil {
+		panic("set fns init failed")
+	}
+
+	ns.Def("hash-set", hashSet)
+	ns.Def("set", set)
+	ns.Def("set?", isSet)
+	ns.Def("disj", disj)
+	ns.Def("contains?", contains)
+}
+
+func setArg(name string, v vm.Value) (*vm.Set, error) {
+	s, ok := v.(*vm.Set)
+	if !ok {
+		return nil, vm.NewTypeError(v, "is not a set in "+name, vm.SetType)
+	}
+	return s, nil
+}
+
+// relationArg returns maps making up a relation given as a set or any other collection of maps
+func relationArg(name string, v vm.Value) ([]*vm.Map, error) {
+	elems, err := seqValues(v)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]*vm.Map, len(elems))
+	for i := range elems {
+		m, ok := elems[i].(*vm.Map)
+		if !ok {
+			return nil, vm.NewTypeError(elems[i], "is not a map in "+name, vm.MapType)
+		}
+		ret[i] = m
+	}
+	return ret, nil
+}
+
+// selectKeys returns a map holding only entries of m under ks
+func selectKeys(m *vm.Map, ks []vm.Value) *vm.Map {
+	ret := vm.EmptyMap
+	for _, k := range ks {
+		if m.Contains(k) {
+			ret = ret.Assoc(k, m.ValueAt(k)).(*vm.Map)
+		}
+	}
+	return ret
+}
+
+// installSetNS registers the set namespace holding set algebra and relational functions
+//
+//nolint
+func installSetNS() {
+	ns := vm.NewNamespace("set")
+	ns.Refer(CoreNS, "", true)
+
+	union, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		ret := vm.EmptySet
+		for i := range vs {
+			if vs[i] == vm.NIL {
+				continue
+			}
+			s, err := setArg("union", vs[i])
+			if err != nil {
+				return vm.NIL, err
+			}
+			if i == 0 {
+				ret = s
+				continue
+			}
+			s.Each(func(v vm.Value) {
+				ret = ret.Conj(v)
+			})
+		}
+		return ret, nil
+	})
+
+	intersection, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) < 1 {
+			return vm.NIL, arityError("intersection", len(vs))
+		}
+		ret, err := setArg("intersection", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		for _, x := range vs[1:] {
+			s, err := setArg("intersection", x)
+			if err != nil {
+				return vm.NIL, err
+			}
+			ret.Each(func(v vm.Value) {
+				if !s.Contains(v) {
+					ret = ret.Disj(v)
+				}
+			})
+		}
+		return ret, nil
+	})
+
+	difference, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) < 1 {
+			return vm.NIL, arityError("difference", len(vs))
+		}
+		ret, err := setArg("difference", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		for _, x := range vs[1:] {
+			s, err := setArg("difference", x)
+			if err != nil {
+				return vm.NIL, err
+			}
+			s.Each(func(v vm.Value) {
+				ret = ret.Disj(v)
+			})
+		}
+		return ret, nil
+	})
+
+	sel, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 2 {
+			return vm.NIL, arityError("select", len(vs))
+		}
+		pred, err := fnArg("select", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		ret, err := setArg("select", vs[1])
+		if err != nil {
+			return vm.NIL, err
+		}
+		for _, v := range ret.Unbox().([]vm.Value) {
+			keep, err := pred.Invoke([]vm.Value{v})
+			if err != nil {
+				return vm.NIL, err
+			}
+			if !vm.IsTruthy(keep) {
+				ret = ret.Disj(v)
+			}
+		}
+		return ret, nil
+	})
+
+	project, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 2 {
+			return vm.NIL, arityError("project", len(vs))
+		}
+		rel, err := relationArg("project", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		ks, err := seqValues(vs[1])
+		if err != nil {
+			return vm.NIL, err
+		}
+		ret := vm.EmptySet
+		for _, m := range rel {
+			ret = ret.Conj(selectKeys(m, ks))
+		}
+		return ret, nil
+	})
+
+	index, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 2 {
+			return vm.NIL, arityError("index", len(vs))
+		}
+		rel, err := relationArg("index", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		ks, err := seqValues(vs[1])
+		if err != nil {
+			return vm.NIL, err
+		}
+		ret := vm.EmptyMap
+		for _, m := range rel {
+			k := selectKeys(m, ks)
+			group, ok := ret.ValueAtOr(k, vm.EmptySet).(*vm.Set)
+			if !ok {
+				group = vm.EmptySet
+			}
+			ret = ret.Assoc(k, group.Conj(m)).(*vm.Map)
+		}
+		return ret, nil
+	})
+
+	renameKeys, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 2 {
+			return vm.NIL, arityError("rename-keys", len(vs))
+		}
+		m, ok := vs[0].(*vm.Map)
+		if !ok {
+			return vm.NIL, vm.NewTypeError(vs[0], "is not a map in rename-keys", vm.MapType)
+		}
+		kmap, ok := vs[1].(*vm.Map)
+		if !ok {
+			return vm.NIL, vm.NewTypeError(vs[1], "is not a map in rename-keys", vm.MapType)
+		}
+		ret := m
+		kmap.Each(func(old, _ vm.Value) {
+			ret = ret.Dissoc(old).(*vm.Map)
+		})
+		kmap.Each(func(old, nu vm.Value) {
+			if m.Contains(old) {
+				ret = ret.Assoc(nu, m.ValueAt(old)).(*vm.Map)
+			}
+		})
+		return ret, nil
+	})
+
+	if err != nil {
+		panic("set namespace init failed")
+	}
+
+	ns.Def("union", union)
+	ns.Def("intersection", intersection)
+	ns.Def("difference", difference)
+	ns.Def("select", sel)
+	ns.Def("project", project)
+	ns.Def("index", index)
+	ns.Def("rename-keys", renameKeys)
+
+	RegisterNS(ns)
+}
@@ -0,0 +1,181 @@
+/*
+ * Copyright (c) 2021 Marcin Gasperowicz <xnooga@gmail.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+ * documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit
+ * persons to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+ * Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+ * WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+ * OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package rt
+
+import (
+	"fmt"
+	"github.com/nooga/let-go/pkg/vm"
+	"io"
+	"strings"
+)
+
+// installVarFns defines functions operating on vars and their dynamic bindings in the core namespace
+//
+//nolint
+func installVarFns(ns *vm.Namespace) {
+	pushThreadBindings, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("push-thread-bindings", len(vs))
+		}
+		m, ok := vs[0].(*vm.Map)
+		if !ok {
+			return vm.NIL, vm.NewTypeError(vs[0], "can't be used as bindings", vm.MapType)
+		}
+		vals := map[*vm.Var]vm.Value{}
+		var err error
+		m.Each(func(k vm.Value, v vm.Value) {
+			va, ok := k.(*vm.Var)
+			if !ok {
+				err = vm.NewTypeError(k, "can't be bound", nil)
+				return
+			}
+			vals[va] = v
+		})
+		if err != nil {
+			return vm.NIL, err
+		}
+		return vm.NIL, vm.PushBindings(vals)
+	})
+
+	popThreadBindings, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 0 {
+			return vm.NIL, arityError("pop-thread-bindings", len(vs))
+		}
+		return vm.NIL, vm.PopBindings()
+	})
+
+	getThreadBindings, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 0 {
+			return vm.NIL, arityError("get-thread-bindings", len(vs))
+		}
+		b := vm.CurrentBindings()
+		if b == nil {
+			return vm.NewMap(nil)
+		}
+		vars := b.Vars()
+		kvs := make([]vm.Value, 0, len(vars)*2)
+		for _, v := range vars {
+			val, _ := b.Get(v)
+			kvs = append(kvs, v, val)
+		}
+		return vm.NewMap(kvs)
+	})
+
+	threadBound, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		for i := range vs {
+			v, ok := vs[i].(*vm.Var)
+			if !ok {
+				return vm.NIL, vm.NewTypeError(vs[i], "is not a var", nil)
+			}
+			if !v.IsThreadBound() {
+				return vm.FALSE, nil
+			}
+		}
+		return vm.TRUE, nil
+	})
+
+	varSet, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 2 {
+			return vm.NIL, arityError("var-set", len(vs))
+		}
+		v, ok := vs[0].(*vm.Var)
+		if !ok {
+			return vm.NIL, vm.NewTypeError(vs[0], "is not a var", nil)
+		}
+		// like set! in clojure this only changes thread bindings, roots are changed with alter-var-root
+		if !v.IsThreadBound() {
+			return vm.NIL, vm.NewExecutionError(fmt.Sprintf("can't change root binding of %s with set!", v))
+		}
+		v.Set(vs[1])
+		return vs[1], nil
+	})
+
+	alterVarRoot, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) < 2 {
+			return vm.NIL, arityError("alter-var-root", len(vs))
+		}
+		v, ok := vs[0].(*vm.Var)
+		if !ok {
+			return vm.NIL, vm.NewTypeError(vs[0], "is not a var", nil)
+		}
+		f, err := fnArg("alter-var-root", vs[1])
+		if err != nil {
+			return vm.NIL, err
+		}
+		return v.AlterRoot(func(root vm.Value) (vm.Value, error) {
+			return f.Invoke(append([]vm.Value{root}, vs[2:]...))
+		})
+	})
+
+	boundFn, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("bound-fn*", len(vs))
+		}
+		f, err := fnArg("bound-fn*", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		return vm.BoundFn(f), nil
+	})
+
+	withOutStr, err := vm.NativeFnType.Wrap(func(vs []vm.Value) (vm.Value, error) {
+		if len(vs) != 1 {
+			return vm.NIL, arityError("with-out-str*", len(vs))
+		}
+		f, err := fnArg("with-out-str*", vs[0])
+		if err != nil {
+			return vm.NIL, err
+		}
+		b := &strings.Builder{}
+		if err := vm.PushBindings(map[*vm.Var]vm.Value{Out: vm.NewBoxed(b)}); err != nil {
+			return vm.NIL, err
+		}
+		_, err = f.Invoke([]vm.Value{})
+		perr := vm.PopBindings()
+		if err != nil {
+			return vm.NIL, err
+		}
+		if perr != nil {
+			return vm.NIL, perr
+		}
+		return vm.String(b.String()), nil
+	})
+
+	if err != nil {
+		panic("var fns init failed")
+	}
+
+	ns.Def("push-thread-bindings", pushThreadBindings)
+	ns.Def("pop-thread-bindings", popThreadBindings)
+	ns.Def("get-thread-bindings", getThreadBindings)
+	ns.Def("thread-bound?", threadBound)
+	ns.Def("var-set", varSet)
+	ns.Def("alter-var-root", alterVarRoot)
+	ns.Def("bound-fn*", boundFn)
+	ns.Def("with-out-str*", withOutStr)
+}
+
+// writer returns the writer held by var v, like *out* or *err*
+func writer(v *vm.Var) (io.Writer, error) {
+	val := v.Deref()
+	w, ok := val.Unbox().(io.Writer)
+	if !ok {
+		return nil, vm.NewTypeError(val, "can't be used as a writer in "+v.String(), nil)
+	}
+	return w, nil
+}
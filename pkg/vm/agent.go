@@ -0,0 +1,370 @@
+/*
+ * Copyright (c) 2021 Marcin Gasperowicz <xnooga@gmail.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+ * documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit
+ * persons to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+ * Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+ * WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+ * OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package vm
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"sync"
+	"time"
+)
+
+type theAgentType struct{}
+
+func (t *theAgentType) String() string     { return t.Name() }
+func (t *theAgentType) Type() ValueType    { return TypeType }
+func (t *theAgentType) Unbox() interface{} { return reflect.TypeOf(t) }
+
+func (t *theAgentType) Name() string { return "let-go.lang.Agent" }
+func (t *theAgentType) Box(b interface{}) (Value, error) {
+	return NIL, NewTypeError(b, "can't be boxed as", t)
+}
+
+// AgentType is the type of Agents
+var AgentType *theAgentType
+
+func init() {
+	AgentType = &theAgentType{}
+}
+
+// AgentPool runs agent actions
+type AgentPool interface {
+	submit(func())
+}
+
+// boundedPool runs tasks on a fixed number of goroutines, tasks wait in an unbounded queue
+type boundedPool struct {
+	size  int
+	start sync.Once
+	mu    sync.Mutex
+	cond  *sync.Cond
+	tasks []func()
+}
+
+func newBoundedPool(size int) *boundedPool {
+	p := &boundedPool{size: size}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+func (p *boundedPool) submit(task func()) {
+	p.start.Do(func() {
+		for i := 0; i < p.size; i++ {
+			go p.work()
+		}
+	})
+	p.mu.Lock()
+	p.tasks = append(p.tasks, task)
+	p.mu.Unlock()
+	p.cond.Signal()
+}
+
+func (p *boundedPool) work() {
+	for {
+		p.mu.Lock()
+		for len(p.tasks) == 0 {
+			p.cond.Wait()
+		}
+		task := p.tasks[0]
+		p.tasks[0] = nil
+		p.tasks = p.tasks[1:]
+		p.mu.Unlock()
+		task()
+	}
+}
+
+// unboundedPool runs every task on a new goroutine
+type unboundedPool struct{}
+
+func (p unboundedPool) submit(task func()) {
+	go task()
+}
+
+// SendPool runs actions dispatched with send, they are expected to be CPU bound
+var SendPool AgentPool = newBoundedPool(runtime.NumCPU() + 2)
+
+// SendOffPool runs actions dispatched with send-off, they may block on I/O
+var SendOffPool AgentPool = unboundedPool{}
+
+// ErrorMode tells what an Agent does after an action fails
+type ErrorMode int
+
+const (
+	// ErrorModeFail makes the agent stop until it's restarted
+	ErrorModeFail ErrorMode = iota
+	// ErrorModeContinue makes the agent ignore the error and run following actions
+	ErrorModeContinue
+)
+
+// agentAction is an action waiting in the queue of an Agent, awaits are actions with a done channel
+type agentAction struct {
+	fn   Fn
+	args []Value
+	pool AgentPool
+	done chan struct{}
+}
+
+// agentActions maps goroutine ids to sends held until the agent action running on the goroutine finishes
+var agentActions sync.Map
+
+// Agent is a reference changed asynchronously by actions. Actions sent to an agent run one at a time
+// in the order they were sent, on one of the pools.
+type Agent struct {
+	watchers
+	lock         sync.Mutex
+	state        Value
+	queue        []*agentAction
+	running      bool
+	err          error
+	errorHandler Fn
+	errorMode    ErrorMode
+}
+
+// NewAgent creates an Agent holding val
+func NewAgent(val Value) *Agent {
+	return &Agent{state: val}
+}
+
+// Type implements Value
+func (a *Agent) Type() ValueType { return AgentType }
+
+// Unbox implements Value
+func (a *Agent) Unbox() interface{} { return a }
+
+func (a *Agent) String() string {
+	return fmt.Sprintf("<agent %s>", a.Deref())
+}
+
+// Deref implements Reference
+func (a *Agent) Deref() Value {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.state
+}
+
+// SetValidator implements Watchable, the current value must pass the new validator
+func (a *Agent) SetValidator(v Value) error {
+	old := a.Validator()
+	if err := a.setValidator(v); err != nil {
+		return err
+	}
+	if err := a.validate(a.Deref()); err != nil {
+		_ = a.setValidator(old)
+		return err
+	}
+	return nil
+}
+
+// Error returns the error which stopped the agent or nil if it's running fine
+func (a *Agent) Error() error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.err
+}
+
+// SetErrorHandler sets fn called with the agent and the error when an action fails, nil removes the handler
+func (a *Agent) SetErrorHandler(f Fn) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.errorHandler = f
+}
+
+// ErrorHandler returns the error handler or nil
+func (a *Agent) ErrorHandler() Value {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.errorHandler == nil {
+		return NIL
+	}
+	return a.errorHandler
+}
+
+// SetErrorMode sets what happens after an action fails
+func (a *Agent) SetErrorMode(mode ErrorMode) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.errorMode = mode
+}
+
+// ErrorMode returns what happens after an action fails
+func (a *Agent) ErrorMode() ErrorMode {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.errorMode
+}
+
+// Send queues (f state args...) to run on pool. Sends made by agent actions and transactions are held
+// until the action finishes or the transaction commits.
+func (a *Agent) Send(pool AgentPool, f Fn, args []Value) error {
+	if err := a.Error(); err != nil {
+		return NewExecutionError("agent is failed, needs restart").Wrap(err)
+	}
+	act := &agentAction{fn: f, args: args, pool: pool}
+	if t := currentTransaction(); t != nil {
+		t.afterCommit = append(t.afterCommit, func() { a.enqueue(act) })
+		return nil
+	}
+	if held, ok := agentActions.Load(goroutineID()); ok {
+		h := held.(*[]func())
+		*h = append(*h, func() { a.enqueue(act) })
+		return nil
+	}
+	a.enqueue(act)
+	return nil
+}
+
+func (a *Agent) enqueue(act *agentAction) {
+	a.lock.Lock()
+	a.queue = append(a.queue, act)
+	start := !a.running && a.err == nil
+	if start {
+		a.running = true
+	}
+	a.lock.Unlock()
+	if start {
+		act.pool.submit(a.runNext)
+	}
+}
+
+// runNext runs the first queued action and schedules the next one
+func (a *Agent) runNext() {
+	a.lock.Lock()
+	act := a.queue[0]
+	a.queue[0] = nil
+	a.queue = a.queue[1:]
+	a.lock.Unlock()
+
+	a.execute(act)
+
+	a.lock.Lock()
+	if len(a.queue) == 0 || a.err != nil {
+		a.running = false
+		a.lock.Unlock()
+		return
+	}
+	next := a.queue[0]
+	a.lock.Unlock()
+	next.pool.submit(a.runNext)
+}
+
+func (a *Agent) execute(act *agentAction) {
+	if act.done != nil {
+		close(act.done)
+		return
+	}
+	gid := goroutineID()
+	var held []func()
+	agentActions.Store(gid, &held)
+	err := a.apply(act)
+	agentActions.Delete(gid)
+	if err != nil {
+		a.fail(err)
+		return
+	}
+	for _, send := range held {
+		send()
+	}
+}
+
+// apply runs the action and sets the new state
+func (a *Agent) apply(act *agentAction) error {
+	old := a.Deref()
+	val, err := act.fn.Invoke(append([]Value{old}, act.args...))
+	if err != nil {
+		return err
+	}
+	if err := a.validate(val); err != nil {
+		return err
+	}
+	a.lock.Lock()
+	a.state = val
+	a.lock.Unlock()
+	return a.notifyWatches(a, old, val)
+}
+
+// fail reports err to the error handler and stops the agent unless it should continue
+func (a *Agent) fail(err error) {
+	a.lock.Lock()
+	handler, mode := a.errorHandler, a.errorMode
+	if mode == ErrorModeFail {
+		a.err = err
+	}
+	a.lock.Unlock()
+	if handler != nil {
+		// errors thrown by the handler have nowhere to go
+		_, _ = handler.Invoke([]Value{a, ExceptionValue(err)})
+	}
+}
+
+// Restart clears the error of a failed agent and sets its state, queued actions run again unless clearActions is set
+func (a *Agent) Restart(val Value, clearActions bool) error {
+	if a.Error() == nil {
+		return NewExecutionError("agent does not need a restart")
+	}
+	if err := a.validate(val); err != nil {
+		return err
+	}
+	a.lock.Lock()
+	old := a.state
+	a.state = val
+	a.err = nil
+	if clearActions {
+		a.queue = nil
+	}
+	var next *agentAction
+	if len(a.queue) > 0 && !a.running {
+		a.running = true
+		next = a.queue[0]
+	}
+	a.lock.Unlock()
+	if next != nil {
+		next.pool.submit(a.runNext)
+	}
+	return a.notifyWatches(a, old, val)
+}
+
+// AwaitAgents waits until all actions sent to agents so far are done, negative timeout waits indefinitely.
+// It returns false if the timeout passed first.
+func AwaitAgents(agents []*Agent, timeout time.Duration) (bool, error) {
+	if _, ok := agentActions.Load(goroutineID()); ok {
+		return false, NewExecutionError("can't await in agent action")
+	}
+	dones := make([]chan struct{}, len(agents))
+	for i, a := range agents {
+		if err := a.Error(); err != nil {
+			return false, NewExecutionError("agent is failed, needs restart").Wrap(err)
+		}
+		dones[i] = make(chan struct{})
+		a.enqueue(&agentAction{pool: SendPool, done: dones[i]})
+	}
+	var deadline <-chan time.Time
+	if timeout >= 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		deadline = t.C
+	}
+	for _, done := range dones {
+		select {
+		case <-done:
+		case <-deadline:
+			return false, nil
+		}
+	}
+	return true, nil
+}
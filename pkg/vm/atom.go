@@ -0,0 +1,150 @@
+/*
+ * Copyright (c) 2021 Marcin Gasperowicz <xnooga@gmail.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+ * documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit
+ * persons to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+ * Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+ * WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+ * OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package vm
+
+import (
+	"fmt"
+	"reflect"
+)
+
+type theAtomType struct{}
+
+func (t *theAtomType) String() string     { return t.Name() }
+func (t *theAtomType) Type() ValueType    { return TypeType }
+func (t *theAtomType) Unbox() interface{} { return reflect.TypeOf(t) }
+
+func (t *theAtomType) Name() string { return "let-go.lang.Atom" }
+func (t *theAtomType) Box(b interface{}) (Value, error) {
+	return NIL, NewTypeError(b, "can't be boxed as", t)
+}
+
+// AtomType is the type of Atoms
+var AtomType *theAtomType
+
+func init() {
+	AtomType = &theAtomType{}
+}
+
+// Atom is a reference which can be changed atomically from many goroutines
+type Atom struct {
+	watchers
+	state   Value
+	version uint64
+}
+
+// NewAtom creates a new Atom holding val
+func NewAtom(val Value) *Atom {
+	return &Atom{state: val}
+}
+
+// Type implements Value
+func (a *Atom) Type() ValueType { return AtomType }
+
+// Unbox implements Value
+func (a *Atom) Unbox() interface{} { return a }
+
+func (a *Atom) String() string {
+	return fmt.Sprintf("<atom %s>", a.Deref())
+}
+
+// Deref implements Reference
+func (a *Atom) Deref() Value {
+	v, _ := a.current()
+	return v
+}
+
+func (a *Atom) current() (Value, uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.state, a.version
+}
+
+// SetValidator implements Watchable, the current value must pass the new validator
+func (a *Atom) SetValidator(v Value) error {
+	old := a.Validator()
+	if err := a.setValidator(v); err != nil {
+		return err
+	}
+	if err := a.validate(a.Deref()); err != nil {
+		_ = a.setValidator(old)
+		return err
+	}
+	return nil
+}
+
+// commit sets the state to val if it wasn't changed since version was read
+func (a *Atom) commit(val Value, version uint64) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.version != version {
+		return false
+	}
+	a.state = val
+	a.version++
+	return true
+}
+
+// Swap sets the value to (f old args...) and returns old and new value, f may be called
+// more than once when other goroutines change the atom concurrently
+func (a *Atom) Swap(f Fn, args []Value) (Value, Value, error) {
+	fargs := make([]Value, len(args)+1)
+	copy(fargs[1:], args)
+	for {
+		old, version := a.current()
+		fargs[0] = old
+		val, err := f.Invoke(fargs)
+		if err != nil {
+			return NIL, NIL, err
+		}
+		if err = a.validate(val); err != nil {
+			return NIL, NIL, err
+		}
+		if a.commit(val, version) {
+			return old, val, a.notifyWatches(a, old, val)
+		}
+	}
+}
+
+// Reset sets the value to val regardless of the current value and returns the old one
+func (a *Atom) Reset(val Value) (Value, error) {
+	if err := a.validate(val); err != nil {
+		return NIL, err
+	}
+	a.mu.Lock()
+	old := a.state
+	a.state = val
+	a.version++
+	a.mu.Unlock()
+	return old, a.notifyWatches(a, old, val)
+}
+
+// CompareAndSet sets the value to val only if the current value is identical to old
+func (a *Atom) CompareAndSet(old Value, val Value) (bool, error) {
+	if err := a.validate(val); err != nil {
+		return false, err
+	}
+	a.mu.Lock()
+	if !equalsGo(a.state, old) {
+		a.mu.Unlock()
+		return false, nil
+	}
+	a.state = val
+	a.version++
+	a.mu.Unlock()
+	return true, a.notifyWatches(a, old, val)
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright (c) 2021 Marcin Gasperowicz <xnooga@gmail.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+ * documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit
+ * persons to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+ * Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+ * WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+ * OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package vm
+
+import (
+	"math/big"
+	"reflect"
+)
+
+type theBigIntType struct{}
+
+func (t *theBigIntType) String() string     { return t.Name() }
+func (t *theBigIntType) Type() ValueType    { return TypeType }
+func (t *theBigIntType) Unbox() interface{} { return reflect.TypeOf(t) }
+
+func (t *theBigIntType) Name() string { return "let-go.lang.BigInt" }
+
+func (t *theBigIntType) Box(bare interface{}) (Value, error) {
+	switch raw := bare.(type) {
+	case *big.Int:
+		return NewBigInt(raw), nil
+	case int:
+		return NewBigInt(big.NewInt(int64(raw))), nil
+	}
+	return NIL, NewTypeError(bare, "can't be boxed as", t)
+}
+
+// BigIntType is the type of BigInts
+var BigIntType *theBigIntType
+
+func init() {
+	BigIntType = &theBigIntType{}
+}
+
+// BigInt is an arbitrary precision integer, it is never mutated after creation
+type BigInt struct {
+	v *big.Int
+}
+
+// NewBigInt boxes n, n must not be modified afterwards
+func NewBigInt(n *big.Int) *BigInt {
+	return &BigInt{v: n}
+}
+
+// Type implements Value
+func (l *BigInt) Type() ValueType { return BigIntType }
+
+// Unbox implements Value, it returns a copy of the underlying *big.Int
+func (l *BigInt) Unbox() interface{} {
+	return new(big.Int).Set(l.v)
+}
+
+func (l *BigInt) String() string {
+	return l.v.String() + "N"
+}
+
+// Hash implements Hasher, BigInts that fit in an Int hash like that Int
+func (l *BigInt) Hash() uint32 {
+	if l.v.IsInt64() {
+		return Int(l.v.Int64()).Hash()
+	}
+	return hashString(l.v.String())
+}
+
+// Equals implements Hasher, BigInts are equal to Ints of the same value
+func (l *BigInt) Equals(o Value) bool {
+	switch n := o.(type) {
+	case *BigInt:
+		return l.v.Cmp(n.v) == 0
+	case Int:
+		return l.v.IsInt64() && l.v.Int64() == int64(n)
+	}
+	return false
+}
@@ -0,0 +1,164 @@
+/*
+ * Copyright (c) 2021 Marcin Gasperowicz <xnooga@gmail.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+ * documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit
+ * persons to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+ * Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+ * WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+ * OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package vm
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// varBinding holds the value of a var bound by binding, it can be shared between goroutines by bound-fn
+type varBinding struct {
+	val atomic.Value // holds varRoot
+}
+
+func (b *varBinding) get() Value {
+	return b.val.Load().(varRoot).val
+}
+
+func (b *varBinding) set(val Value) {
+	b.val.Store(varRoot{val})
+}
+
+// Bindings is a level of a per-goroutine binding stack, it holds all bindings visible at that level
+type Bindings struct {
+	vals map[*Var]*varBinding
+	prev *Bindings
+}
+
+// threadBindings maps goroutine ids to the innermost Bindings established on them
+var threadBindings sync.Map
+
+func currentBindings() *Bindings {
+	b, ok := threadBindings.Load(goroutineID())
+	if !ok {
+		return nil
+	}
+	return b.(*Bindings)
+}
+
+func setCurrentBindings(b *Bindings) {
+	if b == nil {
+		threadBindings.Delete(goroutineID())
+		return
+	}
+	threadBindings.Store(goroutineID(), b)
+}
+
+// PushBindings establishes new bindings for dynamic vars on the calling goroutine
+func PushBindings(vals map[*Var]Value) error {
+	prev := currentBindings()
+	b := &Bindings{vals: map[*Var]*varBinding{}, prev: prev}
+	if prev != nil {
+		for v, vb := range prev.vals {
+			b.vals[v] = vb
+		}
+	}
+	for v, val := range vals {
+		if !v.IsDynamic() {
+			return NewExecutionError("can't dynamically bind non-dynamic var " + v.String())
+		}
+		vb := &varBinding{}
+		vb.set(val)
+		b.vals[v] = vb
+	}
+	// count bindings only once all vars are known to be dynamic so that failed pushes leave no trace
+	for v := range vals {
+		atomic.AddInt32(&v.bound, 1)
+	}
+	setCurrentBindings(b)
+	return nil
+}
+
+// PopBindings removes bindings established by the matching PushBindings
+func PopBindings() error {
+	b := currentBindings()
+	if b == nil {
+		return NewExecutionError("pop without matching push")
+	}
+	for v, vb := range b.vals {
+		if b.prev == nil || b.prev.vals[v] != vb {
+			atomic.AddInt32(&v.bound, -1)
+		}
+	}
+	setCurrentBindings(b.prev)
+	return nil
+}
+
+// CurrentBindings returns bindings visible on the calling goroutine, nil if there are none
+func CurrentBindings() *Bindings {
+	return currentBindings()
+}
+
+// Vars returns vars bound in b sorted by name
+func (b *Bindings) Vars() []*Var {
+	vars := make([]*Var, 0, len(b.vals))
+	for v := range b.vals {
+		vars = append(vars, v)
+	}
+	sort.Slice(vars, func(i, j int) bool { return vars[i].String() < vars[j].String() })
+	return vars
+}
+
+// Get returns the value v is bound to in b
+func (b *Bindings) Get(v *Var) (Value, bool) {
+	vb, ok := b.vals[v]
+	if !ok {
+		return NIL, false
+	}
+	return vb.get(), true
+}
+
+// WithBindings runs f with bindings of the calling goroutine replaced by b
+func WithBindings(b *Bindings, f func() (Value, error)) (Value, error) {
+	prev := currentBindings()
+	if b == prev {
+		return f()
+	}
+	if b != nil {
+		for v := range b.vals {
+			atomic.AddInt32(&v.bound, 1)
+		}
+	}
+	setCurrentBindings(b)
+	defer func() {
+		setCurrentBindings(prev)
+		if b != nil {
+			for v := range b.vals {
+				atomic.AddInt32(&v.bound, -1)
+			}
+		}
+	}()
+	return f()
+}
+
+// BoundFn returns a function which calls f with bindings visible on the calling goroutine,
+// it's used to convey bindings to other goroutines
+func BoundFn(f Fn) Fn {
+	b := currentBindings()
+	if b == nil {
+		return f
+	}
+	fn, _ := NativeFnType.Wrap(func(args []Value) (Value, error) {
+		return WithBindings(b, func() (Value, error) {
+			return f.Invoke(args)
+		})
+	})
+	return fn.(Fn)
+}
@@ -64,3 +64,17 @@ func (n Boolean) String() string {
 	}
 	return "false"
 }
+
+// Hash implements Hasher
+func (n Boolean) Hash() uint32 {
+	if n {
+		return 1231
+	}
+	return 1237
+}
+
+// Equals implements Hasher
+func (n Boolean) Equals(o Value) bool {
+	b, ok := o.(Boolean)
+	return ok && b == n
+}
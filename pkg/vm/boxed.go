@@ -20,6 +20,7 @@ package vm
 import (
 	"fmt"
 	"reflect"
+	"sync"
 )
 
 type aBoxedType struct {
@@ -54,31 +55,38 @@ func (n *Boxed) String() string {
 	return fmt.Sprintf("<%s %v>", n.typ.Name(), n.value)
 }
 
-func (n *Boxed) InvokeMethod(methodName Symbol, args []Value) Value {
-	if n.typ.methods == nil {
-		// FIXME error :P
-		fmt.Println("methods nil")
-		return NIL
-	}
+// Hash implements Hasher
+func (n *Boxed) Hash() uint32 {
+	return hashGo(n.value)
+}
+
+// Equals implements Hasher
+func (n *Boxed) Equals(o Value) bool {
+	b, ok := o.(*Boxed)
+	return ok && (b == n || equalsGo(n.value, b.value))
+}
+
+func (n *Boxed) InvokeMethod(methodName Symbol, args []Value) (Value, error) {
 	method, ok := n.typ.methods[methodName]
 	if !ok {
-		// FIXME error :P
-		fmt.Println("method", methodName, "not found")
-		return NIL
+		return NIL, NewTypeError(n, fmt.Sprintf("has no method %s", methodName), nil)
 	}
 	return method.Invoke(append([]Value{n}, args...))
 }
 
-// BoxedType is the type of NilValues
-var BoxedTypes map[string]*aBoxedType
+// boxedTypes caches types of boxed Go values, it is guarded by boxedTypesMu
+var boxedTypes map[reflect.Type]*aBoxedType
+var boxedTypesMu sync.RWMutex
 
 func init() {
-	BoxedTypes = map[string]*aBoxedType{}
+	boxedTypes = map[reflect.Type]*aBoxedType{}
 }
 
 func valueType(value interface{}) *aBoxedType {
 	reflected := reflect.TypeOf(value)
-	t, ok := BoxedTypes[reflected.Name()]
+	boxedTypesMu.RLock()
+	t, ok := boxedTypes[reflected]
+	boxedTypesMu.RUnlock()
 	if ok {
 		return t
 	}
@@ -104,10 +112,34 @@ func valueType(value interface{}) *aBoxedType {
 			t.methods[Symbol(m.Name)] = mef
 		}
 	}
-	BoxedTypes[reflected.Name()] = t
+	boxedTypesMu.Lock()
+	defer boxedTypesMu.Unlock()
+	// another goroutine might have boxed a value of the same type in the meantime
+	if prev, ok := boxedTypes[reflected]; ok {
+		return prev
+	}
+	boxedTypes[reflected] = t
 	return t
 }
 
+// BoxedTypeOf returns the type values like value get when boxed, it can be used to extend protocols to Go types
+func BoxedTypeOf(value interface{}) ValueType {
+	return valueType(value)
+}
+
+// FindBoxedType looks up the type of boxed Go values by Go type name, like time.Time.
+// Only types of values which were boxed before are known.
+func FindBoxedType(name string) (ValueType, bool) {
+	boxedTypesMu.RLock()
+	defer boxedTypesMu.RUnlock()
+	for reflected, t := range boxedTypes {
+		if reflected.String() == name {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
 func NewBoxed(value interface{}) *Boxed {
 	return &Boxed{value: value, typ: valueType(value)}
 }
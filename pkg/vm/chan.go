@@ -0,0 +1,366 @@
+/*
+ * Copyright (c) 2021 Marcin Gasperowicz <xnooga@gmail.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+ * documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit
+ * persons to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+ * Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+ * WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+ * OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package vm
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+type theBufferType struct{}
+
+func (t *theBufferType) String() string     { return t.Name() }
+func (t *theBufferType) Type() ValueType    { return TypeType }
+func (t *theBufferType) Unbox() interface{} { return reflect.TypeOf(t) }
+
+func (t *theBufferType) Name() string { return "let-go.lang.Buffer" }
+func (t *theBufferType) Box(b interface{}) (Value, error) {
+	return NIL, NewTypeError(b, "can't be boxed as", t)
+}
+
+// BufferType is the type of channel Buffers
+var BufferType *theBufferType
+
+type theChanType struct{}
+
+func (t *theChanType) String() string     { return t.Name() }
+func (t *theChanType) Type() ValueType    { return TypeType }
+func (t *theChanType) Unbox() interface{} { return reflect.TypeOf(t) }
+
+func (t *theChanType) Name() string { return "let-go.lang.Chan" }
+func (t *theChanType) Box(b interface{}) (Value, error) {
+	c, err := WrapChan(b)
+	if err != nil {
+		return NIL, err
+	}
+	return c, nil
+}
+
+// ChanType is the type of Chans
+var ChanType *theChanType
+
+func init() {
+	BufferType = &theBufferType{}
+	ChanType = &theChanType{}
+}
+
+// BufferPolicy tells what happens to values put on a channel with a full buffer
+type BufferPolicy int
+
+const (
+	// BlockingBuffer makes puts wait until there is room in the buffer
+	BlockingBuffer BufferPolicy = iota
+	// DroppingBuffer drops the value being put
+	DroppingBuffer
+	// SlidingBuffer drops the oldest value in the buffer to make room for the new one
+	SlidingBuffer
+)
+
+// Buffer describes the buffer of a channel, it is passed to chan to create buffered channels
+type Buffer struct {
+	size   int
+	policy BufferPolicy
+}
+
+// NewBuffer creates a Buffer description
+func NewBuffer(size int, policy BufferPolicy) *Buffer {
+	return &Buffer{size: size, policy: policy}
+}
+
+// Type implements Value
+func (b *Buffer) Type() ValueType { return BufferType }
+
+// Unbox implements Value
+func (b *Buffer) Unbox() interface{} { return b }
+
+func (b *Buffer) String() string {
+	return fmt.Sprintf("<buffer %d>", b.size)
+}
+
+// valueInterfaceType is the reflected Value interface, Go channels of Values carry let-go values as they are
+var valueInterfaceType = reflect.TypeOf((*Value)(nil)).Elem()
+
+// Chan is a channel of let-go values. Channels made by let-go are closed by closing a separate done channel,
+// so putting on a closed Chan reports false instead of panicking. Chans can also wrap Go channels of any type,
+// values are boxed and unboxed when they cross the channel.
+type Chan struct {
+	native    chan Value
+	ch        reflect.Value
+	policy    BufferPolicy
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewChan creates a channel, buf can be nil for unbuffered channels
+func NewChan(buf *Buffer) *Chan {
+	c := &Chan{done: make(chan struct{})}
+	if buf != nil {
+		c.native = make(chan Value, buf.size)
+		c.policy = buf.policy
+	} else {
+		c.native = make(chan Value)
+	}
+	c.ch = reflect.ValueOf(c.native)
+	return c
+}
+
+// WrapChan makes a Chan out of a Go channel
+func WrapChan(ch interface{}) (*Chan, error) {
+	rv := reflect.ValueOf(ch)
+	if rv.Kind() != reflect.Chan || rv.IsNil() {
+		return nil, NewTypeError(ch, "can't be boxed as", ChanType)
+	}
+	return &Chan{ch: rv}, nil
+}
+
+// NewTimeout creates a channel which is closed after d
+func NewTimeout(d time.Duration) *Chan {
+	c := NewChan(nil)
+	time.AfterFunc(d, func() { _ = c.Close() })
+	return c
+}
+
+// Type implements Value
+func (c *Chan) Type() ValueType { return ChanType }
+
+// Unbox implements Value, it returns the underlying Go channel
+func (c *Chan) Unbox() interface{} { return c.ch.Interface() }
+
+func (c *Chan) String() string {
+	return fmt.Sprintf("<chan %p>", c)
+}
+
+func (c *Chan) canSend() bool {
+	return c.ch.Type().ChanDir()&reflect.SendDir != 0
+}
+
+func (c *Chan) canRecv() bool {
+	return c.ch.Type().ChanDir()&reflect.RecvDir != 0
+}
+
+func (c *Chan) isClosed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// elem converts v to the element type of a wrapped Go channel
+func (c *Chan) elem(v Value) (reflect.Value, error) {
+	typ := c.ch.Type().Elem()
+	if typ == valueInterfaceType {
+		return reflect.ValueOf(&v).Elem(), nil
+	}
+	return unboxArg(v, typ)
+}
+
+// Put puts v on the channel waiting for room in the buffer or for a taker. It returns false if the channel is closed.
+func (c *Chan) Put(v Value) (ok bool, err error) {
+	if v == NIL {
+		return false, NewExecutionError("can't put nil on a channel")
+	}
+	if c.native == nil {
+		if !c.canSend() {
+			return false, NewTypeError(c, "is a receive-only channel", nil)
+		}
+		ev, err := c.elem(v)
+		if err != nil {
+			return false, err
+		}
+		// sending on a closed Go channel panics, that just means the value wasn't put
+		defer func() {
+			if recover() != nil {
+				ok = false
+			}
+		}()
+		c.ch.Send(ev)
+		return true, nil
+	}
+	if c.isClosed() {
+		return false, nil
+	}
+	switch c.policy {
+	case DroppingBuffer:
+		select {
+		case c.native <- v:
+		default:
+		}
+		return true, nil
+	case SlidingBuffer:
+		for {
+			select {
+			case c.native <- v:
+				return true, nil
+			default:
+				select {
+				case <-c.native:
+				default:
+				}
+			}
+		}
+	}
+	select {
+	case c.native <- v:
+		return true, nil
+	case <-c.done:
+		return false, nil
+	}
+}
+
+// Take takes a value from the channel waiting until there is one, it returns nil when the channel is closed and drained
+func (c *Chan) Take() (Value, error) {
+	if c.native == nil {
+		if !c.canRecv() {
+			return NIL, NewTypeError(c, "is a send-only channel", nil)
+		}
+		return c.boxRecv(c.ch.Recv())
+	}
+	select {
+	case v := <-c.native:
+		return v, nil
+	case <-c.done:
+		return c.drain(), nil
+	}
+}
+
+// drain takes a value left in the buffer of a closed channel without waiting
+func (c *Chan) drain() Value {
+	select {
+	case v := <-c.native:
+		return v
+	default:
+		return NIL
+	}
+}
+
+func (c *Chan) boxRecv(v reflect.Value, ok bool) (Value, error) {
+	if !ok {
+		return NIL, nil
+	}
+	return BoxValue(v)
+}
+
+// Close closes the channel, closing a channel more than once does nothing
+func (c *Chan) Close() error {
+	if c.native == nil {
+		if !c.canSend() {
+			return NewTypeError(c, "is a receive-only channel", nil)
+		}
+		defer func() { _ = recover() }()
+		c.ch.Close()
+		return nil
+	}
+	c.closeOnce.Do(func() { close(c.done) })
+	return nil
+}
+
+// ChanOp is a take from Chan or, when Put is set, a put of Val on it
+type ChanOp struct {
+	Chan *Chan
+	Put  bool
+	Val  Value
+}
+
+// selectCase ties a reflect.SelectCase to the op it came from, done cases fire when a let-go channel gets closed
+type selectCase struct {
+	op   int
+	done bool
+}
+
+// Alts performs exactly one of ops and returns its result along with the index of the op. Takes result in
+// the value taken, puts in true or false. When priority is set ops are tried in order, otherwise a random
+// ready op is picked. If block is false and no op is ready Alts returns -1.
+func Alts(ops []ChanOp, priority bool, block bool) (ret Value, idx int, err error) {
+	for i := range ops {
+		c := ops[i].Chan
+		if ops[i].Put && ops[i].Val == NIL {
+			return NIL, -1, NewExecutionError("can't put nil on a channel")
+		}
+		if ops[i].Put && !c.canSend() {
+			return NIL, -1, NewTypeError(c, "is a receive-only channel", nil)
+		}
+		if !ops[i].Put && !c.canRecv() {
+			return NIL, -1, NewTypeError(c, "is a send-only channel", nil)
+		}
+		// puts on closed channels and on dropping and sliding buffers never wait
+		if ops[i].Put && c.native != nil && (c.policy != BlockingBuffer || c.isClosed()) {
+			ok, err := c.Put(ops[i].Val)
+			return Boolean(ok), i, err
+		}
+	}
+	if priority {
+		for i := range ops {
+			ret, idx, err = alts(ops[i:i+1], false)
+			if err != nil || idx >= 0 {
+				return ret, i, err
+			}
+		}
+		if !block {
+			return NIL, -1, nil
+		}
+	}
+	return alts(ops, block)
+}
+
+func alts(ops []ChanOp, block bool) (ret Value, idx int, err error) {
+	cases := make([]reflect.SelectCase, 0, len(ops)*2+1)
+	tags := make([]selectCase, 0, len(ops)*2)
+	for i := range ops {
+		c := ops[i].Chan
+		if ops[i].Put {
+			ev, err := c.elem(ops[i].Val)
+			if err != nil {
+				return NIL, -1, err
+			}
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectSend, Chan: c.ch, Send: ev})
+		} else {
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: c.ch})
+		}
+		tags = append(tags, selectCase{op: i})
+		if c.native != nil {
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(c.done)})
+			tags = append(tags, selectCase{op: i, done: true})
+		}
+	}
+	if !block {
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectDefault})
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			ret, idx, err = NIL, -1, NewExecutionError(fmt.Sprintf("channel operation failed: %v", r))
+		}
+	}()
+	chosen, recv, ok := reflect.Select(cases)
+	if chosen == len(tags) {
+		return NIL, -1, nil
+	}
+	tag := tags[chosen]
+	op := ops[tag.op]
+	switch {
+	case op.Put:
+		return Boolean(!tag.done), tag.op, nil
+	case tag.done:
+		return op.Chan.drain(), tag.op, nil
+	}
+	ret, err = op.Chan.boxRecv(recv, ok)
+	return ret, tag.op, err
+}
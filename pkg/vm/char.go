@@ -61,3 +61,14 @@ func (l Char) Unbox() interface{} {
 func (l Char) String() string {
 	return "\\" + string(l)
 }
+
+// Hash implements Hasher
+func (l Char) Hash() uint32 {
+	return hashUint64(uint64(l)) ^ 0x9e3779b9
+}
+
+// Equals implements Hasher
+func (l Char) Equals(o Value) bool {
+	c, ok := o.(Char)
+	return ok && c == l
+}
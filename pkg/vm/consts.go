@@ -0,0 +1,73 @@
+/*
+ * Copyright (c) 2021 Marcin Gasperowicz <xnooga@gmail.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+ * documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit
+ * persons to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+ * Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+ * WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+ * OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package vm
+
+import (
+	"reflect"
+	"sync"
+)
+
+// Consts is a pool of constants shared by code chunks. It can be extended by the compiler
+// while frames using it run on other goroutines, values are never removed or replaced.
+type Consts struct {
+	mu     sync.RWMutex
+	values []Value
+}
+
+// NewConsts creates a pool holding values
+func NewConsts(values ...Value) *Consts {
+	return &Consts{values: values}
+}
+
+// Intern returns index of a constant equal to v and of the same type and metadata, v is added if there is none
+func (c *Consts) Intern(v Value) int {
+	c.mu.RLock()
+	n := len(c.values)
+	i := c.find(v, 0, n)
+	c.mu.RUnlock()
+	if i >= 0 {
+		return i
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// only constants added since the first lookup have to be checked again
+	if i = c.find(v, n, len(c.values)); i >= 0 {
+		return i
+	}
+	c.values = append(c.values, v)
+	return len(c.values) - 1
+}
+
+func (c *Consts) find(v Value, from int, to int) int {
+	t := reflect.TypeOf(v)
+	for i := from; i < to; i++ {
+		k := c.values[i]
+		// metadata doesn't affect equality but constants with different metadata are not interchangeable
+		if reflect.TypeOf(k) == t && Equals(k, v) && Equals(MetaOf(k), MetaOf(v)) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Values returns a snapshot of the pool, it is safe to use while the pool grows
+func (c *Consts) Values() []Value {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.values[:len(c.values):len(c.values)]
+}
@@ -0,0 +1,226 @@
+/*
+ * Copyright (c) 2021 Marcin Gasperowicz <xnooga@gmail.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+ * documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit
+ * persons to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+ * Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+ * WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+ * OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package vm
+
+import (
+	"fmt"
+	"github.com/nooga/let-go/pkg/errors"
+	"reflect"
+)
+
+type theExceptionType struct{}
+
+func (t *theExceptionType) String() string     { return t.Name() }
+func (t *theExceptionType) Type() ValueType    { return TypeType }
+func (t *theExceptionType) Unbox() interface{} { return reflect.TypeOf(t) }
+
+func (t *theExceptionType) Name() string { return "let-go.lang.Exception" }
+func (t *theExceptionType) Box(b interface{}) (Value, error) {
+	return NIL, NewTypeError(b, "can't be boxed as", t)
+}
+
+// ExceptionType is the root of all exception types, catching it catches everything
+var ExceptionType *theExceptionType
+
+type theExceptionInfoType struct{}
+
+func (t *theExceptionInfoType) String() string     { return t.Name() }
+func (t *theExceptionInfoType) Type() ValueType    { return TypeType }
+func (t *theExceptionInfoType) Unbox() interface{} { return reflect.TypeOf(t) }
+
+func (t *theExceptionInfoType) Name() string { return "let-go.lang.ExceptionInfo" }
+func (t *theExceptionInfoType) Box(b interface{}) (Value, error) {
+	return NIL, NewTypeError(b, "can't be boxed as", t)
+}
+
+// ExceptionInfoType is the type of ExInfo values
+var ExceptionInfoType *theExceptionInfoType
+
+type theErrorType struct{}
+
+func (t *theErrorType) String() string     { return t.Name() }
+func (t *theErrorType) Type() ValueType    { return TypeType }
+func (t *theErrorType) Unbox() interface{} { return reflect.TypeOf(t) }
+
+func (t *theErrorType) Name() string { return "let-go.lang.Error" }
+func (t *theErrorType) Box(b interface{}) (Value, error) {
+	err, ok := b.(error)
+	if !ok {
+		return NIL, NewTypeError(b, "can't be boxed as", t)
+	}
+	return &Error{err: err}, nil
+}
+
+// ErrorType is the type of Go errors caught by let-go code
+var ErrorType *theErrorType
+
+func init() {
+	ExceptionType = &theExceptionType{}
+	ExceptionInfoType = &theExceptionInfoType{}
+	ErrorType = &theErrorType{}
+}
+
+// ExInfo is an exception carrying a message and a map of data, created by ex-info
+type ExInfo struct {
+	message string
+	data    Value
+	cause   error
+}
+
+// NewExInfo creates a new ExInfo
+func NewExInfo(message string, data Value, cause error) *ExInfo {
+	return &ExInfo{
+		message: message,
+		data:    data,
+		cause:   cause,
+	}
+}
+
+// Type implements Value
+func (e *ExInfo) Type() ValueType { return ExceptionInfoType }
+
+// Unbox implements Value
+func (e *ExInfo) Unbox() interface{} { return e }
+
+func (e *ExInfo) String() string {
+	// data is printed directly so errors realizing lazy seqs in it reach PrintString instead of fmt
+	return fmt.Sprintf("<ex-info %q %s>", e.message, e.data.String())
+}
+
+// Error implements error, data that can't be printed is left out of the message
+func (e *ExInfo) Error() string {
+	data, err := PrintString(e.data)
+	if err != nil {
+		return errors.AddCause(e, fmt.Sprintf("ExceptionInfo: %s", e.message))
+	}
+	return errors.AddCause(e, fmt.Sprintf("ExceptionInfo: %s %s", e.message, data))
+}
+
+func (e *ExInfo) Wrap(err error) errors.Error {
+	e.cause = err
+	return e
+}
+
+func (e *ExInfo) GetCause() error {
+	return e.cause
+}
+
+func (e *ExInfo) Message() string {
+	return e.message
+}
+
+func (e *ExInfo) Data() Value {
+	return e.data
+}
+
+// Error is a Go error caught by let-go code
+type Error struct {
+	err error
+}
+
+// Type implements Value
+func (e *Error) Type() ValueType { return ErrorType }
+
+// Unbox implements Value
+func (e *Error) Unbox() interface{} { return e.err }
+
+func (e *Error) String() string {
+	return fmt.Sprintf("<error %q>", e.err.Error())
+}
+
+// Hash implements Hasher
+func (e *Error) Hash() uint32 {
+	return hashGo(e.err)
+}
+
+// Equals implements Hasher, errors are equal when they wrap the same Go error
+func (e *Error) Equals(o Value) bool {
+	oe, ok := o.(*Error)
+	return ok && (oe == e || equalsGo(e.err, oe.err))
+}
+
+// ExceptionValue turns an error into a let-go value that can be bound in catch clauses
+func ExceptionValue(err error) Value {
+	err = unwrapRuntimeError(err)
+	if v, ok := err.(Value); ok {
+		return v
+	}
+	return &Error{err: err}
+}
+
+// Throwable turns a thrown let-go value into an error
+func Throwable(v Value) error {
+	switch e := v.(type) {
+	case *Error:
+		return e.err
+	case error:
+		return e
+	}
+	return NewTypeError(v, "can't be thrown", nil)
+}
+
+// ExceptionMessage returns the message of an exception value or nil if v is not an exception
+func ExceptionMessage(v Value) Value {
+	switch e := v.(type) {
+	case *ExInfo:
+		return String(e.message)
+	case *Error:
+		return String(e.err.Error())
+	}
+	return NIL
+}
+
+// ExceptionCause returns the cause of an exception value or nil if it has none
+func ExceptionCause(v Value) Value {
+	var cause error
+	switch e := v.(type) {
+	case *ExInfo:
+		cause = e.cause
+	case *Error:
+		if ee, ok := e.err.(errors.Error); ok {
+			cause = ee.GetCause()
+		}
+	}
+	if cause == nil {
+		return NIL
+	}
+	return ExceptionValue(cause)
+}
+
+// IsInstance checks if v is an instance of typ, Go errors are instances of their boxed Go types
+// and all exception values except transaction retries are instances of ExceptionType
+func IsInstance(typ Value, v Value) bool {
+	if v.Type() == typ {
+		return true
+	}
+	switch e := v.(type) {
+	case *ExInfo:
+		return typ == ExceptionType
+	case *Error:
+		// transaction retries must reach the transaction, only finally blocks see them
+		if isRetry(e.err) {
+			return false
+		}
+		if typ == ExceptionType {
+			return true
+		}
+		bt, ok := typ.(*aBoxedType)
+		return ok && reflect.TypeOf(e.err) == bt.typ
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+/*
+ * Copyright (c) 2021 Marcin Gasperowicz <xnooga@gmail.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+ * documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit
+ * persons to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+ * Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+ * WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+ * OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package vm
+
+import (
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+type theFloatType struct {
+	zero Float
+}
+
+func (t *theFloatType) String() string     { return t.Name() }
+func (t *theFloatType) Type() ValueType    { return TypeType }
+func (t *theFloatType) Unbox() interface{} { return reflect.TypeOf(t) }
+
+func (t *theFloatType) Name() string { return "let-go.lang.Float" }
+
+func (t *theFloatType) Box(bare interface{}) (Value, error) {
+	switch raw := bare.(type) {
+	case float64:
+		return Float(raw), nil
+	case float32:
+		return Float(raw), nil
+	}
+	return FloatType.zero, NewTypeError(bare, "can't be boxed as", t)
+}
+
+// FloatType is the type of Floats
+var FloatType *theFloatType
+
+func init() {
+	FloatType = &theFloatType{zero: 0}
+}
+
+// Float is boxed float64
+type Float float64
+
+// Type implements Value
+func (l Float) Type() ValueType { return FloatType }
+
+// Unbox implements Unbox
+func (l Float) Unbox() interface{} {
+	return float64(l)
+}
+
+func (l Float) String() string {
+	f := float64(l)
+	switch {
+	case math.IsInf(f, 1):
+		return "##Inf"
+	case math.IsInf(f, -1):
+		return "##-Inf"
+	case math.IsNaN(f):
+		return "##NaN"
+	}
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eE") {
+		s += ".0"
+	}
+	return s
+}
+
+// Hash implements Hasher
+func (l Float) Hash() uint32 {
+	if l == 0 {
+		// 0.0 and -0.0 are equal so they have to hash the same
+		return 0
+	}
+	return hashUint64(math.Float64bits(float64(l)))
+}
+
+// Equals implements Hasher, floats are never equal to integers or ratios, use == to compare numbers across types
+func (l Float) Equals(o Value) bool {
+	f, ok := o.(Float)
+	return ok && f == l
+}
@@ -40,6 +40,7 @@ func init() {
 }
 
 type Func struct {
+	name        string
 	arity       int
 	isVariadric bool
 	chunk       *CodeChunk
@@ -55,48 +56,67 @@ func MakeFunc(arity int, variadric bool, c *CodeChunk) *Func {
 
 func (l *Func) Type() ValueType { return FuncType }
 
+// Name returns the name this fn shows up under in stack traces
+func (l *Func) Name() string {
+	if l.name == "" {
+		return "fn"
+	}
+	return l.name
+}
+
+func (l *Func) SetName(name string) {
+	l.name = name
+}
+
 type FuncInterface func(interface{})
 
 // Unbox implements Unbox
 func (l *Func) Unbox() interface{} {
-	proxy := func(in []reflect.Value) []reflect.Value {
-		args := make([]Value, len(in))
-		for i := range in {
-			a, _ := BoxValue(in[i]) // FIXME handle error
-			args[i] = a
-		}
-		f := NewFrame(l.chunk, args)
-		out, _ := f.Run() // FIXME handle error
-		return []reflect.Value{reflect.ValueOf(out.Unbox())}
-	}
-	return func(fptr interface{}) {
-		fn := reflect.ValueOf(fptr).Elem()
-		v := reflect.MakeFunc(fn.Type(), proxy)
-		fn.Set(v)
-	}
+	return unboxFn(l)
 }
 
 func (l *Func) Arity() int {
 	return l.arity
 }
 
-func (l *Func) Invoke(pargs []Value) Value {
+func (l *Func) Invoke(pargs []Value) (Value, error) {
+	return l.invoke(l, nil, pargs)
+}
+
+// invoke runs the fn with closedOvers, self is the value the fn refers to by its own name
+func (l *Func) invoke(self Fn, closedOvers []Value, pargs []Value) (Value, error) {
 	args := pargs
+	argc := len(args)
 	if l.isVariadric {
 		// pretty sure variadric should guarantee arity >= 1
+		if argc < l.arity-1 {
+			return NIL, NewExecutionError(fmt.Sprintf("wrong number of arguments (%d) passed to %s", argc, l))
+		}
 		sargs := args[0 : l.arity-1]
 		rest := args[l.arity-1:]
-		// FIXME don't swallow the error, make invoke return an error
-		restlist, _ := ListType.Box(rest)
-		args = append(sargs, restlist)
+		var restlist Value = NIL
+		if len(rest) > 0 {
+			rl, err := ListType.Box(rest)
+			if err != nil {
+				return NIL, NewExecutionError("boxing rest arguments").Wrap(err)
+			}
+			restlist = rl
+		}
+		args = append(sargs[:len(sargs):len(sargs)], restlist)
+	} else if argc != l.arity {
+		return NIL, NewExecutionError(fmt.Sprintf("wrong number of arguments (%d) passed to %s", argc, l))
 	}
 	f := NewFrame(l.chunk, args)
-	// FIXME don't swallow the error, make invoke return an error
-	v, _ := f.Run()
-	return v
+	f.closedOvers = closedOvers
+	f.fn = l
+	f.self = self
+	return f.Run()
 }
 
 func (l *Func) String() string {
+	if l.name != "" {
+		return fmt.Sprintf("<fn %s %p>", l.name, l)
+	}
 	return fmt.Sprintf("<fn %p>", l)
 }
 
@@ -116,45 +136,167 @@ func (l *Closure) Type() ValueType { return FuncType }
 
 // Unbox implements Unbox
 func (l *Closure) Unbox() interface{} {
-	proxy := func(in []reflect.Value) []reflect.Value {
-		args := make([]Value, len(in))
-		for i := range in {
-			a, _ := BoxValue(in[i]) // FIXME handle error
-			args[i] = a
-		}
-		f := NewFrame(l.fn.chunk, args)
-		f.closedOvers = l.closedOvers
-		out, _ := f.Run() // FIXME handle error
-		return []reflect.Value{reflect.ValueOf(out.Unbox())}
-	}
-	return func(fptr interface{}) {
-		fn := reflect.ValueOf(fptr).Elem()
-		v := reflect.MakeFunc(fn.Type(), proxy)
-		fn.Set(v)
-	}
+	return unboxFn(l)
 }
 
 func (l *Closure) Arity() int {
 	return l.fn.arity
 }
 
-func (l *Closure) Invoke(pargs []Value) Value {
-	args := pargs
-	if l.fn.isVariadric {
-		// pretty sure variadric should guarantee arity >= 1
-		sargs := args[0 : l.fn.arity-1]
-		rest := args[l.fn.arity-1:]
-		// FIXME don't swallow the error, make invoke return an error
-		restlist, _ := ListType.Box(rest)
-		args = append(sargs, restlist)
-	}
-	f := NewFrame(l.fn.chunk, args)
-	f.closedOvers = l.closedOvers
-	// FIXME don't swallow the error, make invoke return an error
-	v, _ := f.Run()
-	return v
+func (l *Closure) Invoke(pargs []Value) (Value, error) {
+	return l.fn.invoke(l, l.closedOvers, pargs)
+}
+
+func (l *Closure) Name() string {
+	return l.fn.Name()
 }
 
 func (l *Closure) String() string {
 	return l.fn.String()
 }
+
+// MultiArityFn dispatches invocations to one of its arities based on the number of arguments
+type MultiArityFn struct {
+	fixed    map[int]Fn
+	variadic Fn
+	minArgs  int
+	name     string
+}
+
+// NewMultiArityFn makes a multi-arity fn out of Funcs or Closures, at most one of them can be variadic
+// and it must not take fewer fixed arguments than any of the others
+func NewMultiArityFn(fns []Value) (Value, error) {
+	m := &MultiArityFn{fixed: map[int]Fn{}}
+	maxFixed := -1
+	for i := range fns {
+		var f *Func
+		switch fn := fns[i].(type) {
+		case *Func:
+			f = fn
+		case *Closure:
+			f = fn.fn
+		default:
+			return NIL, NewTypeError(fns[i], "can't be used as an arity of a fn", FuncType)
+		}
+		if m.name == "" {
+			m.name = f.name
+		}
+		if f.isVariadric {
+			if m.variadic != nil {
+				return NIL, NewExecutionError("can't have more than one variadic arity")
+			}
+			m.variadic = fns[i].(Fn)
+			m.minArgs = f.arity - 1
+			continue
+		}
+		if _, ok := m.fixed[f.arity]; ok {
+			return NIL, NewExecutionError(fmt.Sprintf("can't have two arities with %d arguments", f.arity))
+		}
+		m.fixed[f.arity] = fns[i].(Fn)
+		if f.arity > maxFixed {
+			maxFixed = f.arity
+		}
+	}
+	if m.variadic != nil && m.minArgs < maxFixed {
+		return NIL, NewExecutionError("can't have fixed arity function with more params than variadic function")
+	}
+	return m, nil
+}
+
+func (m *MultiArityFn) Type() ValueType { return FuncType }
+
+// Unbox implements Unbox
+func (m *MultiArityFn) Unbox() interface{} {
+	return unboxFn(m)
+}
+
+// Arity returns -1 since multi-arity fns don't have a single arity
+func (m *MultiArityFn) Arity() int {
+	return -1
+}
+
+func (m *MultiArityFn) Invoke(pargs []Value) (Value, error) {
+	fn, ok := m.fixed[len(pargs)]
+	if !ok {
+		if m.variadic == nil || len(pargs) < m.minArgs {
+			return NIL, NewExecutionError(fmt.Sprintf("wrong number of arguments (%d) passed to %s", len(pargs), m))
+		}
+		fn = m.variadic
+	}
+	switch f := fn.(type) {
+	case *Func:
+		return f.invoke(m, nil, pargs)
+	case *Closure:
+		return f.fn.invoke(m, f.closedOvers, pargs)
+	}
+	return fn.Invoke(pargs)
+}
+
+func (m *MultiArityFn) Name() string {
+	if m.name == "" {
+		return "fn"
+	}
+	return m.name
+}
+
+func (m *MultiArityFn) String() string {
+	if m.name != "" {
+		return fmt.Sprintf("<fn %s %p>", m.name, m)
+	}
+	return fmt.Sprintf("<fn %p>", m)
+}
+
+// unboxFn returns a setter which turns a pointer to a Go func into a proxy calling fn.
+// If the Go func returns an error as its last result, invocation errors are reported through it,
+// otherwise the proxy panics.
+func unboxFn(fn Fn) interface{} {
+	return func(fptr interface{}) {
+		target := reflect.ValueOf(fptr).Elem()
+		ty := target.Type()
+		proxy := func(in []reflect.Value) []reflect.Value {
+			args := make([]Value, len(in))
+			for i := range in {
+				a, err := BoxValue(in[i])
+				if err != nil {
+					return proxyResults(ty, NIL, NewExecutionError("boxing proxy argument").Wrap(err))
+				}
+				args[i] = a
+			}
+			out, err := fn.Invoke(args)
+			return proxyResults(ty, out, err)
+		}
+		target.Set(reflect.MakeFunc(ty, proxy))
+	}
+}
+
+func proxyResults(ty reflect.Type, out Value, err error) []reflect.Value {
+	n := ty.NumOut()
+	returnsError := n > 0 && ty.Out(n-1) == errorType
+	if err != nil && !returnsError {
+		panic(err)
+	}
+	res := make([]reflect.Value, n)
+	for i := 0; i < n; i++ {
+		res[i] = reflect.Zero(ty.Out(i))
+	}
+	if returnsError {
+		if err != nil {
+			res[n-1] = reflect.ValueOf(&err).Elem()
+			return res
+		}
+		n--
+	}
+	if n > 0 && out != nil {
+		rv, uerr := unboxArg(out, ty.Out(0))
+		if uerr != nil {
+			if returnsError {
+				uerr = NewExecutionError("unboxing proxy result").Wrap(uerr)
+				res[len(res)-1] = reflect.ValueOf(&uerr).Elem()
+				return res
+			}
+			panic(uerr)
+		}
+		res[0] = rv
+	}
+	return res
+}
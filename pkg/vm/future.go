@@ -0,0 +1,276 @@
+/*
+ * Copyright (c) 2021 Marcin Gasperowicz <xnooga@gmail.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+ * documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit
+ * persons to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+ * Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+ * WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+ * OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package vm
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Realizable is implemented by values computed on demand or asynchronously
+type Realizable interface {
+	Value
+	IsRealized() bool
+}
+
+// Pending is implemented by references whose value may not be there yet, deref waits for it
+type Pending interface {
+	Realizable
+	// Await waits for the value at most timeout, negative timeout waits indefinitely.
+	// ok is false when the wait timed out.
+	Await(timeout time.Duration) (val Value, ok bool, err error)
+}
+
+// outcome is a value or an error set exactly once, it can be awaited from many goroutines
+type outcome struct {
+	once sync.Once
+	done chan struct{}
+	val  Value
+	err  error
+}
+
+func newOutcome() *outcome {
+	return &outcome{done: make(chan struct{}), val: NIL}
+}
+
+// deliver sets the outcome unless it was already set, it reports if that happened
+func (o *outcome) deliver(val Value, err error) bool {
+	delivered := false
+	o.once.Do(func() {
+		o.val, o.err = val, err
+		delivered = true
+		close(o.done)
+	})
+	return delivered
+}
+
+func (o *outcome) isDone() bool {
+	select {
+	case <-o.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (o *outcome) await(timeout time.Duration) (Value, bool, error) {
+	if timeout < 0 {
+		<-o.done
+		return o.val, true, o.err
+	}
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-o.done:
+		return o.val, true, o.err
+	case <-t.C:
+		return NIL, false, nil
+	}
+}
+
+type theFutureType struct{}
+
+func (t *theFutureType) String() string     { return t.Name() }
+func (t *theFutureType) Type() ValueType    { return TypeType }
+func (t *theFutureType) Unbox() interface{} { return reflect.TypeOf(t) }
+
+func (t *theFutureType) Name() string { return "let-go.lang.Future" }
+func (t *theFutureType) Box(b interface{}) (Value, error) {
+	return NIL, NewTypeError(b, "can't be boxed as", t)
+}
+
+// FutureType is the type of Futures
+var FutureType *theFutureType
+
+type thePromiseType struct{}
+
+func (t *thePromiseType) String() string     { return t.Name() }
+func (t *thePromiseType) Type() ValueType    { return TypeType }
+func (t *thePromiseType) Unbox() interface{} { return reflect.TypeOf(t) }
+
+func (t *thePromiseType) Name() string { return "let-go.lang.Promise" }
+func (t *thePromiseType) Box(b interface{}) (Value, error) {
+	return NIL, NewTypeError(b, "can't be boxed as", t)
+}
+
+// PromiseType is the type of Promises
+var PromiseType *thePromiseType
+
+type theDelayType struct{}
+
+func (t *theDelayType) String() string     { return t.Name() }
+func (t *theDelayType) Type() ValueType    { return TypeType }
+func (t *theDelayType) Unbox() interface{} { return reflect.TypeOf(t) }
+
+func (t *theDelayType) Name() string { return "let-go.lang.Delay" }
+func (t *theDelayType) Box(b interface{}) (Value, error) {
+	return NIL, NewTypeError(b, "can't be boxed as", t)
+}
+
+// DelayType is the type of Delays
+var DelayType *theDelayType
+
+func init() {
+	FutureType = &theFutureType{}
+	PromiseType = &thePromiseType{}
+	DelayType = &theDelayType{}
+}
+
+// Future is the result of invoking a fn on its own goroutine
+type Future struct {
+	result    *outcome
+	cancelled int32
+}
+
+// NewFuture invokes f with no arguments on a new goroutine
+func NewFuture(f Fn) *Future {
+	fu := &Future{result: newOutcome()}
+	go func() {
+		val, err := f.Invoke([]Value{})
+		fu.result.deliver(val, err)
+	}()
+	return fu
+}
+
+// Type implements Value
+func (f *Future) Type() ValueType { return FutureType }
+
+// Unbox implements Value
+func (f *Future) Unbox() interface{} { return f }
+
+func (f *Future) String() string {
+	if !f.IsRealized() {
+		return fmt.Sprintf("<future %p pending>", f)
+	}
+	return fmt.Sprintf("<future %p>", f)
+}
+
+// IsRealized implements Realizable, cancelled futures are realized too
+func (f *Future) IsRealized() bool {
+	return f.result.isDone()
+}
+
+// Await implements Pending, it fails if the fn failed or the future was cancelled
+func (f *Future) Await(timeout time.Duration) (Value, bool, error) {
+	return f.result.await(timeout)
+}
+
+// Cancel cancels the future unless it's done already. The goroutine can't be stopped, its result is discarded.
+func (f *Future) Cancel() bool {
+	if !f.result.deliver(NIL, NewExecutionError("future was cancelled")) {
+		return false
+	}
+	atomic.StoreInt32(&f.cancelled, 1)
+	return true
+}
+
+// IsCancelled checks if Cancel succeeded
+func (f *Future) IsCancelled() bool {
+	return atomic.LoadInt32(&f.cancelled) != 0
+}
+
+// Promise is a reference delivered exactly once, deref waits for the delivery
+type Promise struct {
+	result *outcome
+}
+
+// NewPromise creates an undelivered Promise
+func NewPromise() *Promise {
+	return &Promise{result: newOutcome()}
+}
+
+// Type implements Value
+func (p *Promise) Type() ValueType { return PromiseType }
+
+// Unbox implements Value
+func (p *Promise) Unbox() interface{} { return p }
+
+func (p *Promise) String() string {
+	if !p.IsRealized() {
+		return fmt.Sprintf("<promise %p pending>", p)
+	}
+	return fmt.Sprintf("<promise %p %s>", p, p.result.val)
+}
+
+// Deliver sets the value of the promise, only the first delivery counts
+func (p *Promise) Deliver(val Value) bool {
+	return p.result.deliver(val, nil)
+}
+
+// IsRealized implements Realizable
+func (p *Promise) IsRealized() bool {
+	return p.result.isDone()
+}
+
+// Await implements Pending
+func (p *Promise) Await(timeout time.Duration) (Value, bool, error) {
+	return p.result.await(timeout)
+}
+
+// Delay invokes its fn on first deref and caches the result, errors are cached as well
+type Delay struct {
+	mu       sync.Mutex
+	fn       Fn
+	val      Value
+	err      error
+	realized int32
+}
+
+// NewDelay creates a Delay computed by invoking f with no arguments
+func NewDelay(f Fn) *Delay {
+	return &Delay{fn: f, val: NIL}
+}
+
+// Type implements Value
+func (d *Delay) Type() ValueType { return DelayType }
+
+// Unbox implements Value
+func (d *Delay) Unbox() interface{} { return d }
+
+func (d *Delay) String() string {
+	if !d.IsRealized() {
+		return fmt.Sprintf("<delay %p pending>", d)
+	}
+	return fmt.Sprintf("<delay %p %s>", d, d.val)
+}
+
+// IsRealized implements Realizable
+func (d *Delay) IsRealized() bool {
+	return atomic.LoadInt32(&d.realized) != 0
+}
+
+// Force computes the value if that didn't happen yet, goroutines forcing it at the same time wait for each other
+func (d *Delay) Force() (Value, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.fn != nil {
+		d.val, d.err = d.fn.Invoke([]Value{})
+		d.fn = nil
+		atomic.StoreInt32(&d.realized, 1)
+	}
+	return d.val, d.err
+}
+
+// Await implements Pending, the value is computed on the calling goroutine so timeout doesn't apply
+func (d *Delay) Await(_ time.Duration) (Value, bool, error) {
+	val, err := d.Force()
+	return val, true, err
+}
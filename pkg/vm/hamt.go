@@ -0,0 +1,225 @@
+/*
+ * Copyright (c) 2021 Marcin Gasperowicz <xnooga@gmail.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+ * documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit
+ * persons to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+ * Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+ * WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+ * OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package vm
+
+import "math/bits"
+
+// hamtNode is a node of a hash array mapped trie, nodes are never mutated once built
+type hamtNode interface {
+	assoc(shift uint, hash uint32, key Value, val Value, added *bool) hamtNode
+	without(shift uint, hash uint32, key Value) hamtNode
+	find(shift uint, hash uint32, key Value) (Value, bool)
+	each(fn func(Value, Value) bool) bool
+}
+
+const hamtBits = 5
+const hamtMask = 1<<hamtBits - 1
+
+func bitpos(hash uint32, shift uint) uint32 {
+	return 1 << ((hash >> shift) & hamtMask)
+}
+
+// hamtEntry holds either a key-value pair or a subnode
+type hamtEntry struct {
+	key  Value
+	val  Value
+	node hamtNode
+}
+
+// bitmapNode stores up to 32 entries, the bitmap tells which hash fragments are present
+type bitmapNode struct {
+	bitmap  uint32
+	entries []hamtEntry
+}
+
+var emptyBitmapNode = &bitmapNode{}
+
+func (n *bitmapNode) index(bit uint32) int {
+	return bits.OnesCount32(n.bitmap & (bit - 1))
+}
+
+func (n *bitmapNode) withEntry(idx int, e hamtEntry) *bitmapNode {
+	entries := make([]hamtEntry, len(n.entries))
+	copy(entries, n.entries)
+	entries[idx] = e
+	return &bitmapNode{bitmap: n.bitmap, entries: entries}
+}
+
+func (n *bitmapNode) assoc(shift uint, hash uint32, key Value, val Value, added *bool) hamtNode {
+	bit := bitpos(hash, shift)
+	idx := n.index(bit)
+	if n.bitmap&bit == 0 {
+		entries := make([]hamtEntry, len(n.entries)+1)
+		copy(entries, n.entries[:idx])
+		entries[idx] = hamtEntry{key: key, val: val}
+		copy(entries[idx+1:], n.entries[idx:])
+		*added = true
+		return &bitmapNode{bitmap: n.bitmap | bit, entries: entries}
+	}
+	e := n.entries[idx]
+	if e.node != nil {
+		sub := e.node.assoc(shift+hamtBits, hash, key, val, added)
+		if sub == e.node {
+			return n
+		}
+		return n.withEntry(idx, hamtEntry{node: sub})
+	}
+	if Equals(key, e.key) {
+		return n.withEntry(idx, hamtEntry{key: e.key, val: val})
+	}
+	*added = true
+	sub := newHamtPair(shift+hamtBits, e.key, e.val, hash, key, val)
+	return n.withEntry(idx, hamtEntry{node: sub})
+}
+
+// newHamtPair builds a node holding two distinct keys
+func newHamtPair(shift uint, k1 Value, v1 Value, h2 uint32, k2 Value, v2 Value) hamtNode {
+	h1 := Hash(k1)
+	if h1 == h2 {
+		return &collisionNode{hash: h1, entries: []hamtEntry{{key: k1, val: v1}, {key: k2, val: v2}}}
+	}
+	added := false
+	return emptyBitmapNode.
+		assoc(shift, h1, k1, v1, &added).
+		assoc(shift, h2, k2, v2, &added)
+}
+
+func (n *bitmapNode) without(shift uint, hash uint32, key Value) hamtNode {
+	bit := bitpos(hash, shift)
+	if n.bitmap&bit == 0 {
+		return n
+	}
+	idx := n.index(bit)
+	e := n.entries[idx]
+	if e.node != nil {
+		sub := e.node.without(shift+hamtBits, hash, key)
+		if sub == e.node {
+			return n
+		}
+		if sub != nil {
+			return n.withEntry(idx, hamtEntry{node: sub})
+		}
+	} else if !Equals(key, e.key) {
+		return n
+	}
+	if n.bitmap == bit {
+		return nil
+	}
+	entries := make([]hamtEntry, len(n.entries)-1)
+	copy(entries, n.entries[:idx])
+	copy(entries[idx:], n.entries[idx+1:])
+	return &bitmapNode{bitmap: n.bitmap ^ bit, entries: entries}
+}
+
+func (n *bitmapNode) find(shift uint, hash uint32, key Value) (Value, bool) {
+	bit := bitpos(hash, shift)
+	if n.bitmap&bit == 0 {
+		return nil, false
+	}
+	e := n.entries[n.index(bit)]
+	if e.node != nil {
+		return e.node.find(shift+hamtBits, hash, key)
+	}
+	if Equals(key, e.key) {
+		return e.val, true
+	}
+	return nil, false
+}
+
+func (n *bitmapNode) each(fn func(Value, Value) bool) bool {
+	for i := range n.entries {
+		e := &n.entries[i]
+		if e.node != nil {
+			if !e.node.each(fn) {
+				return false
+			}
+			continue
+		}
+		if !fn(e.key, e.val) {
+			return false
+		}
+	}
+	return true
+}
+
+// collisionNode holds keys with identical hashes
+type collisionNode struct {
+	hash    uint32
+	entries []hamtEntry
+}
+
+func (n *collisionNode) indexOf(key Value) int {
+	for i := range n.entries {
+		if Equals(key, n.entries[i].key) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (n *collisionNode) assoc(shift uint, hash uint32, key Value, val Value, added *bool) hamtNode {
+	if hash != n.hash {
+		// push this node down a level so that it can live next to the new key
+		parent := &bitmapNode{bitmap: bitpos(n.hash, shift), entries: []hamtEntry{{node: n}}}
+		return parent.assoc(shift, hash, key, val, added)
+	}
+	idx := n.indexOf(key)
+	entries := make([]hamtEntry, len(n.entries), len(n.entries)+1)
+	copy(entries, n.entries)
+	if idx >= 0 {
+		entries[idx].val = val
+	} else {
+		entries = append(entries, hamtEntry{key: key, val: val})
+		*added = true
+	}
+	return &collisionNode{hash: n.hash, entries: entries}
+}
+
+func (n *collisionNode) without(shift uint, hash uint32, key Value) hamtNode {
+	idx := n.indexOf(key)
+	if idx < 0 {
+		return n
+	}
+	if len(n.entries) == 1 {
+		return nil
+	}
+	entries := make([]hamtEntry, len(n.entries)-1)
+	copy(entries, n.entries[:idx])
+	copy(entries[idx:], n.entries[idx+1:])
+	return &collisionNode{hash: n.hash, entries: entries}
+}
+
+func (n *collisionNode) find(shift uint, hash uint32, key Value) (Value, bool) {
+	if hash != n.hash {
+		return nil, false
+	}
+	idx := n.indexOf(key)
+	if idx < 0 {
+		return nil, false
+	}
+	return n.entries[idx].val, true
+}
+
+func (n *collisionNode) each(fn func(Value, Value) bool) bool {
+	for i := range n.entries {
+		if !fn(n.entries[i].key, n.entries[i].val) {
+			return false
+		}
+	}
+	return true
+}
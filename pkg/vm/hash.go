@@ -0,0 +1,185 @@
+/*
+ * Copyright (c) 2021 Marcin Gasperowicz <xnooga@gmail.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+ * documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit
+ * persons to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+ * Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+ * WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+ * OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package vm
+
+import (
+	"fmt"
+	"hash/fnv"
+	"reflect"
+)
+
+// Hasher is implemented by values which are compared and hashed by value rather than identity.
+// Values that are Equals must have the same Hash.
+type Hasher interface {
+	Hash() uint32
+	Equals(Value) bool
+}
+
+// Hash returns hash of v, values not implementing Hasher are hashed by identity
+func Hash(v Value) uint32 {
+	if h, ok := v.(Hasher); ok {
+		return h.Hash()
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan, reflect.UnsafePointer:
+		return hashUint64(uint64(rv.Pointer()))
+	}
+	return hashString(v.Type().Name()) ^ hashString(v.String())
+}
+
+// Equals checks if a and b are equal, values not implementing Hasher are compared by identity
+func Equals(a Value, b Value) bool {
+	if h, ok := a.(Hasher); ok {
+		return h.Equals(b)
+	}
+	if h, ok := b.(Hasher); ok {
+		return h.Equals(a)
+	}
+	ta, tb := reflect.TypeOf(a), reflect.TypeOf(b)
+	if ta != tb {
+		return false
+	}
+	if ta == nil || !ta.Comparable() {
+		return ta == nil
+	}
+	return a == b
+}
+
+// hashGo hashes a plain Go value consistently with equalsGo
+func hashGo(v interface{}) uint32 {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() {
+		return 0
+	}
+	if !rv.Type().Comparable() {
+		switch rv.Kind() {
+		case reflect.Slice, reflect.Map, reflect.Func:
+			return hashUint64(uint64(rv.Pointer()))
+		}
+		// structs and arrays holding non-comparable fields can only be equal to themselves
+		return hashString(rv.Type().String())
+	}
+	return hashString(fmt.Sprintf("%T %#v", v, v))
+}
+
+// equalsGo compares plain Go values with ==, values which are not comparable in Go
+// are equal when they share the same backing storage
+func equalsGo(a interface{}, b interface{}) bool {
+	ta, tb := reflect.TypeOf(a), reflect.TypeOf(b)
+	if ta != tb {
+		return false
+	}
+	if ta == nil || ta.Comparable() {
+		return a == b
+	}
+	ra, rb := reflect.ValueOf(a), reflect.ValueOf(b)
+	switch ta.Kind() {
+	case reflect.Slice:
+		return ra.Len() == rb.Len() && ra.Pointer() == rb.Pointer()
+	case reflect.Map, reflect.Func:
+		return ra.Pointer() == rb.Pointer()
+	}
+	return false
+}
+
+func hashString(s string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(s))
+	return h.Sum32()
+}
+
+// hashUint64 mixes bits of n, this is the finalizer of MurmurHash3
+func hashUint64(n uint64) uint32 {
+	n ^= n >> 33
+	n *= 0xff51afd7ed558ccd
+	n ^= n >> 33
+	n *= 0xc4ceb9fe1a85ec53
+	n ^= n >> 33
+	return uint32(n)
+}
+
+// sequential is implemented by ordered collections which are equal when their elements are equal
+type sequential interface {
+	Value
+	sequentialValues() []Value
+}
+
+func hashSequential(vs []Value) uint32 {
+	var h uint32 = 1
+	for i := range vs {
+		h = 31*h + Hash(vs[i])
+	}
+	return h
+}
+
+// equalsSeq compares s with o one element at a time, it stops at the first mismatch
+// or when either side ends so infinite seqs can be compared with finite ones
+func equalsSeq(s Seq, o Value) bool {
+	if _, ok := o.(sequential); !ok {
+		return false
+	}
+	b, err := toSeq(o)
+	if err != nil {
+		return false
+	}
+	for s = forceSeq(s); s != EmptyList; s = s.Next() {
+		if b == EmptyList || !Equals(s.First(), b.First()) {
+			return false
+		}
+		b = b.Next()
+	}
+	return b == EmptyList
+}
+
+// hashSeq hashes s like hashSequential without collecting its elements first
+func hashSeq(s Seq) uint32 {
+	var h uint32 = 1
+	for s = forceSeq(s); s != EmptyList; s = s.Next() {
+		h = 31*h + Hash(s.First())
+	}
+	return h
+}
+
+func equalsSequential(a []Value, o Value) bool {
+	s, ok := o.(sequential)
+	if !ok {
+		return false
+	}
+	if b, ok := o.(Seq); ok {
+		// o might be lazy and infinite so it's only realized as far as a goes
+		b = forceSeq(b)
+		for i := range a {
+			if b == EmptyList || !Equals(a[i], b.First()) {
+				return false
+			}
+			b = b.Next()
+		}
+		return b == EmptyList
+	}
+	b := s.sequentialValues()
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !Equals(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
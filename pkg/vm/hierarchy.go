@@ -0,0 +1,171 @@
+/*
+ * Copyright (c) 2021 Marcin Gasperowicz <xnooga@gmail.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+ * documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit
+ * persons to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+ * Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+ * WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+ * OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package vm
+
+import "fmt"
+
+// Hierarchies are maps holding :parents, :ancestors and :descendants relations. Each relation maps tags
+// to sets of tags, so hierarchies are plain immutable values.
+
+var (
+	keywordParents     = Keyword("parents")
+	keywordAncestors   = Keyword("ancestors")
+	keywordDescendants = Keyword("descendants")
+)
+
+// GlobalHierarchy holds the hierarchy used by derive, isa? and multimethods when no other is given
+var GlobalHierarchy = NewAtom(MakeHierarchy())
+
+// MakeHierarchy returns an empty hierarchy
+func MakeHierarchy() *Map {
+	// not using EmptyMap here since GlobalHierarchy is initialized before map.go init runs
+	empty := &Map{}
+	return empty.
+		Assoc(keywordParents, empty).
+		Assoc(keywordAncestors, empty).
+		Assoc(keywordDescendants, empty).(*Map)
+}
+
+// hierarchyRelation returns the set of tags related to tag in relation rel of h
+func hierarchyRelation(h Value, rel Keyword, tag Value) *Set {
+	hl, ok := h.(Lookup)
+	if !ok {
+		return EmptySet
+	}
+	r, ok := hl.ValueAt(rel).(*Map)
+	if !ok {
+		return EmptySet
+	}
+	s, ok := r.ValueAt(tag).(*Set)
+	if !ok {
+		return EmptySet
+	}
+	return s
+}
+
+// HierarchyParents returns the set of direct parents of tag in h
+func HierarchyParents(h Value, tag Value) *Set {
+	return hierarchyRelation(h, keywordParents, tag)
+}
+
+// HierarchyAncestors returns the set of all ancestors of tag in h
+func HierarchyAncestors(h Value, tag Value) *Set {
+	return hierarchyRelation(h, keywordAncestors, tag)
+}
+
+// HierarchyDescendants returns the set of all descendants of tag in h
+func HierarchyDescendants(h Value, tag Value) *Set {
+	return hierarchyRelation(h, keywordDescendants, tag)
+}
+
+// Isa checks if child is equal to parent or derives from it in h, vectors are compared element by element
+func Isa(h Value, child Value, parent Value) bool {
+	if Equals(child, parent) {
+		return true
+	}
+	if hierarchyRelation(h, keywordAncestors, child).Contains(parent) {
+		return true
+	}
+	cs, cok := vectorValues(child)
+	ps, pok := vectorValues(parent)
+	if !cok || !pok || len(cs) != len(ps) {
+		return false
+	}
+	for i := range cs {
+		if !Isa(h, cs[i], ps[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func vectorValues(v Value) ([]Value, bool) {
+	switch vec := v.(type) {
+	case ArrayVector:
+		return vec, true
+	case *PersistentVector:
+		return vec.Unbox().([]Value), true
+	}
+	return nil, false
+}
+
+// Derive returns h with parent added to parents of tag
+func Derive(h *Map, tag Value, parent Value) (*Map, error) {
+	if Equals(tag, parent) {
+		return nil, NewExecutionError(fmt.Sprintf("%s can't derive from itself", tag))
+	}
+	if hierarchyRelation(h, keywordParents, tag).Contains(parent) {
+		return h, nil
+	}
+	if Isa(h, parent, tag) {
+		return nil, NewExecutionError(fmt.Sprintf("cyclic derivation: %s already derives from %s", parent, tag))
+	}
+	parents := addToSet(h.ValueAt(keywordParents), tag, []Value{parent})
+
+	// tag and everything below it gain parent and everything above it
+	up := append([]Value{parent}, HierarchyAncestors(h, parent).values()...)
+	down := append([]Value{tag}, HierarchyDescendants(h, tag).values()...)
+	ancestors := h.ValueAt(keywordAncestors)
+	for _, d := range down {
+		ancestors = addToSet(ancestors, d, up)
+	}
+	descendants := h.ValueAt(keywordDescendants)
+	for _, u := range up {
+		descendants = addToSet(descendants, u, down)
+	}
+
+	ret := h.Assoc(keywordParents, parents).
+		Assoc(keywordAncestors, ancestors).
+		Assoc(keywordDescendants, descendants)
+	return ret.(*Map), nil
+}
+
+// addToSet adds vals to the set kept under tag in relation rel
+func addToSet(rel Value, tag Value, vals []Value) Value {
+	r, ok := rel.(*Map)
+	if !ok {
+		r = EmptyMap
+	}
+	s, ok := r.ValueAt(tag).(*Set)
+	if !ok {
+		s = EmptySet
+	}
+	for _, v := range vals {
+		s = s.Conj(v)
+	}
+	return r.Assoc(tag, s)
+}
+
+// DeriveGlobal adds parent to parents of tag in the global hierarchy
+func DeriveGlobal(tag Value, parent Value) error {
+	for {
+		old := GlobalHierarchy.Deref()
+		h, ok := old.(*Map)
+		if !ok {
+			return NewTypeError(old, "is not a hierarchy", MapType)
+		}
+		nh, err := Derive(h, tag, parent)
+		if err != nil {
+			return err
+		}
+		ok, err = GlobalHierarchy.CompareAndSet(old, nh)
+		if err != nil || ok {
+			return err
+		}
+	}
+}
@@ -61,3 +61,19 @@ func (l Int) Unbox() interface{} {
 func (l Int) String() string {
 	return fmt.Sprintf("%d", int(l))
 }
+
+// Hash implements Hasher
+func (l Int) Hash() uint32 {
+	return hashUint64(uint64(l))
+}
+
+// Equals implements Hasher, Ints are equal to BigInts of the same value
+func (l Int) Equals(o Value) bool {
+	switch n := o.(type) {
+	case Int:
+		return n == l
+	case *BigInt:
+		return n.Equals(l)
+	}
+	return false
+}
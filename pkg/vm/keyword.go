@@ -66,19 +66,32 @@ func (l Keyword) Arity() int {
 	return -1
 }
 
-func (l Keyword) Invoke(pargs []Value) Value {
+func (l Keyword) Invoke(pargs []Value) (ret Value, err error) {
+	defer recoverSeqError(&err)
 	vl := len(pargs)
 	if vl < 1 || vl > 2 {
-		// FIXME return error
-		return NIL
+		return NIL, NewExecutionError(fmt.Sprintf("wrong number of arguments (%d) passed to %s", vl, l))
 	}
 	as, ok := pargs[0].(Lookup)
 	if !ok {
-		// FIXME return error
-		return NIL
+		if vl == 2 {
+			return pargs[1], nil
+		}
+		return NIL, nil
 	}
 	if vl == 1 {
-		return as.ValueAt(l)
+		return as.ValueAt(l), nil
 	}
-	return as.ValueAtOr(l, pargs[1])
+	return as.ValueAtOr(l, pargs[1]), nil
+}
+
+// Hash implements Hasher
+func (l Keyword) Hash() uint32 {
+	return hashString(":" + string(l))
+}
+
+// Equals implements Hasher
+func (l Keyword) Equals(o Value) bool {
+	k, ok := o.(Keyword)
+	return ok && k == l
 }
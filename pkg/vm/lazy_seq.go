@@ -0,0 +1,531 @@
+/*
+ * Copyright (c) 2021 Marcin Gasperowicz <xnooga@gmail.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+ * documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit
+ * persons to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+ * Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+ * WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+ * OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package vm
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// seqError carries an error raised while realizing a lazy seq out of Seq methods, which can't return errors.
+// It is turned back into an error by Frame.run, NativeFn.Invoke and Invoke of collections.
+type seqError struct {
+	err error
+}
+
+func raiseSeqError(err error) {
+	panic(&seqError{err: err})
+}
+
+func recoverSeqError(err *error) {
+	if r := recover(); r != nil {
+		se, ok := r.(*seqError)
+		if !ok {
+			panic(r)
+		}
+		*err = se.err
+	}
+}
+
+// ToSeq returns a seq over elements of v, empty collections and nil become EmptyList and lazy seqs are realized
+func ToSeq(v Value) (ret Seq, err error) {
+	ret = EmptyList
+	defer recoverSeqError(&err)
+	return toSeq(v)
+}
+
+func toSeq(v Value) (Seq, error) {
+	switch s := v.(type) {
+	case *LazySeq:
+		return s.seq(), nil
+	case *PersistentVector:
+		return s.Seq(), nil
+	case ArrayVector:
+		if len(s) == 0 {
+			return EmptyList, nil
+		}
+		l, _ := ListType.Box([]Value(s))
+		return l.(*List), nil
+	case *List:
+		if s.count == 0 {
+			return EmptyList, nil
+		}
+		return s, nil
+	case *Map:
+		if s.count == 0 {
+			return EmptyList, nil
+		}
+		return s, nil
+	case *Set:
+		return s.Seq(), nil
+	case Seq:
+		return s, nil
+	}
+	if v == NIL {
+		return EmptyList, nil
+	}
+	return EmptyList, NewTypeError(v, "can't be turned into a seq", nil)
+}
+
+// forceSeq is toSeq for values known to be seqs
+func forceSeq(s Seq) Seq {
+	if s == nil {
+		return EmptyList
+	}
+	ret, _ := toSeq(s)
+	return ret
+}
+
+// SeqValues realizes v and returns its elements
+func SeqValues(v Value) (out []Value, err error) {
+	defer recoverSeqError(&err)
+	s, err := toSeq(v)
+	if err != nil {
+		return nil, err
+	}
+	return seqValues(s), nil
+}
+
+// PrintString returns v.String(), errors raised while realizing lazy seqs inside v are returned instead of panicking
+func PrintString(v Value) (s string, err error) {
+	defer recoverSeqError(&err)
+	return v.String(), nil
+}
+
+func seqValues(s Seq) []Value {
+	var out []Value
+	for s = forceSeq(s); s != EmptyList; s = s.Next() {
+		out = append(out, s.First())
+	}
+	return out
+}
+
+func seqString(s Seq) string {
+	b := &strings.Builder{}
+	b.WriteRune('(')
+	for s = forceSeq(s); s != EmptyList; s = s.Next() {
+		if b.Len() > 1 {
+			b.WriteRune(' ')
+		}
+		b.WriteString(s.First().String())
+	}
+	b.WriteRune(')')
+	return b.String()
+}
+
+func seqCount(s Seq) int {
+	n := 0
+	for s = forceSeq(s); s != EmptyList; {
+		if c, ok := s.(Chunked); ok {
+			n += len(c.ChunkFirst())
+			s = forceSeq(c.ChunkMore())
+			continue
+		}
+		n++
+		s = s.Next()
+	}
+	return n
+}
+
+type theLazySeqType struct{}
+
+func (t *theLazySeqType) String() string     { return t.Name() }
+func (t *theLazySeqType) Type() ValueType    { return TypeType }
+func (t *theLazySeqType) Unbox() interface{} { return reflect.TypeOf(t) }
+
+func (t *theLazySeqType) Name() string { return "let-go.lang.LazySeq" }
+
+func (t *theLazySeqType) Box(bare interface{}) (Value, error) {
+	return NIL, NewTypeError(bare, "can't be boxed as", t)
+}
+
+// LazySeqType is the type of LazySeqs
+var LazySeqType *theLazySeqType
+
+func init() {
+	LazySeqType = &theLazySeqType{}
+}
+
+// LazySeq is a seq whose elements are computed by fn on first access.
+// fn can return any seqable value, including another LazySeq.
+// Realization is guarded by a mutex so fn runs at most once even when many goroutines share the seq.
+type LazySeq struct {
+	mu sync.Mutex
+	fn func() (Value, error)
+	sv Value
+	s  Seq
+}
+
+// NewLazySeq creates a LazySeq realized by calling fn
+func NewLazySeq(fn func() (Value, error)) *LazySeq {
+	return &LazySeq{fn: fn}
+}
+
+// sval calls fn if that didn't happen yet, errors are raised as seqErrors and leave the seq unrealized
+func (l *LazySeq) sval() Value {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.svalLocked()
+}
+
+func (l *LazySeq) svalLocked() Value {
+	if l.fn != nil {
+		// fn is dropped before it runs so l doesn't pin whatever fn walks through,
+		// a failed fn can't be retried safely so the seq keeps failing with the same error
+		fn := l.fn
+		l.fn = nil
+		v, err := callLazy(fn)
+		if err != nil {
+			l.fn = func() (Value, error) { return NIL, err }
+			raiseSeqError(err)
+		}
+		l.sv = v
+	}
+	if l.sv != nil {
+		return l.sv
+	}
+	return l.s
+}
+
+// callLazy runs fn turning seqErrors raised by seqs it realizes into a returned error
+func callLazy(fn func() (Value, error)) (v Value, err error) {
+	defer recoverSeqError(&err)
+	return fn()
+}
+
+// seq realizes l, nested LazySeqs are unwrapped in a loop so long chains don't grow the Go stack
+func (l *LazySeq) seq() Seq {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.svalLocked()
+	if l.sv != nil {
+		v := l.sv
+		for {
+			ls, ok := v.(*LazySeq)
+			if !ok {
+				break
+			}
+			v = ls.sval()
+		}
+		s, err := toSeq(v)
+		if err != nil {
+			raiseSeqError(err)
+		}
+		l.sv = nil
+		l.s = s
+	}
+	if l.s == nil {
+		return EmptyList
+	}
+	return l.s
+}
+
+// IsRealized checks if fn was already called
+func (l *LazySeq) IsRealized() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.fn == nil
+}
+
+// Type implements Value
+func (l *LazySeq) Type() ValueType { return LazySeqType }
+
+// Unbox implements Value
+func (l *LazySeq) Unbox() interface{} {
+	return seqValues(l)
+}
+
+// First implements Seq
+func (l *LazySeq) First() Value {
+	s := l.seq()
+	if s == EmptyList {
+		return NIL
+	}
+	return s.First()
+}
+
+// More implements Seq
+func (l *LazySeq) More() Seq {
+	s := l.seq()
+	if s == EmptyList {
+		return EmptyList
+	}
+	return s.More()
+}
+
+// Next implements Seq
+func (l *LazySeq) Next() Seq {
+	s := l.seq()
+	if s == EmptyList {
+		return EmptyList
+	}
+	return s.Next()
+}
+
+// Cons implements Seq
+func (l *LazySeq) Cons(val Value) Seq {
+	return NewCons(val, l)
+}
+
+// Count implements Collection, it realizes the whole seq
+func (l *LazySeq) Count() Value {
+	return Int(seqCount(l))
+}
+
+// RawCount implements Collection, it realizes the whole seq
+func (l *LazySeq) RawCount() int {
+	return seqCount(l)
+}
+
+// Empty implements Collection
+func (l *LazySeq) Empty() Collection {
+	return EmptyList
+}
+
+func (l *LazySeq) String() string {
+	return seqString(l)
+}
+
+func (l *LazySeq) sequentialValues() []Value {
+	return seqValues(l)
+}
+
+// Hash implements Hasher
+func (l *LazySeq) Hash() uint32 {
+	return hashSeq(l)
+}
+
+// Equals implements Hasher
+func (l *LazySeq) Equals(o Value) bool {
+	return equalsSeq(l, o)
+}
+
+type theConsType struct{}
+
+func (t *theConsType) String() string     { return t.Name() }
+func (t *theConsType) Type() ValueType    { return TypeType }
+func (t *theConsType) Unbox() interface{} { return reflect.TypeOf(t) }
+
+func (t *theConsType) Name() string { return "let-go.lang.Cons" }
+
+func (t *theConsType) Box(bare interface{}) (Value, error) {
+	return NIL, NewTypeError(bare, "can't be boxed as", t)
+}
+
+// ConsType is the type of Cons cells
+var ConsType *theConsType
+
+func init() {
+	ConsType = &theConsType{}
+}
+
+// Cons is a seq cell holding first element in front of an arbitrary, possibly lazy, seq
+type Cons struct {
+	first Value
+	more  Seq
+}
+
+// NewCons creates a Cons cell with first in front of more
+func NewCons(first Value, more Seq) *Cons {
+	return &Cons{first: first, more: more}
+}
+
+// Type implements Value
+func (c *Cons) Type() ValueType { return ConsType }
+
+// Unbox implements Value
+func (c *Cons) Unbox() interface{} {
+	return seqValues(c)
+}
+
+// First implements Seq
+func (c *Cons) First() Value {
+	return c.first
+}
+
+// More implements Seq
+func (c *Cons) More() Seq {
+	if c.more == nil {
+		return EmptyList
+	}
+	return c.more
+}
+
+// Next implements Seq
+func (c *Cons) Next() Seq {
+	return forceSeq(c.more)
+}
+
+// Cons implements Seq
+func (c *Cons) Cons(val Value) Seq {
+	return NewCons(val, c)
+}
+
+// Count implements Collection
+func (c *Cons) Count() Value {
+	return Int(seqCount(c))
+}
+
+// RawCount implements Collection
+func (c *Cons) RawCount() int {
+	return seqCount(c)
+}
+
+// Empty implements Collection
+func (c *Cons) Empty() Collection {
+	return EmptyList
+}
+
+func (c *Cons) String() string {
+	return seqString(c)
+}
+
+func (c *Cons) sequentialValues() []Value {
+	return seqValues(c)
+}
+
+// Hash implements Hasher
+func (c *Cons) Hash() uint32 {
+	return hashSeq(c)
+}
+
+// Equals implements Hasher
+func (c *Cons) Equals(o Value) bool {
+	return equalsSeq(c, o)
+}
+
+// Chunked is implemented by seqs which can hand out their elements in blocks, lazy seq functions
+// process whole chunks at once to cut the overhead of realizing elements one by one
+type Chunked interface {
+	Seq
+	// ChunkFirst returns elements of the first chunk, the slice must not be modified
+	ChunkFirst() []Value
+	// ChunkMore returns a seq of elements following the first chunk
+	ChunkMore() Seq
+}
+
+type theChunkedSeqType struct{}
+
+func (t *theChunkedSeqType) String() string     { return t.Name() }
+func (t *theChunkedSeqType) Type() ValueType    { return TypeType }
+func (t *theChunkedSeqType) Unbox() interface{} { return reflect.TypeOf(t) }
+
+func (t *theChunkedSeqType) Name() string { return "let-go.lang.ChunkedCons" }
+
+func (t *theChunkedSeqType) Box(bare interface{}) (Value, error) {
+	return NIL, NewTypeError(bare, "can't be boxed as", t)
+}
+
+// ChunkedSeqType is the type of ChunkedSeqs
+var ChunkedSeqType *theChunkedSeqType
+
+func init() {
+	ChunkedSeqType = &theChunkedSeqType{}
+}
+
+// ChunkedSeq is a realized chunk of elements in front of an arbitrary, possibly lazy, seq
+type ChunkedSeq struct {
+	chunk []Value
+	more  Seq
+}
+
+// NewChunkedSeq creates a ChunkedSeq, chunk must not be empty and must not be modified afterwards
+func NewChunkedSeq(chunk []Value, more Seq) *ChunkedSeq {
+	return &ChunkedSeq{chunk: chunk, more: more}
+}
+
+// ChunkFirst implements Chunked
+func (c *ChunkedSeq) ChunkFirst() []Value {
+	return c.chunk
+}
+
+// ChunkMore implements Chunked
+func (c *ChunkedSeq) ChunkMore() Seq {
+	if c.more == nil {
+		return EmptyList
+	}
+	return c.more
+}
+
+// Type implements Value
+func (c *ChunkedSeq) Type() ValueType { return ChunkedSeqType }
+
+// Unbox implements Value
+func (c *ChunkedSeq) Unbox() interface{} {
+	return seqValues(c)
+}
+
+// First implements Seq
+func (c *ChunkedSeq) First() Value {
+	return c.chunk[0]
+}
+
+// More implements Seq
+func (c *ChunkedSeq) More() Seq {
+	if len(c.chunk) > 1 {
+		return &ChunkedSeq{chunk: c.chunk[1:], more: c.more}
+	}
+	return c.ChunkMore()
+}
+
+// Next implements Seq
+func (c *ChunkedSeq) Next() Seq {
+	if len(c.chunk) > 1 {
+		return &ChunkedSeq{chunk: c.chunk[1:], more: c.more}
+	}
+	return forceSeq(c.more)
+}
+
+// Cons implements Seq
+func (c *ChunkedSeq) Cons(val Value) Seq {
+	return NewCons(val, c)
+}
+
+// Count implements Collection
+func (c *ChunkedSeq) Count() Value {
+	return Int(seqCount(c))
+}
+
+// RawCount implements Collection
+func (c *ChunkedSeq) RawCount() int {
+	return seqCount(c)
+}
+
+// Empty implements Collection
+func (c *ChunkedSeq) Empty() Collection {
+	return EmptyList
+}
+
+func (c *ChunkedSeq) String() string {
+	return seqString(c)
+}
+
+func (c *ChunkedSeq) sequentialValues() []Value {
+	return seqValues(c)
+}
+
+// Hash implements Hasher
+func (c *ChunkedSeq) Hash() uint32 {
+	return hashSeq(c)
+}
+
+// Equals implements Hasher
+func (c *ChunkedSeq) Equals(o Value) bool {
+	return equalsSeq(c, o)
+}
@@ -62,6 +62,7 @@ type List struct {
 	first Value
 	next  *List
 	count int
+	meta  *Map
 }
 
 // Type implements Value
@@ -115,9 +116,25 @@ func (l *List) Cons(val Value) Seq {
 		first: val,
 		next:  l,
 		count: l.count + 1,
+		meta:  l.meta,
 	}
 }
 
+// Meta implements IMeta
+func (l *List) Meta() *Map {
+	return l.meta
+}
+
+// WithMeta implements IObj, only the head of the list carries metadata
+func (l *List) WithMeta(meta *Map) IObj {
+	if meta == l.meta {
+		return l
+	}
+	ret := *l
+	ret.meta = meta
+	return &ret
+}
+
 // Count implements Collection
 func (l *List) Count() Value {
 	return Int(l.count)
@@ -129,7 +146,7 @@ func (l *List) RawCount() int {
 
 // Empty implements Collection
 func (l *List) Empty() Collection {
-	return EmptyList
+	return EmptyList.WithMeta(l.meta).(Collection)
 }
 
 func (l *List) String() string {
@@ -147,10 +164,27 @@ func (l *List) String() string {
 	return b.String()
 }
 
-func NewList(vs []Value) Value {
-	li, err := ListType.Box(vs)
-	if err != nil {
-		return EmptyList
+func NewList(vs []Value) (Value, error) {
+	return ListType.Box(vs)
+}
+
+func (l *List) sequentialValues() []Value {
+	return l.Unbox().([]Value)
+}
+
+// Hash implements Hasher
+func (l *List) Hash() uint32 {
+	var h uint32 = 1
+	for c := l; c.count > 0; c = c.next {
+		h = 31*h + Hash(c.first)
+	}
+	return h
+}
+
+// Equals implements Hasher, lists are equal to other sequential collections with equal elements
+func (l *List) Equals(o Value) bool {
+	if ol, ok := o.(*List); ok && ol == l {
+		return true
 	}
-	return li
+	return equalsSequential(l.sequentialValues(), o)
 }
@@ -18,6 +18,7 @@
 package vm
 
 import (
+	"fmt"
 	"reflect"
 	"strings"
 )
@@ -35,160 +36,268 @@ func (t *theMapType) Box(bare interface{}) (Value, error) {
 	if !ok {
 		return NIL, NewTypeError(bare, "can't be boxed as", t)
 	}
-
-	return Map(casted), nil
+	var ret Associative = EmptyMap
+	for k, v := range casted {
+		ret = ret.Assoc(k, v)
+	}
+	return ret, nil
 }
 
-// ArrayVectorType is the type of ArrayVectors
+// MapType is the type of Maps
 var MapType *theMapType
 
+// EmptyMap is an empty Map
+var EmptyMap *Map
+
 func init() {
 	MapType = &theMapType{}
+	EmptyMap = &Map{}
 }
 
-// Map is boxed singly linked list that can hold other Values.
-type Map map[Value]Value
+// Map is a persistent hash map implemented as a hash array mapped trie.
+// Updates share structure with the original map and cost O(log32 n).
+type Map struct {
+	root  hamtNode
+	count int
+	meta  *Map
+}
 
 // Type implements Value
-func (l Map) Type() ValueType { return MapType }
+func (l *Map) Type() ValueType { return MapType }
 
-// Unbox implements Value
-func (l Map) Unbox() interface{} {
-	return map[Value]Value(l)
+// Unbox implements Value, keys which are not comparable in Go are left out
+func (l *Map) Unbox() interface{} {
+	ret := make(map[Value]Value, l.count)
+	l.Each(func(k Value, v Value) {
+		if t := reflect.TypeOf(k); t != nil && !t.Comparable() {
+			return
+		}
+		ret[k] = v
+	})
+	return ret
 }
 
-// First implements Seq
-func (l Map) First() Value {
-	if len(l) == 0 {
-		return NIL
-	}
-	for k, v := range l {
-		return ArrayVector{k, v}
+// Each calls fn for every entry of the map
+func (l *Map) Each(fn func(Value, Value)) {
+	if l.root == nil {
+		return
 	}
-	return NIL // unreachable
+	l.root.each(func(k Value, v Value) bool {
+		fn(k, v)
+		return true
+	})
 }
 
-func toList(l Map) *List {
-	var lst []Value
-	for k, v := range l {
-		lst = append(lst, ArrayVector{k, v})
-	}
-	ret, _ := ListType.Box(lst)
+func (l *Map) entries() []Value {
+	ret := make([]Value, 0, l.count)
+	l.Each(func(k Value, v Value) {
+		ret = append(ret, ArrayVector{k, v})
+	})
+	return ret
+}
+
+func (l *Map) toList() *List {
+	ret, _ := ListType.Box(l.entries())
 	return ret.(*List)
 }
 
+// First implements Seq
+func (l *Map) First() Value {
+	if l.root == nil {
+		return NIL
+	}
+	var ret Value = NIL
+	l.root.each(func(k Value, v Value) bool {
+		ret = ArrayVector{k, v}
+		return false
+	})
+	return ret
+}
+
 // More implements Seq
-func (l Map) More() Seq {
-	if len(l) == 1 {
+func (l *Map) More() Seq {
+	if l.count <= 1 {
 		return EmptyList
 	}
-	ret := toList(l)
-	return ret.More()
+	return l.toList().More()
 }
 
 // Next implements Seq
-func (l Map) Next() Seq {
+func (l *Map) Next() Seq {
 	return l.More()
 }
 
 // Cons implements Seq
-func (l Map) Cons(val Value) Seq {
-	return toList(l).Cons(val)
+func (l *Map) Cons(val Value) Seq {
+	return l.toList().Cons(val)
 }
 
 // Count implements Collection
-func (l Map) Count() Value {
-	return Int(len(l))
+func (l *Map) Count() Value {
+	return Int(l.count)
 }
 
-func (l Map) RawCount() int {
-	return len(l)
+func (l *Map) RawCount() int {
+	return l.count
 }
 
 // Empty implements Collection
-func (l Map) Empty() Collection {
-	return make(Map)
+func (l *Map) Empty() Collection {
+	return EmptyMap.WithMeta(l.meta).(Collection)
+}
+
+// Meta implements IMeta
+func (l *Map) Meta() *Map {
+	return l.meta
 }
 
-func (l Map) Assoc(k Value, v Value) Associative {
-	// FIXME implement persistent maps :P
-	newmap := make(Map)
-	for ok, ov := range l {
-		newmap[ok] = ov
+// WithMeta implements IObj
+func (l *Map) WithMeta(meta *Map) IObj {
+	if meta == l.meta {
+		return l
 	}
-	newmap[k] = v
-	return newmap
+	ret := *l
+	ret.meta = meta
+	return &ret
 }
 
-func (l Map) Dissoc(k Value) Associative {
-	// FIXME implement persistent maps :P
-	newmap := make(Map)
-	for ok, ov := range l {
-		if ok == k {
-			continue
-		}
-		newmap[ok] = ov
+func (l *Map) Assoc(k Value, v Value) Associative {
+	root := l.root
+	if root == nil {
+		root = emptyBitmapNode
+	}
+	added := false
+	newRoot := root.assoc(0, Hash(k), k, v, &added)
+	if newRoot == root {
+		return l
+	}
+	count := l.count
+	if added {
+		count++
+	}
+	return &Map{root: newRoot, count: count, meta: l.meta}
+}
+
+func (l *Map) Dissoc(k Value) Associative {
+	if l.root == nil {
+		return l
 	}
-	return newmap
+	newRoot := l.root.without(0, Hash(k), k)
+	if newRoot == l.root {
+		return l
+	}
+	if newRoot == nil {
+		return EmptyMap.WithMeta(l.meta).(Associative)
+	}
+	return &Map{root: newRoot, count: l.count - 1, meta: l.meta}
 }
 
-func (l Map) ValueAt(key Value) Value {
+// Contains checks if key is present in the map
+func (l *Map) Contains(key Value) bool {
+	if l.root == nil {
+		return false
+	}
+	_, ok := l.root.find(0, Hash(key), key)
+	return ok
+}
+
+func (l *Map) ValueAt(key Value) Value {
 	return l.ValueAtOr(key, NIL)
 }
 
-func (l Map) ValueAtOr(key Value, dflt Value) Value {
-	ret, ok := l[key]
+func (l *Map) ValueAtOr(key Value, dflt Value) Value {
+	if l.root == nil {
+		return dflt
+	}
+	ret, ok := l.root.find(0, Hash(key), key)
 	if !ok {
 		return dflt
 	}
 	return ret
 }
 
-func NewMap(v []Value) Value {
-	if len(v) == 0 {
-		return make(Map)
+func NewMap(v []Value) (Value, error) {
+	if len(v)%2 != 0 {
+		return NIL, NewExecutionError("map requires an even number of forms")
+	}
+	var newmap Associative = EmptyMap
+	for i := 0; i < len(v); i += 2 {
+		newmap = newmap.Assoc(v[i], v[i+1])
 	}
+	return newmap, nil
+}
+
+// NewMapLiteral is NewMap refusing equal keys, map literals are built with it
+func NewMapLiteral(v []Value) (Value, error) {
 	if len(v)%2 != 0 {
-		// FIXME this is an error
-		return NIL
+		return NIL, NewExecutionError("map literal must contain even number of forms")
 	}
-	newmap := make(Map)
+	ret := EmptyMap
 	for i := 0; i < len(v); i += 2 {
-		newmap[v[i]] = v[i+1]
+		if ret.Contains(v[i]) {
+			return NIL, NewExecutionError(fmt.Sprintf("duplicate key: %v", v[i]))
+		}
+		ret = ret.Assoc(v[i], v[i+1]).(*Map)
 	}
-	return newmap
+	return ret, nil
 }
 
-func (l Map) String() string {
+// Hash implements Hasher
+func (l *Map) Hash() uint32 {
+	var h uint32
+	l.Each(func(k Value, v Value) {
+		h += Hash(k) ^ Hash(v)
+	})
+	return h
+}
+
+// Equals implements Hasher
+func (l *Map) Equals(o Value) bool {
+	om, ok := o.(*Map)
+	if !ok || om.count != l.count {
+		return false
+	}
+	if om == l {
+		return true
+	}
+	if l.root == nil {
+		return true
+	}
+	return l.root.each(func(k Value, v Value) bool {
+		ov, ok := om.root.find(0, Hash(k), k)
+		return ok && Equals(v, ov)
+	})
+}
+
+func (l *Map) String() string {
 	b := &strings.Builder{}
 	b.WriteRune('{')
 	i := 0
-	n := len(l)
-	for k, v := range l {
+	l.Each(func(k Value, v Value) {
+		if i > 0 {
+			b.WriteRune(' ')
+		}
 		b.WriteString(k.String())
 		b.WriteRune(' ')
 		b.WriteString(v.String())
-		if i < n-1 {
-			b.WriteRune(' ')
-		}
 		i++
-	}
+	})
 	b.WriteRune('}')
 	return b.String()
 }
 
-func (l Map) Arity() int {
+func (l *Map) Arity() int {
 	return -1
 }
 
-func (l Map) Invoke(pargs []Value) Value {
+func (l *Map) Invoke(pargs []Value) (ret Value, err error) {
+	defer recoverSeqError(&err)
 	vl := len(pargs)
 	if vl < 1 || vl > 2 {
-		// FIXME return error
-		return NIL
+		return NIL, NewExecutionError(fmt.Sprintf("wrong number of arguments (%d) passed to a map", vl))
 	}
 	if vl == 1 {
-		return l.ValueAt(pargs[0])
+		return l.ValueAt(pargs[0]), nil
 	}
-	return l.ValueAtOr(pargs[0], pargs[1])
+	return l.ValueAtOr(pargs[0], pargs[1]), nil
 }
@@ -0,0 +1,94 @@
+/*
+ * Copyright (c) 2021 Marcin Gasperowicz <xnooga@gmail.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+ * documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit
+ * persons to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+ * Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+ * WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+ * OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package vm
+
+// IMeta is implemented by values carrying metadata, metadata never takes part in equality
+type IMeta interface {
+	Value
+	Meta() *Map // nil if there is no metadata
+}
+
+// IObj is implemented by immutable values which can be copied with different metadata
+type IObj interface {
+	IMeta
+	WithMeta(*Map) IObj
+}
+
+// WithMeta returns v with metadata replaced by meta, fns which don't carry metadata themselves get wrapped.
+// Keywords and vars are invokable but are compared by identity, so they can't be wrapped.
+func WithMeta(v Value, meta *Map) (Value, error) {
+	switch o := v.(type) {
+	case IObj:
+		return o.WithMeta(meta), nil
+	case Keyword:
+		return NIL, NewTypeError(v, "can't carry metadata", nil)
+	case *Var:
+		return NIL, NewTypeError(v, "can't carry metadata, use alter-meta! on vars", nil)
+	case Fn:
+		return &MetaFn{fn: o, meta: meta}, nil
+	}
+	return NIL, NewTypeError(v, "can't carry metadata", nil)
+}
+
+// MetaOf returns metadata of v or NIL if it has none
+func MetaOf(v Value) Value {
+	if o, ok := v.(IMeta); ok {
+		if m := o.Meta(); m != nil {
+			return m
+		}
+	}
+	return NIL
+}
+
+// MetaFn is a fn with metadata attached, it behaves exactly like the fn it wraps
+type MetaFn struct {
+	fn   Fn
+	meta *Map
+}
+
+// Type implements Value
+func (l *MetaFn) Type() ValueType { return l.fn.Type() }
+
+// Unbox implements Value
+func (l *MetaFn) Unbox() interface{} {
+	return l.fn.Unbox()
+}
+
+func (l *MetaFn) String() string {
+	return l.fn.String()
+}
+
+// Invoke implements Fn
+func (l *MetaFn) Invoke(args []Value) (Value, error) {
+	return l.fn.Invoke(args)
+}
+
+// Arity implements Fn
+func (l *MetaFn) Arity() int {
+	return l.fn.Arity()
+}
+
+// Meta implements IMeta
+func (l *MetaFn) Meta() *Map {
+	return l.meta
+}
+
+// WithMeta implements IObj, the fn is never wrapped twice
+func (l *MetaFn) WithMeta(meta *Map) IObj {
+	return &MetaFn{fn: l.fn, meta: meta}
+}
@@ -0,0 +1,206 @@
+/*
+ * Copyright (c) 2021 Marcin Gasperowicz <xnooga@gmail.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+ * documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit
+ * persons to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+ * Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+ * WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+ * OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package vm
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+type theMultiFnType struct{}
+
+func (t *theMultiFnType) String() string     { return t.Name() }
+func (t *theMultiFnType) Type() ValueType    { return TypeType }
+func (t *theMultiFnType) Unbox() interface{} { return reflect.TypeOf(t) }
+
+func (t *theMultiFnType) Name() string { return "let-go.lang.MultiFn" }
+func (t *theMultiFnType) Box(b interface{}) (Value, error) {
+	return NIL, NewTypeError(b, "can't be boxed as", t)
+}
+
+// MultiFnType is the type of MultiFns
+var MultiFnType *theMultiFnType
+
+func init() {
+	MultiFnType = &theMultiFnType{}
+}
+
+// MultiFn is a function dispatching to methods chosen by the value of a dispatch function. Dispatch values
+// are matched with isa? in a hierarchy, so methods for parent tags handle their descendants too.
+type MultiFn struct {
+	name         string
+	dispatch     Fn
+	defaultValue Value
+	hierarchy    Reference
+	mu           sync.RWMutex
+	methods      *Map // dispatch value -> Fn
+	prefers      *Map // dispatch value -> set of dispatch values it is preferred over
+}
+
+// NewMultiFn creates a MultiFn, dispatch values are looked up in hierarchy or the global one if it's nil
+func NewMultiFn(name string, dispatch Fn, defaultValue Value, hierarchy Reference) *MultiFn {
+	if hierarchy == nil {
+		hierarchy = GlobalHierarchy
+	}
+	return &MultiFn{
+		name:         name,
+		dispatch:     dispatch,
+		defaultValue: defaultValue,
+		hierarchy:    hierarchy,
+		methods:      EmptyMap,
+		prefers:      EmptyMap,
+	}
+}
+
+// Type implements Value
+func (m *MultiFn) Type() ValueType { return MultiFnType }
+
+// Unbox implements Value
+func (m *MultiFn) Unbox() interface{} { return m }
+
+func (m *MultiFn) String() string {
+	return fmt.Sprintf("<multi-fn %s>", m.name)
+}
+
+// Arity implements Fn
+func (m *MultiFn) Arity() int {
+	return -1
+}
+
+// Invoke implements Fn
+func (m *MultiFn) Invoke(args []Value) (Value, error) {
+	dv, err := m.dispatch.Invoke(args)
+	if err != nil {
+		return NIL, err
+	}
+	f, err := m.Method(dv)
+	if err != nil {
+		return NIL, err
+	}
+	if f == nil {
+		return NIL, NewExecutionError(fmt.Sprintf("no method in multimethod %s for dispatch value %s", m.name, dv))
+	}
+	return f.Invoke(args)
+}
+
+// AddMethod makes f handle dispatch value dv
+func (m *MultiFn) AddMethod(dv Value, f Fn) {
+	m.mu.Lock()
+	m.methods = m.methods.Assoc(dv, f).(*Map)
+	m.mu.Unlock()
+}
+
+// RemoveMethod removes the method handling dispatch value dv
+func (m *MultiFn) RemoveMethod(dv Value) {
+	m.mu.Lock()
+	m.methods = m.methods.Dissoc(dv).(*Map)
+	m.mu.Unlock()
+}
+
+// RemoveAllMethods removes all methods and preferences
+func (m *MultiFn) RemoveAllMethods() {
+	m.mu.Lock()
+	m.methods = EmptyMap
+	m.prefers = EmptyMap
+	m.mu.Unlock()
+}
+
+// PreferMethod makes the method for x win over the method for y when both match a dispatch value
+func (m *MultiFn) PreferMethod(x Value, y Value) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := m.hierarchy.Deref()
+	if m.isPreferred(h, y, x) {
+		return NewExecutionError(fmt.Sprintf("preference conflict in multimethod %s: %s is already preferred to %s",
+			m.name, y, x))
+	}
+	m.prefers = addToSet(m.prefers, x, []Value{y}).(*Map)
+	return nil
+}
+
+// Methods returns a map from dispatch values to methods
+func (m *MultiFn) Methods() *Map {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.methods
+}
+
+// Prefers returns a map from dispatch values to sets of dispatch values they are preferred over
+func (m *MultiFn) Prefers() *Map {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.prefers
+}
+
+// Method returns the method handling dispatch value dv, nil if there is none
+func (m *MultiFn) Method(dv Value) (Fn, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if f, ok := m.methods.ValueAtOr(dv, nil).(Fn); ok {
+		return f, nil
+	}
+	h := m.hierarchy.Deref()
+	var best Value
+	var candidates []Value
+	m.methods.Each(func(k Value, _ Value) {
+		if !Isa(h, dv, k) {
+			return
+		}
+		candidates = append(candidates, k)
+		if best == nil || m.dominates(h, k, best) {
+			best = k
+		}
+	})
+	for _, c := range candidates {
+		if !Equals(c, best) && !m.dominates(h, best, c) {
+			return nil, NewExecutionError(fmt.Sprintf(
+				"multiple methods in multimethod %s match dispatch value %s: %s and %s, and neither is preferred",
+				m.name, dv, best, c))
+		}
+	}
+	if best != nil {
+		return m.methods.ValueAt(best).(Fn), nil
+	}
+	if f, ok := m.methods.ValueAtOr(m.defaultValue, nil).(Fn); ok {
+		return f, nil
+	}
+	return nil, nil
+}
+
+func (m *MultiFn) dominates(h Value, x Value, y Value) bool {
+	return m.isPreferred(h, x, y) || Isa(h, x, y)
+}
+
+// isPreferred checks if x was preferred over y, preferences are inherited through parents
+func (m *MultiFn) isPreferred(h Value, x Value, y Value) bool {
+	if s, ok := m.prefers.ValueAt(x).(*Set); ok && s.Contains(y) {
+		return true
+	}
+	for _, p := range HierarchyParents(h, y).values() {
+		if m.isPreferred(h, x, p) {
+			return true
+		}
+	}
+	for _, p := range HierarchyParents(h, x).values() {
+		if m.isPreferred(h, p, y) {
+			return true
+		}
+	}
+	return false
+}
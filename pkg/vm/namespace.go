@@ -20,6 +20,7 @@ package vm
 import (
 	"fmt"
 	"reflect"
+	"sync"
 )
 
 type theNamespaceType struct{}
@@ -44,10 +45,13 @@ type Refer struct {
 	all bool
 }
 
+// Namespace maps symbols to vars, it can be read and extended from many goroutines
 type Namespace struct {
 	name     string
+	mu       sync.RWMutex
 	registry map[Symbol]*Var
 	refers   map[Symbol]*Refer
+	mappings map[Symbol]*Var
 }
 
 func (n *Namespace) Type() ValueType { return NamespaceType }
@@ -62,6 +66,7 @@ func NewNamespace(name string) *Namespace {
 		name:     name,
 		registry: map[Symbol]*Var{},
 		refers:   map[Symbol]*Refer{},
+		mappings: map[Symbol]*Var{},
 	}
 }
 
@@ -69,53 +74,145 @@ func (n *Namespace) Def(name string, val Value) *Var {
 	s := Symbol(name)
 	va := NewVar(n, n.name, name)
 	va.SetRoot(val)
+	n.mu.Lock()
 	n.registry[s] = va
+	n.mu.Unlock()
 	return va
 }
 
 func (n *Namespace) LookupOrAdd(symbol Symbol) Value {
+	n.mu.Lock()
+	defer n.mu.Unlock()
 	val, ok := n.registry[symbol]
 	if !ok {
-		return n.Def(string(symbol), NIL)
+		val = NewVar(n, n.name, string(symbol))
+		n.registry[symbol] = val
 	}
 	return val
 }
 
+// lookupLocal finds a var defined in n, refers are not consulted
+func (n *Namespace) lookupLocal(symbol Symbol) *Var {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.registry[symbol]
+}
+
+// refer returns namespace referred to as name
+func (n *Namespace) refer(name Symbol) *Refer {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.refers[name]
+}
+
+// referredNamespaces returns all namespaces whose vars are visible in n without qualification
+func (n *Namespace) referredNamespaces() []*Namespace {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	nss := make([]*Namespace, 0, len(n.refers))
+	for _, ref := range n.refers {
+		if ref.all {
+			nss = append(nss, ref.ns)
+		}
+	}
+	return nss
+}
+
+// mapping returns a var referred by name with ReferVars
+func (n *Namespace) mapping(name Symbol) *Var {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.mappings[name]
+}
+
 func (n *Namespace) Lookup(symbol Symbol) Value {
 	sns, sym := symbol.Namespaced()
 	if sns == NIL {
-		v := n.registry[sym.(Symbol)]
+		v := n.lookupLocal(sym.(Symbol))
 		if v == nil {
-			for _, ref := range n.refers {
-				v = ref.ns.registry[sym.(Symbol)]
-				if v != nil {
+			v = n.mapping(sym.(Symbol))
+		}
+		if v == nil {
+			for _, ns := range n.referredNamespaces() {
+				v = ns.lookupLocal(sym.(Symbol))
+				if v != nil && !v.IsPrivate() {
 					return v
 				}
 			}
+			v = nil
 		}
 		if v == nil {
 			return NIL
 		}
 		return v
 	}
-	refer := n.refers[sns.(Symbol)]
-	if refer == nil {
+	var v *Var
+	if string(sns.(Symbol)) == n.name {
+		v = n.lookupLocal(sym.(Symbol))
+	} else {
+		refer := n.refer(sns.(Symbol))
+		if refer == nil {
+			return NIL
+		}
+		v = refer.ns.lookupLocal(sym.(Symbol))
+	}
+	if v == nil {
 		return NIL
 	}
-	return refer.ns.registry[sym.(Symbol)]
+	return v
 }
 
+// Refer makes vars of ns resolvable in n when qualified with alias or the name of ns,
+// all makes them resolvable without qualification too
 func (n *Namespace) Refer(ns *Namespace, alias string, all bool) {
 	nom := ns.Name()
 	if alias != "" {
 		nom = alias
 	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if old := n.refers[Symbol(nom)]; old != nil && old.ns == ns {
+		// referring again, e.g. with require after use, doesn't hide vars
+		all = all || old.all
+	}
 	n.refers[Symbol(nom)] = &Refer{
 		all: all,
 		ns:  ns,
 	}
 }
 
+// ReferVars makes vars named by syms in ns resolvable in n without qualification
+func (n *Namespace) ReferVars(ns *Namespace, syms []Symbol) error {
+	vars := make([]*Var, len(syms))
+	for i := range syms {
+		vars[i] = ns.lookupLocal(syms[i])
+		if vars[i] == nil {
+			return NewExecutionError(fmt.Sprintf("%s does not exist in %s", syms[i], ns.Name()))
+		}
+		if vars[i].IsPrivate() {
+			return NewExecutionError(fmt.Sprintf("%s is not public", vars[i]))
+		}
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for i := range syms {
+		n.mappings[syms[i]] = vars[i]
+	}
+	return nil
+}
+
+// Alias returns namespace referred in n by given alias or name, nil if there is none
+func (n *Namespace) Alias(name Symbol) *Namespace {
+	if string(name) == n.name {
+		return n
+	}
+	ref := n.refer(name)
+	if ref == nil {
+		return nil
+	}
+	return ref.ns
+}
+
 func (n *Namespace) Name() string {
 	return n.name
 }
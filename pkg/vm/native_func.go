@@ -36,27 +36,50 @@ func (t *theNativeFnType) Box(fn interface{}) (Value, error) {
 	}
 
 	variadric := ty.IsVariadic()
+	arity := ty.NumIn()
+	returnsError := ty.NumOut() > 0 && ty.Out(ty.NumOut()-1) == errorType
 
 	v := reflect.ValueOf(fn)
 
-	proxy := func(args []Value) Value {
-		rawArgs := make([]reflect.Value, len(args))
+	proxy := func(args []Value) (Value, error) {
+		argc := len(args)
+		if (!variadric && argc != arity) || (variadric && argc < arity-1) {
+			return NIL, NewExecutionError(fmt.Sprintf("wrong number of arguments (%d) passed to %s", argc, t.Name()))
+		}
+		rawArgs := make([]reflect.Value, argc)
 		for i := range args {
-			rawArgs[i] = reflect.ValueOf(args[i].Unbox())
+			var at reflect.Type
+			if variadric && i >= arity-1 {
+				at = ty.In(arity - 1).Elem()
+			} else {
+				at = ty.In(i)
+			}
+			ra, err := unboxArg(args[i], at)
+			if err != nil {
+				return NIL, NewExecutionError(fmt.Sprintf("argument %d", i)).Wrap(err)
+			}
+			rawArgs[i] = ra
 		}
 		res := v.Call(rawArgs)
+		if returnsError {
+			errv := res[len(res)-1]
+			if !errv.IsNil() {
+				return NIL, errv.Interface().(error)
+			}
+			res = res[:len(res)-1]
+		}
 		if len(res) == 0 {
-			return NIL
+			return NIL, nil
 		}
 		wv, err := BoxValue(res[0])
 		if err != nil {
-			return NIL
+			return NIL, NewExecutionError("boxing native fn return value").Wrap(err)
 		}
-		return wv
+		return wv, nil
 	}
 
 	f := &NativeFn{
-		arity:       ty.NumIn(),
+		arity:       arity,
 		isVariadric: variadric,
 		fn:          fn,
 		proxy:       proxy,
@@ -65,7 +88,7 @@ func (t *theNativeFnType) Box(fn interface{}) (Value, error) {
 	return f, nil
 }
 
-func (t *theNativeFnType) Wrap(fn func(args []Value) Value) (Value, error) {
+func (t *theNativeFnType) Wrap(fn func(args []Value) (Value, error)) (Value, error) {
 	f := &NativeFn{
 		arity:       -1,
 		isVariadric: false,
@@ -82,6 +105,33 @@ func (l *NativeFn) WithArity(arity int, variadric bool) *NativeFn {
 	return l
 }
 
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// unboxArg converts a Value to a reflect.Value suitable for passing to a Go function expecting typ
+func unboxArg(v Value, typ reflect.Type) (reflect.Value, error) {
+	raw := v.Unbox()
+	if raw == nil {
+		switch typ.Kind() {
+		case reflect.Interface, reflect.Ptr, reflect.Slice, reflect.Map, reflect.Func, reflect.Chan:
+			return reflect.Zero(typ), nil
+		}
+		return reflect.Value{}, NewTypeError(v, "can't be passed as", nil)
+	}
+	rv := reflect.ValueOf(raw)
+	if rv.Type().AssignableTo(typ) {
+		return rv, nil
+	}
+	if rv.Kind() == typ.Kind() && rv.Type().ConvertibleTo(typ) {
+		return rv.Convert(typ), nil
+	}
+	// some values can be passed as is, for example when the Go function expects a Value
+	vv := reflect.ValueOf(v)
+	if vv.Type().AssignableTo(typ) {
+		return vv, nil
+	}
+	return reflect.Value{}, NewTypeError(v, "can't be passed as "+typ.String(), nil)
+}
+
 var NativeFnType *theNativeFnType
 
 func init() {
@@ -92,7 +142,7 @@ type NativeFn struct {
 	arity       int
 	isVariadric bool
 	fn          interface{}
-	proxy       func([]Value) Value
+	proxy       func([]Value) (Value, error)
 }
 
 func (l *NativeFn) Type() ValueType { return NativeFnType }
@@ -106,7 +156,10 @@ func (l *NativeFn) Arity() int {
 	return l.arity
 }
 
-func (l *NativeFn) Invoke(args []Value) Value {
+func (l *NativeFn) Invoke(args []Value) (ret Value, err error) {
+	// errors raised while realizing lazy seqs leave ret untouched so it has to be set upfront
+	ret = NIL
+	defer recoverSeqError(&err)
 	return l.proxy(args)
 }
 
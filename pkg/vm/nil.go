@@ -63,3 +63,13 @@ func init() {
 func (n *Nil) String() string {
 	return "nil"
 }
+
+// Hash implements Hasher
+func (n *Nil) Hash() uint32 {
+	return 0
+}
+
+// Equals implements Hasher
+func (n *Nil) Equals(o Value) bool {
+	return o == NIL
+}
@@ -0,0 +1,298 @@
+/*
+ * Copyright (c) 2021 Marcin Gasperowicz <xnooga@gmail.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+ * documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit
+ * persons to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+ * Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+ * WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+ * OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package vm
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+)
+
+const (
+	maxInt = 1<<(strconv.IntSize-1) - 1
+	minInt = -1 << (strconv.IntSize - 1)
+)
+
+// numeric categories ordered by how contagious they are, mixing two numbers
+// yields a result in the larger category of the two
+const (
+	categoryInt = iota
+	categoryBigInt
+	categoryRatio
+	categoryFloat
+)
+
+func numberCategory(v Value) (int, bool) {
+	switch v.(type) {
+	case Int:
+		return categoryInt, true
+	case *BigInt:
+		return categoryBigInt, true
+	case *Ratio:
+		return categoryRatio, true
+	case Float:
+		return categoryFloat, true
+	}
+	return 0, false
+}
+
+// IsNumber checks if v is a number of any kind
+func IsNumber(v Value) bool {
+	_, ok := numberCategory(v)
+	return ok
+}
+
+func normalizeBigInt(n *big.Int) Value {
+	if n.IsInt64() && n.Int64() >= minInt && n.Int64() <= maxInt {
+		return Int(n.Int64())
+	}
+	return NewBigInt(n)
+}
+
+func toBigInt(v Value) *big.Int {
+	switch n := v.(type) {
+	case Int:
+		return big.NewInt(int64(n))
+	case *BigInt:
+		return n.v
+	}
+	return nil
+}
+
+func toRat(v Value) *big.Rat {
+	switch n := v.(type) {
+	case *Ratio:
+		return n.v
+	case Int, *BigInt:
+		return new(big.Rat).SetInt(toBigInt(v))
+	}
+	return nil
+}
+
+// ToFloat converts any number to a float64
+func ToFloat(v Value) (float64, bool) {
+	switch n := v.(type) {
+	case Int:
+		return float64(n), true
+	case Float:
+		return float64(n), true
+	case *BigInt:
+		f, _ := new(big.Float).SetInt(n.v).Float64()
+		return f, true
+	case *Ratio:
+		f, _ := n.v.Float64()
+		return f, true
+	}
+	return 0, false
+}
+
+// ToBigInt converts an integer or truncates any other number to a BigInt
+func ToBigInt(v Value) (*BigInt, bool) {
+	switch n := v.(type) {
+	case Int, *BigInt:
+		return NewBigInt(toBigInt(n)), true
+	case *Ratio:
+		return NewBigInt(new(big.Int).Quo(n.v.Num(), n.v.Denom())), true
+	case Float:
+		f := float64(n)
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return nil, false
+		}
+		b, _ := big.NewFloat(f).Int(nil)
+		return NewBigInt(b), true
+	}
+	return nil, false
+}
+
+type arithmetic struct {
+	name   string
+	ints   func(a, b int) (int, bool)
+	bigs   func(a, b *big.Int) *big.Int
+	rats   func(a, b *big.Rat) *big.Rat
+	floats func(a, b float64) float64
+}
+
+func (op *arithmetic) apply(a Value, b Value, promote bool) (Value, error) {
+	ca, ok := numberCategory(a)
+	if !ok {
+		return NIL, NewTypeError(a, "can't be used as a number in "+op.name, nil)
+	}
+	cb, ok := numberCategory(b)
+	if !ok {
+		return NIL, NewTypeError(b, "can't be used as a number in "+op.name, nil)
+	}
+	if cb > ca {
+		ca = cb
+	}
+	switch ca {
+	case categoryInt:
+		r, ok := op.ints(int(a.(Int)), int(b.(Int)))
+		if ok {
+			return Int(r), nil
+		}
+		if !promote {
+			return NIL, NewExecutionError("integer overflow in " + op.name)
+		}
+		return NewBigInt(op.bigs(toBigInt(a), toBigInt(b))), nil
+	case categoryBigInt:
+		return NewBigInt(op.bigs(toBigInt(a), toBigInt(b))), nil
+	case categoryRatio:
+		return NewRatio(op.rats(toRat(a), toRat(b))), nil
+	}
+	fa, _ := ToFloat(a)
+	fb, _ := ToFloat(b)
+	return Float(op.floats(fa, fb)), nil
+}
+
+var addition = &arithmetic{
+	name: "+",
+	ints: func(a, b int) (int, bool) {
+		r := a + b
+		return r, (a >= 0) != (b >= 0) || (r >= 0) == (a >= 0)
+	},
+	bigs:   func(a, b *big.Int) *big.Int { return new(big.Int).Add(a, b) },
+	rats:   func(a, b *big.Rat) *big.Rat { return new(big.Rat).Add(a, b) },
+	floats: func(a, b float64) float64 { return a + b },
+}
+
+var subtraction = &arithmetic{
+	name: "-",
+	ints: func(a, b int) (int, bool) {
+		r := a - b
+		return r, (a >= 0) == (b >= 0) || (r >= 0) == (a >= 0)
+	},
+	bigs:   func(a, b *big.Int) *big.Int { return new(big.Int).Sub(a, b) },
+	rats:   func(a, b *big.Rat) *big.Rat { return new(big.Rat).Sub(a, b) },
+	floats: func(a, b float64) float64 { return a - b },
+}
+
+var multiplication = &arithmetic{
+	name: "*",
+	ints: func(a, b int) (int, bool) {
+		if a == 0 || b == 0 {
+			return 0, true
+		}
+		r := a * b
+		return r, r/b == a && !(a == -1 && b == minInt) && !(b == -1 && a == minInt)
+	},
+	bigs:   func(a, b *big.Int) *big.Int { return new(big.Int).Mul(a, b) },
+	rats:   func(a, b *big.Rat) *big.Rat { return new(big.Rat).Mul(a, b) },
+	floats: func(a, b float64) float64 { return a * b },
+}
+
+// Add adds two numbers, Int overflow is an error
+func Add(a Value, b Value) (Value, error) {
+	return addition.apply(a, b, false)
+}
+
+// AddP adds two numbers promoting to BigInt on Int overflow
+func AddP(a Value, b Value) (Value, error) {
+	return addition.apply(a, b, true)
+}
+
+// Sub subtracts b from a, Int overflow is an error
+func Sub(a Value, b Value) (Value, error) {
+	return subtraction.apply(a, b, false)
+}
+
+// SubP subtracts b from a promoting to BigInt on Int overflow
+func SubP(a Value, b Value) (Value, error) {
+	return subtraction.apply(a, b, true)
+}
+
+// Mul multiplies two numbers, Int overflow is an error
+func Mul(a Value, b Value) (Value, error) {
+	return multiplication.apply(a, b, false)
+}
+
+// MulP multiplies two numbers promoting to BigInt on Int overflow
+func MulP(a Value, b Value) (Value, error) {
+	return multiplication.apply(a, b, true)
+}
+
+// Div divides a by b, dividing integers yields a Ratio unless the result is whole.
+// Division of exact numbers by zero is an error while floats follow IEEE 754.
+func Div(a Value, b Value) (Value, error) {
+	ca, ok := numberCategory(a)
+	if !ok {
+		return NIL, NewTypeError(a, "can't be used as a number in /", nil)
+	}
+	cb, ok := numberCategory(b)
+	if !ok {
+		return NIL, NewTypeError(b, "can't be used as a number in /", nil)
+	}
+	if cb > ca {
+		ca = cb
+	}
+	if ca == categoryFloat {
+		fa, _ := ToFloat(a)
+		fb, _ := ToFloat(b)
+		return Float(fa / fb), nil
+	}
+	rb := toRat(b)
+	if rb.Sign() == 0 {
+		return NIL, NewExecutionError("divide by zero")
+	}
+	ret := NewRatio(new(big.Rat).Quo(toRat(a), rb))
+	if n, ok := ret.(Int); ok && ca == categoryBigInt {
+		return NewBigInt(big.NewInt(int64(n))), nil
+	}
+	return ret, nil
+}
+
+// Compare compares two numbers of any kind returning -1, 0 or 1.
+// ok is false if the numbers are unordered which happens when one of them is NaN.
+func Compare(a Value, b Value) (c int, ok bool, err error) {
+	ca, isNum := numberCategory(a)
+	if !isNum {
+		return 0, false, NewTypeError(a, "can't be compared as a number", nil)
+	}
+	cb, isNum := numberCategory(b)
+	if !isNum {
+		return 0, false, NewTypeError(b, "can't be compared as a number", nil)
+	}
+	if cb > ca {
+		ca = cb
+	}
+	switch ca {
+	case categoryInt:
+		x, y := a.(Int), b.(Int)
+		switch {
+		case x < y:
+			return -1, true, nil
+		case x > y:
+			return 1, true, nil
+		}
+		return 0, true, nil
+	case categoryBigInt:
+		return toBigInt(a).Cmp(toBigInt(b)), true, nil
+	case categoryRatio:
+		return toRat(a).Cmp(toRat(b)), true, nil
+	}
+	fa, _ := ToFloat(a)
+	fb, _ := ToFloat(b)
+	switch {
+	case math.IsNaN(fa) || math.IsNaN(fb):
+		return 0, false, nil
+	case fa < fb:
+		return -1, true, nil
+	case fa > fb:
+		return 1, true, nil
+	}
+	return 0, true, nil
+}
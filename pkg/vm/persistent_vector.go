@@ -0,0 +1,518 @@
+/*
+ * Copyright (c) 2021 Marcin Gasperowicz <xnooga@gmail.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+ * documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit
+ * persons to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+ * Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+ * WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+ * OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package vm
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+type thePersistentVectorType struct{}
+
+func (t *thePersistentVectorType) String() string     { return t.Name() }
+func (t *thePersistentVectorType) Type() ValueType    { return TypeType }
+func (t *thePersistentVectorType) Unbox() interface{} { return reflect.TypeOf(t) }
+
+func (t *thePersistentVectorType) Name() string { return "let-go.lang.PersistentVector" }
+
+func (t *thePersistentVectorType) Box(bare interface{}) (Value, error) {
+	arr, ok := bare.([]Value)
+	if !ok {
+		return NIL, NewTypeError(bare, "can't be boxed as", t)
+	}
+	return NewPersistentVector(arr), nil
+}
+
+// PersistentVectorType is the type of PersistentVectors
+var PersistentVectorType *thePersistentVectorType
+
+// EmptyPersistentVector is an empty PersistentVector
+var EmptyPersistentVector *PersistentVector
+
+func init() {
+	PersistentVectorType = &thePersistentVectorType{}
+	EmptyPersistentVector = &PersistentVector{shift: vectorBits, root: &vectorNode{}}
+}
+
+const (
+	vectorBits  = 5
+	vectorWidth = 1 << vectorBits
+	vectorMask  = vectorWidth - 1
+)
+
+// vectorNode is a node of the vector trie, inner nodes hold children and leaves hold values
+type vectorNode struct {
+	children []*vectorNode
+	values   []Value
+}
+
+// PersistentVector is an immutable vector implemented as a 32-way trie with the last
+// (up to 32) elements kept in a separate tail. Updates copy only the path to the
+// changed leaf, so conj is O(1) amortized and lookups and assoc are O(log32 n).
+type PersistentVector struct {
+	count int
+	shift uint
+	root  *vectorNode
+	tail  []Value
+	meta  *Map
+}
+
+// NewPersistentVector creates a PersistentVector holding vs
+func NewPersistentVector(vs []Value) *PersistentVector {
+	ret := EmptyPersistentVector
+	for i := range vs {
+		ret = ret.Conj(vs[i])
+	}
+	return ret
+}
+
+// Type implements Value
+func (v *PersistentVector) Type() ValueType { return PersistentVectorType }
+
+// Unbox implements Value
+func (v *PersistentVector) Unbox() interface{} {
+	return v.values()
+}
+
+func (v *PersistentVector) values() []Value {
+	ret := make([]Value, 0, v.count)
+	for i := 0; i < v.count; i += vectorWidth {
+		ret = append(ret, v.leafFor(i)...)
+	}
+	return ret
+}
+
+func (v *PersistentVector) tailOffset() int {
+	if v.count < vectorWidth {
+		return 0
+	}
+	return ((v.count - 1) >> vectorBits) << vectorBits
+}
+
+// leafFor returns the leaf holding the element at index i
+func (v *PersistentVector) leafFor(i int) []Value {
+	if i >= v.tailOffset() {
+		return v.tail
+	}
+	node := v.root
+	for level := v.shift; level > 0; level -= vectorBits {
+		node = node.children[(i>>level)&vectorMask]
+	}
+	return node.values
+}
+
+// Nth returns the element at index i
+func (v *PersistentVector) Nth(i int) (Value, bool) {
+	if i < 0 || i >= v.count {
+		return NIL, false
+	}
+	return v.leafFor(i)[i&vectorMask], true
+}
+
+// Conj returns a new vector with val appended
+func (v *PersistentVector) Conj(val Value) *PersistentVector {
+	if v.count-v.tailOffset() < vectorWidth {
+		tail := make([]Value, len(v.tail)+1)
+		copy(tail, v.tail)
+		tail[len(v.tail)] = val
+		return &PersistentVector{count: v.count + 1, shift: v.shift, root: v.root, tail: tail, meta: v.meta}
+	}
+	leaf := &vectorNode{values: v.tail}
+	shift := v.shift
+	var root *vectorNode
+	if (v.count >> vectorBits) > (1 << v.shift) {
+		root = &vectorNode{children: []*vectorNode{v.root, newVectorPath(v.shift, leaf)}}
+		shift += vectorBits
+	} else {
+		root = v.pushTail(v.shift, v.root, leaf)
+	}
+	return &PersistentVector{count: v.count + 1, shift: shift, root: root, tail: []Value{val}, meta: v.meta}
+}
+
+func (v *PersistentVector) pushTail(level uint, parent *vectorNode, leaf *vectorNode) *vectorNode {
+	idx := ((v.count - 1) >> level) & vectorMask
+	var child *vectorNode
+	if level == vectorBits {
+		child = leaf
+	} else if idx < len(parent.children) {
+		child = v.pushTail(level-vectorBits, parent.children[idx], leaf)
+	} else {
+		child = newVectorPath(level-vectorBits, leaf)
+	}
+	ret := &vectorNode{children: make([]*vectorNode, len(parent.children), idx+1)}
+	copy(ret.children, parent.children)
+	if idx < len(ret.children) {
+		ret.children[idx] = child
+	} else {
+		ret.children = append(ret.children, child)
+	}
+	return ret
+}
+
+func newVectorPath(level uint, node *vectorNode) *vectorNode {
+	if level == 0 {
+		return node
+	}
+	return &vectorNode{children: []*vectorNode{newVectorPath(level-vectorBits, node)}}
+}
+
+// AssocN returns a new vector with the element at index i replaced by val,
+// i may be equal to the count of the vector in which case val is appended
+func (v *PersistentVector) AssocN(i int, val Value) (*PersistentVector, error) {
+	if i == v.count {
+		return v.Conj(val), nil
+	}
+	if i < 0 || i > v.count {
+		return nil, NewExecutionError(fmt.Sprintf("index %d out of bounds for vector of length %d", i, v.count))
+	}
+	if i >= v.tailOffset() {
+		tail := make([]Value, len(v.tail))
+		copy(tail, v.tail)
+		tail[i&vectorMask] = val
+		return &PersistentVector{count: v.count, shift: v.shift, root: v.root, tail: tail, meta: v.meta}, nil
+	}
+	return &PersistentVector{count: v.count, shift: v.shift, root: assocVectorNode(v.shift, v.root, i, val), tail: v.tail, meta: v.meta}, nil
+}
+
+func assocVectorNode(level uint, node *vectorNode, i int, val Value) *vectorNode {
+	if level == 0 {
+		values := make([]Value, len(node.values))
+		copy(values, node.values)
+		values[i&vectorMask] = val
+		return &vectorNode{values: values}
+	}
+	children := make([]*vectorNode, len(node.children))
+	copy(children, node.children)
+	idx := (i >> level) & vectorMask
+	children[idx] = assocVectorNode(level-vectorBits, node.children[idx], i, val)
+	return &vectorNode{children: children}
+}
+
+// Pop returns a new vector without the last element
+func (v *PersistentVector) Pop() (*PersistentVector, error) {
+	switch {
+	case v.count == 0:
+		return nil, NewExecutionError("can't pop empty vector")
+	case v.count == 1:
+		return EmptyPersistentVector.WithMeta(v.meta).(*PersistentVector), nil
+	case v.count-v.tailOffset() > 1:
+		return &PersistentVector{count: v.count - 1, shift: v.shift, root: v.root, tail: v.tail[:len(v.tail)-1], meta: v.meta}, nil
+	}
+	tail := v.leafFor(v.count - 2)
+	root := v.popTail(v.shift, v.root)
+	shift := v.shift
+	if root == nil {
+		root = EmptyPersistentVector.root
+	}
+	if shift > vectorBits && len(root.children) == 1 {
+		root = root.children[0]
+		shift -= vectorBits
+	}
+	return &PersistentVector{count: v.count - 1, shift: shift, root: root, tail: tail, meta: v.meta}, nil
+}
+
+func (v *PersistentVector) popTail(level uint, node *vectorNode) *vectorNode {
+	idx := ((v.count - 2) >> level) & vectorMask
+	if level > vectorBits {
+		child := v.popTail(level-vectorBits, node.children[idx])
+		if child == nil && idx == 0 {
+			return nil
+		}
+		children := make([]*vectorNode, idx, idx+1)
+		copy(children, node.children)
+		if child != nil {
+			children = append(children, child)
+		}
+		return &vectorNode{children: children}
+	}
+	if idx == 0 {
+		return nil
+	}
+	children := make([]*vectorNode, idx)
+	copy(children, node.children)
+	return &vectorNode{children: children}
+}
+
+// Peek returns the last element of the vector or nil if it's empty
+func (v *PersistentVector) Peek() Value {
+	if v.count == 0 {
+		return NIL
+	}
+	return v.tail[len(v.tail)-1]
+}
+
+// First implements Seq
+func (v *PersistentVector) First() Value {
+	ret, _ := v.Nth(0)
+	return ret
+}
+
+// More implements Seq
+func (v *PersistentVector) More() Seq {
+	if v.count <= 1 {
+		return EmptyList
+	}
+	return &vectorSeq{vec: v, i: 1}
+}
+
+// Next implements Seq
+func (v *PersistentVector) Next() Seq {
+	return v.More()
+}
+
+// Seq returns a seq over the elements of the vector
+func (v *PersistentVector) Seq() Seq {
+	if v.count == 0 {
+		return EmptyList
+	}
+	return &vectorSeq{vec: v}
+}
+
+// Cons implements Seq, it returns a list with val in front of the vector elements
+func (v *PersistentVector) Cons(val Value) Seq {
+	l, _ := ListType.Box(v.values())
+	return l.(*List).Cons(val)
+}
+
+// Count implements Collection
+func (v *PersistentVector) Count() Value {
+	return Int(v.count)
+}
+
+// RawCount implements Collection
+func (v *PersistentVector) RawCount() int {
+	return v.count
+}
+
+// Empty implements Collection
+func (v *PersistentVector) Empty() Collection {
+	return EmptyPersistentVector.WithMeta(v.meta).(Collection)
+}
+
+// Meta implements IMeta
+func (v *PersistentVector) Meta() *Map {
+	return v.meta
+}
+
+// WithMeta implements IObj
+func (v *PersistentVector) WithMeta(meta *Map) IObj {
+	if meta == v.meta {
+		return v
+	}
+	ret := *v
+	ret.meta = meta
+	return &ret
+}
+
+// Assoc implements Associative, keys which are not valid indices leave the vector unchanged
+func (v *PersistentVector) Assoc(k Value, val Value) Associative {
+	i, ok := k.(Int)
+	if !ok {
+		return v
+	}
+	ret, err := v.AssocN(int(i), val)
+	if err != nil {
+		return v
+	}
+	return ret
+}
+
+// Dissoc implements Associative, vectors don't support removing elements by index so the vector is returned unchanged
+func (v *PersistentVector) Dissoc(k Value) Associative {
+	return v
+}
+
+// Contains implements Keyed, vectors are keyed by indices
+func (v *PersistentVector) Contains(k Value) bool {
+	i, ok := k.(Int)
+	return ok && i >= 0 && int(i) < v.count
+}
+
+// ValueAt implements Lookup
+func (v *PersistentVector) ValueAt(k Value) Value {
+	return v.ValueAtOr(k, NIL)
+}
+
+// ValueAtOr implements Lookup
+func (v *PersistentVector) ValueAtOr(k Value, dflt Value) Value {
+	i, ok := k.(Int)
+	if !ok {
+		return dflt
+	}
+	ret, ok := v.Nth(int(i))
+	if !ok {
+		return dflt
+	}
+	return ret
+}
+
+// Arity implements Fn
+func (v *PersistentVector) Arity() int {
+	return 1
+}
+
+// Invoke implements Fn, vectors return the element at given index
+func (v *PersistentVector) Invoke(pargs []Value) (Value, error) {
+	if len(pargs) != 1 {
+		return NIL, NewExecutionError(fmt.Sprintf("wrong number of arguments (%d) passed to a vector", len(pargs)))
+	}
+	i, ok := pargs[0].(Int)
+	if !ok {
+		return NIL, NewTypeError(pargs[0], "is not a valid index, expected", IntType)
+	}
+	ret, ok := v.Nth(int(i))
+	if !ok {
+		return NIL, NewExecutionError(fmt.Sprintf("index %d out of bounds for vector of length %d", i, v.count))
+	}
+	return ret, nil
+}
+
+func (v *PersistentVector) String() string {
+	b := &strings.Builder{}
+	b.WriteRune('[')
+	for i := 0; i < v.count; i++ {
+		if i > 0 {
+			b.WriteRune(' ')
+		}
+		e, _ := v.Nth(i)
+		b.WriteString(e.String())
+	}
+	b.WriteRune(']')
+	return b.String()
+}
+
+func (v *PersistentVector) sequentialValues() []Value {
+	return v.values()
+}
+
+// Hash implements Hasher
+func (v *PersistentVector) Hash() uint32 {
+	return hashSequential(v.values())
+}
+
+// Equals implements Hasher, vectors are equal to other sequential collections with equal elements
+func (v *PersistentVector) Equals(o Value) bool {
+	return equalsSequential(v.values(), o)
+}
+
+type theVectorSeqType struct{}
+
+func (t *theVectorSeqType) String() string     { return t.Name() }
+func (t *theVectorSeqType) Type() ValueType    { return TypeType }
+func (t *theVectorSeqType) Unbox() interface{} { return reflect.TypeOf(t) }
+
+func (t *theVectorSeqType) Name() string { return "let-go.lang.VectorSeq" }
+
+func (t *theVectorSeqType) Box(bare interface{}) (Value, error) {
+	return NIL, NewTypeError(bare, "can't be boxed as", t)
+}
+
+// VectorSeqType is the type of seqs over PersistentVectors
+var VectorSeqType *theVectorSeqType
+
+func init() {
+	VectorSeqType = &theVectorSeqType{}
+}
+
+// vectorSeq walks a PersistentVector starting at index i without copying it
+type vectorSeq struct {
+	vec *PersistentVector
+	i   int
+}
+
+// Type implements Value
+func (s *vectorSeq) Type() ValueType { return VectorSeqType }
+
+// Unbox implements Value
+func (s *vectorSeq) Unbox() interface{} {
+	return s.sequentialValues()
+}
+
+// First implements Seq
+func (s *vectorSeq) First() Value {
+	ret, _ := s.vec.Nth(s.i)
+	return ret
+}
+
+// More implements Seq
+func (s *vectorSeq) More() Seq {
+	if s.i+1 >= s.vec.count {
+		return EmptyList
+	}
+	return &vectorSeq{vec: s.vec, i: s.i + 1}
+}
+
+// Next implements Seq
+func (s *vectorSeq) Next() Seq {
+	return s.More()
+}
+
+// ChunkFirst implements Chunked
+func (s *vectorSeq) ChunkFirst() []Value {
+	return s.vec.leafFor(s.i)[s.i&vectorMask:]
+}
+
+// ChunkMore implements Chunked
+func (s *vectorSeq) ChunkMore() Seq {
+	next := s.i + len(s.ChunkFirst())
+	if next >= s.vec.count {
+		return EmptyList
+	}
+	return &vectorSeq{vec: s.vec, i: next}
+}
+
+// Cons implements Seq
+func (s *vectorSeq) Cons(val Value) Seq {
+	l, _ := ListType.Box(s.sequentialValues())
+	return l.(*List).Cons(val)
+}
+
+// Count implements Collection
+func (s *vectorSeq) Count() Value {
+	return Int(s.RawCount())
+}
+
+// RawCount implements Collection
+func (s *vectorSeq) RawCount() int {
+	return s.vec.count - s.i
+}
+
+// Empty implements Collection
+func (s *vectorSeq) Empty() Collection {
+	return EmptyList
+}
+
+func (s *vectorSeq) String() string {
+	l, _ := ListType.Box(s.sequentialValues())
+	return l.String()
+}
+
+func (s *vectorSeq) sequentialValues() []Value {
+	return s.vec.values()[s.i:]
+}
+
+// Hash implements Hasher
+func (s *vectorSeq) Hash() uint32 {
+	return hashSequential(s.sequentialValues())
+}
+
+// Equals implements Hasher
+func (s *vectorSeq) Equals(o Value) bool {
+	return equalsSequential(s.sequentialValues(), o)
+}
@@ -0,0 +1,201 @@
+/*
+ * Copyright (c) 2021 Marcin Gasperowicz <xnooga@gmail.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+ * documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit
+ * persons to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+ * Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+ * WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+ * OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package vm
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+type theProtocolType struct{}
+
+func (t *theProtocolType) String() string     { return t.Name() }
+func (t *theProtocolType) Type() ValueType    { return TypeType }
+func (t *theProtocolType) Unbox() interface{} { return reflect.TypeOf(t) }
+
+func (t *theProtocolType) Name() string { return "let-go.lang.Protocol" }
+func (t *theProtocolType) Box(b interface{}) (Value, error) {
+	return NIL, NewTypeError(b, "can't be boxed as", t)
+}
+
+type theProtocolFnType struct{}
+
+func (t *theProtocolFnType) String() string     { return t.Name() }
+func (t *theProtocolFnType) Type() ValueType    { return TypeType }
+func (t *theProtocolFnType) Unbox() interface{} { return reflect.TypeOf(t) }
+
+func (t *theProtocolFnType) Name() string { return "let-go.lang.ProtocolFn" }
+func (t *theProtocolFnType) Box(b interface{}) (Value, error) {
+	return NIL, NewTypeError(b, "can't be boxed as", t)
+}
+
+// ProtocolType is the type of Protocols
+var ProtocolType *theProtocolType
+
+// ProtocolFnType is the type of protocol methods
+var ProtocolFnType *theProtocolFnType
+
+func init() {
+	ProtocolType = &theProtocolType{}
+	ProtocolFnType = &theProtocolFnType{}
+}
+
+// Protocol is a named set of methods which can be implemented for any ValueType, including boxed Go types.
+// Implementations can be added at any time and from many goroutines.
+type Protocol struct {
+	name    string
+	methods []Symbol
+	mu      sync.RWMutex
+	impls   map[ValueType]map[Symbol]Fn
+}
+
+// NewProtocol creates a protocol declaring methods
+func NewProtocol(name string, methods []Symbol) *Protocol {
+	return &Protocol{
+		name:    name,
+		methods: methods,
+		impls:   map[ValueType]map[Symbol]Fn{},
+	}
+}
+
+// Type implements Value
+func (p *Protocol) Type() ValueType { return ProtocolType }
+
+// Unbox implements Value
+func (p *Protocol) Unbox() interface{} { return p }
+
+func (p *Protocol) String() string {
+	return fmt.Sprintf("<protocol %s>", p.name)
+}
+
+// Name returns the name of p
+func (p *Protocol) Name() string {
+	return p.name
+}
+
+// Methods returns names of methods declared by p
+func (p *Protocol) Methods() []Symbol {
+	return p.methods
+}
+
+func (p *Protocol) declares(name Symbol) bool {
+	for _, m := range p.methods {
+		if m == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Method returns a function dispatching calls to method name on the type of its first argument
+func (p *Protocol) Method(name Symbol) (*ProtocolFn, error) {
+	if !p.declares(name) {
+		return nil, NewExecutionError(fmt.Sprintf("protocol %s has no method %s", p.name, name))
+	}
+	return &ProtocolFn{protocol: p, name: name}, nil
+}
+
+// Extend implements methods of p for type t, methods which were implemented before are kept
+func (p *Protocol) Extend(t ValueType, impls map[Symbol]Fn) error {
+	for name := range impls {
+		if !p.declares(name) {
+			return NewExecutionError(fmt.Sprintf("protocol %s has no method %s", p.name, name))
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	methods, ok := p.impls[t]
+	if !ok {
+		methods = map[Symbol]Fn{}
+		p.impls[t] = methods
+	}
+	for name, f := range impls {
+		methods[name] = f
+	}
+	return nil
+}
+
+// Extends checks if p was extended to type t
+func (p *Protocol) Extends(t ValueType) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	_, ok := p.impls[t]
+	return ok
+}
+
+// Satisfies checks if p was extended to the type of v
+func (p *Protocol) Satisfies(v Value) bool {
+	return p.Extends(v.Type())
+}
+
+// Extenders returns types p was extended to, sorted by name
+func (p *Protocol) Extenders() []ValueType {
+	p.mu.RLock()
+	ret := make([]ValueType, 0, len(p.impls))
+	for t := range p.impls {
+		ret = append(ret, t)
+	}
+	p.mu.RUnlock()
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name() < ret[j].Name() })
+	return ret
+}
+
+// Impl returns the implementation of method name for type t
+func (p *Protocol) Impl(t ValueType, name Symbol) (Fn, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	f, ok := p.impls[t][name]
+	return f, ok
+}
+
+// ProtocolFn is a protocol method, it dispatches on the type of its first argument
+type ProtocolFn struct {
+	protocol *Protocol
+	name     Symbol
+}
+
+// Type implements Value
+func (f *ProtocolFn) Type() ValueType { return ProtocolFnType }
+
+// Unbox implements Value
+func (f *ProtocolFn) Unbox() interface{} { return f }
+
+func (f *ProtocolFn) String() string {
+	return fmt.Sprintf("<protocol-fn %s/%s>", f.protocol.name, f.name)
+}
+
+// Arity implements Fn, protocol methods accept any number of arguments as arities are up to implementations
+func (f *ProtocolFn) Arity() int {
+	return -1
+}
+
+// Invoke implements Fn
+func (f *ProtocolFn) Invoke(args []Value) (Value, error) {
+	if len(args) == 0 {
+		return NIL, NewExecutionError(fmt.Sprintf("protocol method %s needs at least one argument", f.name))
+	}
+	t := args[0].Type()
+	impl, ok := f.protocol.Impl(t, f.name)
+	if !ok {
+		return NIL, NewExecutionError(fmt.Sprintf("no implementation of method %s of protocol %s found for %s",
+			f.name, f.protocol.name, t.Name()))
+	}
+	return impl.Invoke(args)
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright (c) 2021 Marcin Gasperowicz <xnooga@gmail.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+ * documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit
+ * persons to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+ * Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+ * WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+ * OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package vm
+
+import (
+	"math/big"
+	"reflect"
+)
+
+type theRatioType struct{}
+
+func (t *theRatioType) String() string     { return t.Name() }
+func (t *theRatioType) Type() ValueType    { return TypeType }
+func (t *theRatioType) Unbox() interface{} { return reflect.TypeOf(t) }
+
+func (t *theRatioType) Name() string { return "let-go.lang.Ratio" }
+
+func (t *theRatioType) Box(bare interface{}) (Value, error) {
+	raw, ok := bare.(*big.Rat)
+	if !ok {
+		return NIL, NewTypeError(bare, "can't be boxed as", t)
+	}
+	return NewRatio(raw), nil
+}
+
+// RatioType is the type of Ratios
+var RatioType *theRatioType
+
+func init() {
+	RatioType = &theRatioType{}
+}
+
+// Ratio is an exact fraction, ratios with denominator of 1 are always turned into integers
+type Ratio struct {
+	v *big.Rat
+}
+
+// NewRatio boxes r, it returns an Int or a BigInt if r is a whole number.
+// r must not be modified afterwards.
+func NewRatio(r *big.Rat) Value {
+	if r.IsInt() {
+		return normalizeBigInt(r.Num())
+	}
+	return &Ratio{v: r}
+}
+
+// Type implements Value
+func (l *Ratio) Type() ValueType { return RatioType }
+
+// Unbox implements Value, it returns a copy of the underlying *big.Rat
+func (l *Ratio) Unbox() interface{} {
+	return new(big.Rat).Set(l.v)
+}
+
+func (l *Ratio) String() string {
+	return l.v.String()
+}
+
+// Numerator returns the numerator of the ratio
+func (l *Ratio) Numerator() Value {
+	return normalizeBigInt(l.v.Num())
+}
+
+// Denominator returns the denominator of the ratio
+func (l *Ratio) Denominator() Value {
+	return normalizeBigInt(l.v.Denom())
+}
+
+// Hash implements Hasher
+func (l *Ratio) Hash() uint32 {
+	return hashString(l.v.String())
+}
+
+// Equals implements Hasher
+func (l *Ratio) Equals(o Value) bool {
+	r, ok := o.(*Ratio)
+	return ok && l.v.Cmp(r.v) == 0
+}
@@ -0,0 +1,278 @@
+/*
+ * Copyright (c) 2021 Marcin Gasperowicz <xnooga@gmail.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+ * documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit
+ * persons to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+ * Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+ * WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+ * OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package vm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RecordType is a ValueType created at runtime by defrecord or deftype
+type RecordType struct {
+	name     string
+	fields   []Symbol
+	isRecord bool
+}
+
+// NewRecordType creates a type with named fields, values of record types behave as maps
+func NewRecordType(name string, fields []Symbol, isRecord bool) *RecordType {
+	return &RecordType{name: name, fields: fields, isRecord: isRecord}
+}
+
+func (t *RecordType) String() string     { return t.Name() }
+func (t *RecordType) Type() ValueType    { return TypeType }
+func (t *RecordType) Unbox() interface{} { return t }
+
+func (t *RecordType) Name() string { return t.name }
+func (t *RecordType) Box(b interface{}) (Value, error) {
+	return NIL, NewTypeError(b, "can't be boxed as", t)
+}
+
+// Fields returns names of fields of t
+func (t *RecordType) Fields() []Symbol {
+	return t.fields
+}
+
+// IsRecord checks if t was created by defrecord
+func (t *RecordType) IsRecord() bool {
+	return t.isRecord
+}
+
+// New creates a value of type t with given field values
+func (t *RecordType) New(vals []Value) (Value, error) {
+	if len(vals) != len(t.fields) {
+		return NIL, NewExecutionError(fmt.Sprintf("wrong number of fields (%d) passed to %s", len(vals), t.name))
+	}
+	fields := make([]Value, len(vals))
+	copy(fields, vals)
+	if t.isRecord {
+		return &Record{typ: t, fields: fields, ext: EmptyMap}, nil
+	}
+	return &Instance{typ: t, fields: fields}, nil
+}
+
+// FromMap creates a record of type t from a map, keys which are not fields of t are kept as extra entries
+func (t *RecordType) FromMap(m *Map) (*Record, error) {
+	if !t.isRecord {
+		return nil, NewExecutionError(t.name + " is not a record type")
+	}
+	r := &Record{typ: t, fields: make([]Value, len(t.fields)), ext: EmptyMap}
+	for i := range t.fields {
+		r.fields[i] = m.ValueAt(Keyword(t.fields[i]))
+	}
+	m.Each(func(k Value, v Value) {
+		if t.fieldIndex(k) < 0 {
+			r.ext = r.ext.Assoc(k, v).(*Map)
+		}
+	})
+	return r, nil
+}
+
+func (t *RecordType) fieldIndex(key Value) int {
+	k, ok := key.(Keyword)
+	if !ok {
+		return -1
+	}
+	for i := range t.fields {
+		if string(t.fields[i]) == string(k) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (t *RecordType) field(fields []Value, name Symbol) (Value, error) {
+	i := t.fieldIndex(Keyword(strings.TrimPrefix(string(name), "-")))
+	if i < 0 {
+		return NIL, NewExecutionError(fmt.Sprintf("%s has no field %s", t.name, name))
+	}
+	return fields[i], nil
+}
+
+// Record is a value of a type created by defrecord, it behaves as a map with fixed keys
+type Record struct {
+	typ    *RecordType
+	fields []Value
+	ext    *Map
+}
+
+// Type implements Value
+func (r *Record) Type() ValueType { return r.typ }
+
+// Unbox implements Value
+func (r *Record) Unbox() interface{} { return r }
+
+// ToMap returns a map holding all entries of r
+func (r *Record) ToMap() *Map {
+	var m Associative = r.ext
+	for i := range r.fields {
+		m = m.Assoc(Keyword(r.typ.fields[i]), r.fields[i])
+	}
+	return m.(*Map)
+}
+
+func (r *Record) String() string {
+	b := &strings.Builder{}
+	b.WriteRune('#')
+	b.WriteString(r.typ.name)
+	b.WriteRune('{')
+	for i := range r.fields {
+		if i > 0 {
+			b.WriteRune(' ')
+		}
+		b.WriteString(Keyword(r.typ.fields[i]).String())
+		b.WriteRune(' ')
+		b.WriteString(r.fields[i].String())
+	}
+	r.ext.Each(func(k Value, v Value) {
+		b.WriteRune(' ')
+		b.WriteString(k.String())
+		b.WriteRune(' ')
+		b.WriteString(v.String())
+	})
+	b.WriteRune('}')
+	return b.String()
+}
+
+// ValueAt implements Lookup
+func (r *Record) ValueAt(key Value) Value {
+	return r.ValueAtOr(key, NIL)
+}
+
+// ValueAtOr implements Lookup
+func (r *Record) ValueAtOr(key Value, dflt Value) Value {
+	if i := r.typ.fieldIndex(key); i >= 0 {
+		return r.fields[i]
+	}
+	return r.ext.ValueAtOr(key, dflt)
+}
+
+// Contains implements Keyed
+func (r *Record) Contains(key Value) bool {
+	return r.typ.fieldIndex(key) >= 0 || r.ext.Contains(key)
+}
+
+// Assoc implements Associative
+func (r *Record) Assoc(key Value, val Value) Associative {
+	ret := &Record{typ: r.typ, fields: r.fields, ext: r.ext}
+	if i := r.typ.fieldIndex(key); i >= 0 {
+		ret.fields = make([]Value, len(r.fields))
+		copy(ret.fields, r.fields)
+		ret.fields[i] = val
+		return ret
+	}
+	ret.ext = r.ext.Assoc(key, val).(*Map)
+	return ret
+}
+
+// Dissoc implements Associative, removing a field turns the record into a plain map
+func (r *Record) Dissoc(key Value) Associative {
+	if r.typ.fieldIndex(key) >= 0 {
+		return r.ToMap().Dissoc(key)
+	}
+	return &Record{typ: r.typ, fields: r.fields, ext: r.ext.Dissoc(key).(*Map)}
+}
+
+// Count implements Collection
+func (r *Record) Count() Value {
+	return Int(r.RawCount())
+}
+
+// RawCount implements Collection
+func (r *Record) RawCount() int {
+	return len(r.fields) + r.ext.RawCount()
+}
+
+// Empty implements Collection, records can't be empty so an empty map is returned
+func (r *Record) Empty() Collection {
+	return EmptyMap
+}
+
+// First implements Seq
+func (r *Record) First() Value {
+	return r.ToMap().First()
+}
+
+// More implements Seq
+func (r *Record) More() Seq {
+	return r.ToMap().More()
+}
+
+// Next implements Seq
+func (r *Record) Next() Seq {
+	return r.ToMap().Next()
+}
+
+// Cons implements Seq, conjoining a map entry assocs it
+func (r *Record) Cons(val Value) Seq {
+	if entry, ok := val.(Seq); ok {
+		return r.Assoc(entry.First(), entry.Next().First()).(Seq)
+	}
+	return r.ToMap().Cons(val)
+}
+
+// Hash implements Hasher
+func (r *Record) Hash() uint32 {
+	return hashString(r.typ.name) ^ r.ToMap().Hash()
+}
+
+// Equals implements Hasher, records are equal when they are of the same type and hold equal entries
+func (r *Record) Equals(o Value) bool {
+	or, ok := o.(*Record)
+	if !ok || or.typ != r.typ {
+		return false
+	}
+	for i := range r.fields {
+		if !Equals(r.fields[i], or.fields[i]) {
+			return false
+		}
+	}
+	return r.ext.Equals(or.ext)
+}
+
+// InvokeMethod implements Receiver, fields can be read with (.field r) or (.-field r)
+func (r *Record) InvokeMethod(name Symbol, args []Value) (Value, error) {
+	if len(args) != 0 {
+		return NIL, NewExecutionError(fmt.Sprintf("%s has no method %s", r.typ.name, name))
+	}
+	return r.typ.field(r.fields, name)
+}
+
+// Instance is a value of a type created by deftype
+type Instance struct {
+	typ    *RecordType
+	fields []Value
+}
+
+// Type implements Value
+func (i *Instance) Type() ValueType { return i.typ }
+
+// Unbox implements Value
+func (i *Instance) Unbox() interface{} { return i }
+
+func (i *Instance) String() string {
+	return fmt.Sprintf("<%s %s>", i.typ.name, ArrayVector(i.fields))
+}
+
+// InvokeMethod implements Receiver, fields can be read with (.field i) or (.-field i)
+func (i *Instance) InvokeMethod(name Symbol, args []Value) (Value, error) {
+	if len(args) != 0 {
+		return NIL, NewExecutionError(fmt.Sprintf("%s has no method %s", i.typ.name, name))
+	}
+	return i.typ.field(i.fields, name)
+}
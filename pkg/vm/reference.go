@@ -0,0 +1,128 @@
+/*
+ * Copyright (c) 2021 Marcin Gasperowicz <xnooga@gmail.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+ * documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit
+ * persons to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+ * Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+ * WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+ * OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package vm
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Reference is implemented by values which can be dereferenced with deref or @
+type Reference interface {
+	Value
+	Deref() Value
+}
+
+// Watchable is implemented by reference types supporting validators and watches
+type Watchable interface {
+	Reference
+	Validator() Value
+	SetValidator(Value) error
+	AddWatch(Value, Fn)
+	RemoveWatch(Value)
+}
+
+// watchers holds validator and watches shared by mutable reference types
+type watchers struct {
+	mu        sync.Mutex
+	validator Fn
+	watches   *Map
+}
+
+// Validator returns the validator fn or nil if there is none
+func (w *watchers) Validator() Value {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.validator == nil {
+		return NIL
+	}
+	return w.validator
+}
+
+func (w *watchers) setValidator(v Value) error {
+	if v == NIL {
+		w.mu.Lock()
+		w.validator = nil
+		w.mu.Unlock()
+		return nil
+	}
+	f, ok := v.(Fn)
+	if !ok {
+		return NewTypeError(v, "can't be used as a validator", nil)
+	}
+	w.mu.Lock()
+	w.validator = f
+	w.mu.Unlock()
+	return nil
+}
+
+// validate checks val against the validator, failing validation and validator errors are returned
+func (w *watchers) validate(val Value) error {
+	w.mu.Lock()
+	f := w.validator
+	w.mu.Unlock()
+	if f == nil {
+		return nil
+	}
+	ok, err := f.Invoke([]Value{val})
+	if err != nil {
+		return NewExecutionError("validator failed").Wrap(err)
+	}
+	if !IsTruthy(ok) {
+		return NewExecutionError(fmt.Sprintf("invalid reference state %s", val))
+	}
+	return nil
+}
+
+// AddWatch adds a watch fn under key, replacing the previous one
+func (w *watchers) AddWatch(key Value, fn Fn) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.watches == nil {
+		w.watches = EmptyMap
+	}
+	w.watches = w.watches.Assoc(key, fn).(*Map)
+}
+
+// RemoveWatch removes watch fn under key
+func (w *watchers) RemoveWatch(key Value) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.watches == nil {
+		return
+	}
+	w.watches = w.watches.Dissoc(key).(*Map)
+}
+
+// notifyWatches calls every watch with key, ref, old and new value
+func (w *watchers) notifyWatches(ref Value, old Value, new Value) error {
+	w.mu.Lock()
+	watches := w.watches
+	w.mu.Unlock()
+	if watches == nil {
+		return nil
+	}
+	var err error
+	watches.Each(func(k Value, f Value) {
+		if err != nil {
+			return
+		}
+		_, err = f.(Fn).Invoke([]Value{k, ref, old, new})
+	})
+	return err
+}
@@ -0,0 +1,224 @@
+/*
+ * Copyright (c) 2021 Marcin Gasperowicz <xnooga@gmail.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+ * documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit
+ * persons to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+ * Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+ * WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+ * OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package vm
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+type theSetType struct{}
+
+func (t *theSetType) String() string     { return t.Name() }
+func (t *theSetType) Type() ValueType    { return TypeType }
+func (t *theSetType) Unbox() interface{} { return reflect.TypeOf(t) }
+
+func (t *theSetType) Name() string { return "let-go.lang.Set" }
+
+func (t *theSetType) Box(bare interface{}) (Value, error) {
+	casted, ok := bare.([]Value)
+	if !ok {
+		return NIL, NewTypeError(bare, "can't be boxed as", t)
+	}
+	return NewSet(casted), nil
+}
+
+// SetType is the type of Sets
+var SetType *theSetType
+
+// EmptySet is an empty Set
+var EmptySet *Set
+
+func init() {
+	SetType = &theSetType{}
+	EmptySet = &Set{m: EmptyMap}
+}
+
+// Set is a persistent hash set, it's backed by a Map from elements to themselves
+type Set struct {
+	m    *Map
+	meta *Map
+}
+
+// NewSet creates a Set holding vs, duplicates are dropped
+func NewSet(vs []Value) *Set {
+	ret := EmptySet
+	for i := range vs {
+		ret = ret.Conj(vs[i])
+	}
+	return ret
+}
+
+// NewSetLiteral is NewSet refusing equal elements, set literals are built with it
+func NewSetLiteral(vs []Value) (Value, error) {
+	ret := EmptySet
+	for i := range vs {
+		if ret.Contains(vs[i]) {
+			return NIL, NewExecutionError(fmt.Sprintf("duplicate key: %v", vs[i]))
+		}
+		ret = ret.Conj(vs[i])
+	}
+	return ret, nil
+}
+
+// Type implements Value
+func (s *Set) Type() ValueType { return SetType }
+
+// Unbox implements Value
+func (s *Set) Unbox() interface{} {
+	return s.values()
+}
+
+func (s *Set) values() []Value {
+	ret := make([]Value, 0, s.m.count)
+	s.Each(func(v Value) {
+		ret = append(ret, v)
+	})
+	return ret
+}
+
+// Each calls fn for every element of the set
+func (s *Set) Each(fn func(Value)) {
+	s.m.Each(func(k Value, _ Value) {
+		fn(k)
+	})
+}
+
+// Conj returns a set with v added
+func (s *Set) Conj(v Value) *Set {
+	if s.m.Contains(v) {
+		return s
+	}
+	return &Set{m: s.m.Assoc(v, v).(*Map), meta: s.meta}
+}
+
+// Disj returns a set without v
+func (s *Set) Disj(v Value) *Set {
+	if !s.m.Contains(v) {
+		return s
+	}
+	return &Set{m: s.m.Dissoc(v).(*Map), meta: s.meta}
+}
+
+// Contains checks if v is an element of the set
+func (s *Set) Contains(v Value) bool {
+	return s.m.Contains(v)
+}
+
+// Seq returns elements of the set as a seq
+func (s *Set) Seq() Seq {
+	if s.m.count == 0 {
+		return EmptyList
+	}
+	ret, _ := ListType.Box(s.values())
+	return ret.(*List)
+}
+
+// Count implements Collection
+func (s *Set) Count() Value {
+	return Int(s.m.count)
+}
+
+// RawCount implements Collection
+func (s *Set) RawCount() int {
+	return s.m.count
+}
+
+// Empty implements Collection
+func (s *Set) Empty() Collection {
+	return EmptySet.WithMeta(s.meta).(Collection)
+}
+
+// ValueAt implements Lookup, it returns the element equal to key
+func (s *Set) ValueAt(key Value) Value {
+	return s.ValueAtOr(key, NIL)
+}
+
+// ValueAtOr implements Lookup
+func (s *Set) ValueAtOr(key Value, dflt Value) Value {
+	return s.m.ValueAtOr(key, dflt)
+}
+
+// Arity implements Fn
+func (s *Set) Arity() int {
+	return -1
+}
+
+// Invoke implements Fn, sets are predicates checking membership which return the element found
+func (s *Set) Invoke(pargs []Value) (ret Value, err error) {
+	defer recoverSeqError(&err)
+	if len(pargs) != 1 {
+		return NIL, NewExecutionError(fmt.Sprintf("wrong number of arguments (%d) passed to a set", len(pargs)))
+	}
+	return s.ValueAt(pargs[0]), nil
+}
+
+// Hash implements Hasher
+func (s *Set) Hash() uint32 {
+	var h uint32
+	s.Each(func(v Value) {
+		h += Hash(v)
+	})
+	return h
+}
+
+// Equals implements Hasher
+func (s *Set) Equals(o Value) bool {
+	other, ok := o.(*Set)
+	if !ok || other.m.count != s.m.count {
+		return false
+	}
+	if other == s {
+		return true
+	}
+	if s.m.root == nil {
+		return true
+	}
+	return s.m.root.each(func(k Value, _ Value) bool {
+		return other.m.Contains(k)
+	})
+}
+
+// Meta implements IMeta
+func (s *Set) Meta() *Map {
+	return s.meta
+}
+
+// WithMeta implements IObj
+func (s *Set) WithMeta(meta *Map) IObj {
+	if meta == s.meta {
+		return s
+	}
+	return &Set{m: s.m, meta: meta}
+}
+
+func (s *Set) String() string {
+	b := &strings.Builder{}
+	b.WriteString("#{")
+	i := 0
+	s.Each(func(v Value) {
+		if i > 0 {
+			b.WriteRune(' ')
+		}
+		b.WriteString(v.String())
+		i++
+	})
+	b.WriteRune('}')
+	return b.String()
+}
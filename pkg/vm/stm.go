@@ -0,0 +1,480 @@
+/*
+ * Copyright (c) 2021 Marcin Gasperowicz <xnooga@gmail.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+ * documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit
+ * persons to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+ * Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+ * WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+ * OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package vm
+
+import (
+	"fmt"
+	"github.com/nooga/let-go/pkg/errors"
+	"math/rand"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type theRefType struct{}
+
+func (t *theRefType) String() string     { return t.Name() }
+func (t *theRefType) Type() ValueType    { return TypeType }
+func (t *theRefType) Unbox() interface{} { return reflect.TypeOf(t) }
+
+func (t *theRefType) Name() string { return "let-go.lang.Ref" }
+func (t *theRefType) Box(b interface{}) (Value, error) {
+	return NIL, NewTypeError(b, "can't be boxed as", t)
+}
+
+// RefType is the type of Refs
+var RefType *theRefType
+
+func init() {
+	RefType = &theRefType{}
+}
+
+// stmClock is bumped by every commit, transactions see values committed before they started
+var stmClock int64
+
+// stmAge orders transactions, the older one wins when two of them want to write the same ref
+var stmAge int64
+
+var refIDs int64
+
+const (
+	// DefaultMaxHistory is the default number of past values a Ref keeps
+	DefaultMaxHistory = 10
+	maxRetries        = 10000
+)
+
+// retryError makes the running transaction start over, let-go code can't catch it
+type retryError struct{}
+
+func (e *retryError) Error() string { return "transaction retry" }
+
+var errRetry error = &retryError{}
+
+// isRetry checks if err was caused by a transaction conflict
+func isRetry(err error) bool {
+	return errors.IsCausedBy(err, errRetry)
+}
+
+// refEntry is a value committed to a Ref at point
+type refEntry struct {
+	val   Value
+	point int64
+}
+
+// Ref is a reference changed only in transactions. It keeps a history of committed values so transactions
+// can read a consistent snapshot while other transactions commit.
+type Ref struct {
+	watchers
+	id         int64
+	lock       sync.Mutex
+	history    []refEntry // newest first
+	owner      *Transaction
+	faults     int
+	minHistory int
+	maxHistory int
+}
+
+// NewRef creates a Ref holding val
+func NewRef(val Value) *Ref {
+	return &Ref{
+		id:         atomic.AddInt64(&refIDs, 1),
+		history:    []refEntry{{val: val}},
+		maxHistory: DefaultMaxHistory,
+	}
+}
+
+// Type implements Value
+func (r *Ref) Type() ValueType { return RefType }
+
+// Unbox implements Value
+func (r *Ref) Unbox() interface{} { return r }
+
+func (r *Ref) String() string {
+	return fmt.Sprintf("<ref %s>", r.Deref())
+}
+
+// latest returns the most recently committed value
+func (r *Ref) latest() Value {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.history[0].val
+}
+
+// Deref implements Reference, in a transaction it returns the in-transaction value
+// falling back to the latest committed value if the snapshot is gone
+func (r *Ref) Deref() Value {
+	v, err := r.Get()
+	if err != nil {
+		return r.latest()
+	}
+	return v
+}
+
+// Get is Deref which can fail when the transaction has to be retried
+func (r *Ref) Get() (Value, error) {
+	if t := currentTransaction(); t != nil {
+		return t.get(r)
+	}
+	return r.latest(), nil
+}
+
+// SetValidator implements Watchable, the current value must pass the new validator
+func (r *Ref) SetValidator(v Value) error {
+	old := r.Validator()
+	if err := r.setValidator(v); err != nil {
+		return err
+	}
+	if err := r.validate(r.latest()); err != nil {
+		_ = r.setValidator(old)
+		return err
+	}
+	return nil
+}
+
+// SetHistoryLimits sets how many past values the ref can keep for slower transactions
+func (r *Ref) SetHistoryLimits(min int, max int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.minHistory, r.maxHistory = min, max
+}
+
+// HistoryCount returns the number of past values kept
+func (r *Ref) HistoryCount() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return len(r.history) - 1
+}
+
+// push records val committed at point, the history grows when readers failed to find old enough values
+func (r *Ref) push(val Value, point int64) {
+	n := len(r.history)
+	if (r.faults > 0 && n <= r.maxHistory) || n <= r.minHistory {
+		r.faults = 0
+		r.history = append([]refEntry{{val: val, point: point}}, r.history...)
+		return
+	}
+	copy(r.history[1:], r.history[:n-1])
+	r.history[0] = refEntry{val: val, point: point}
+}
+
+// Ensure makes sure r isn't changed by other transactions until the running one commits
+func (r *Ref) Ensure() (Value, error) {
+	t, err := runningTransaction()
+	if err != nil {
+		return NIL, err
+	}
+	if err := t.claim(r, true); err != nil {
+		return NIL, err
+	}
+	return t.get(r)
+}
+
+// Set sets the in-transaction value of r
+func (r *Ref) Set(val Value) (Value, error) {
+	t, err := runningTransaction()
+	if err != nil {
+		return NIL, err
+	}
+	return val, t.set(r, val)
+}
+
+// Alter sets the in-transaction value of r to (f val args...)
+func (r *Ref) Alter(f Fn, args []Value) (Value, error) {
+	t, err := runningTransaction()
+	if err != nil {
+		return NIL, err
+	}
+	old, err := t.get(r)
+	if err != nil {
+		return NIL, err
+	}
+	val, err := f.Invoke(append([]Value{old}, args...))
+	if err != nil {
+		return NIL, err
+	}
+	return val, t.set(r, val)
+}
+
+// Commute sets the in-transaction value of r to (f val args...), f is applied again to the latest value
+// on commit so commutes never conflict with other transactions
+func (r *Ref) Commute(f Fn, args []Value) (Value, error) {
+	t, err := runningTransaction()
+	if err != nil {
+		return NIL, err
+	}
+	if err := t.checkRunning(); err != nil {
+		return NIL, err
+	}
+	old, ok := t.vals[r]
+	if !ok {
+		old = r.latest()
+	}
+	val, err := f.Invoke(append([]Value{old}, args...))
+	if err != nil {
+		return NIL, err
+	}
+	t.vals[r] = val
+	t.commutes[r] = append(t.commutes[r], commute{fn: f, args: args})
+	return val, nil
+}
+
+const (
+	txRunning int32 = iota
+	txCommitting
+	txKilled
+	txDone
+)
+
+type commute struct {
+	fn   Fn
+	args []Value
+}
+
+// refChange is a committed change reported to watches
+type refChange struct {
+	ref *Ref
+	old Value
+	new Value
+}
+
+// Transaction runs on a single goroutine and keeps in-transaction values of refs until it commits
+type Transaction struct {
+	age       int64
+	state     int32
+	readPoint int64
+	vals      map[*Ref]Value
+	sets      map[*Ref]bool
+	commutes  map[*Ref][]commute
+	owned     map[*Ref]bool
+	// afterCommit holds agent sends, they are dispatched only if the transaction commits
+	afterCommit []func()
+}
+
+// transactions maps goroutine ids to transactions running on them
+var transactions sync.Map
+
+func currentTransaction() *Transaction {
+	t, ok := transactions.Load(goroutineID())
+	if !ok {
+		return nil
+	}
+	return t.(*Transaction)
+}
+
+func runningTransaction() (*Transaction, error) {
+	t := currentTransaction()
+	if t == nil {
+		return nil, NewExecutionError("no transaction running")
+	}
+	return t, nil
+}
+
+// InTransaction checks if the calling goroutine is running a transaction
+func InTransaction() bool {
+	return currentTransaction() != nil
+}
+
+// RunInTransaction invokes f in a transaction, f is invoked again every time the transaction has to be retried.
+// Nested calls join the transaction already running.
+func RunInTransaction(f Fn) (Value, error) {
+	if InTransaction() {
+		return f.Invoke([]Value{})
+	}
+	gid := goroutineID()
+	t := &Transaction{age: atomic.AddInt64(&stmAge, 1)}
+	transactions.Store(gid, t)
+	defer transactions.Delete(gid)
+	for i := 0; i < maxRetries; i++ {
+		t.begin()
+		val, err := f.Invoke([]Value{})
+		var changes []refChange
+		if err == nil {
+			changes, err = t.commit()
+		}
+		t.release()
+		if err == nil {
+			for _, f := range t.afterCommit {
+				f()
+			}
+			return val, notifyRefWatches(changes)
+		}
+		if !isRetry(err) {
+			return NIL, err
+		}
+		// give the transaction we conflicted with some time to finish
+		time.Sleep(time.Duration(rand.Intn(10*(i%10+1))) * time.Microsecond)
+	}
+	return NIL, NewExecutionError("transaction failed after reaching retry limit")
+}
+
+func (t *Transaction) begin() {
+	atomic.StoreInt32(&t.state, txRunning)
+	t.readPoint = atomic.LoadInt64(&stmClock)
+	t.vals = map[*Ref]Value{}
+	t.sets = map[*Ref]bool{}
+	t.commutes = map[*Ref][]commute{}
+	t.owned = map[*Ref]bool{}
+	t.afterCommit = nil
+}
+
+func (t *Transaction) checkRunning() error {
+	if atomic.LoadInt32(&t.state) == txKilled {
+		return errRetry
+	}
+	return nil
+}
+
+// get reads the in-transaction value of r or the value it had when the transaction started
+func (t *Transaction) get(r *Ref) (Value, error) {
+	if err := t.checkRunning(); err != nil {
+		return NIL, err
+	}
+	if v, ok := t.vals[r]; ok {
+		return v, nil
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, e := range r.history {
+		if e.point <= t.readPoint {
+			return e.val, nil
+		}
+	}
+	// the snapshot value is gone, let the ref keep more history next time
+	r.faults++
+	return NIL, errRetry
+}
+
+func (t *Transaction) set(r *Ref, val Value) error {
+	if err := t.checkRunning(); err != nil {
+		return err
+	}
+	if _, ok := t.commutes[r]; ok && !t.sets[r] {
+		return NewExecutionError("can't set a ref after commute")
+	}
+	if err := t.claim(r, true); err != nil {
+		return err
+	}
+	t.vals[r] = val
+	t.sets[r] = true
+	return nil
+}
+
+// claim makes t the only transaction allowed to write r. When checkPoint is set t has to retry if r
+// was changed after t started. A younger running owner is killed, an older one makes t retry.
+func (t *Transaction) claim(r *Ref, checkPoint bool) error {
+	if t.owned[r] {
+		return nil
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if checkPoint && r.history[0].point > t.readPoint {
+		return errRetry
+	}
+	if o := r.owner; o != nil && o != t && !o.yieldTo(t) {
+		return errRetry
+	}
+	r.owner = t
+	t.owned[r] = true
+	return nil
+}
+
+// yieldTo checks if t can take over refs owned by o, killing o if it's younger
+func (o *Transaction) yieldTo(t *Transaction) bool {
+	switch atomic.LoadInt32(&o.state) {
+	case txKilled, txDone:
+		return true
+	}
+	return t.age < o.age && atomic.CompareAndSwapInt32(&o.state, txRunning, txKilled)
+}
+
+// commit publishes in-transaction values of all written refs at a single point in time
+func (t *Transaction) commit() ([]refChange, error) {
+	for r := range t.commutes {
+		if !t.sets[r] {
+			if err := t.claim(r, false); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if !atomic.CompareAndSwapInt32(&t.state, txRunning, txCommitting) {
+		return nil, errRetry
+	}
+	var err error
+	for r, commutes := range t.commutes {
+		if t.sets[r] {
+			continue
+		}
+		val := r.latest()
+		for _, c := range commutes {
+			val, err = c.fn.Invoke(append([]Value{val}, c.args...))
+			if err != nil {
+				return nil, err
+			}
+		}
+		t.vals[r] = val
+	}
+	refs := make([]*Ref, 0, len(t.vals))
+	for r := range t.vals {
+		if t.sets[r] || t.commutes[r] != nil {
+			refs = append(refs, r)
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].id < refs[j].id })
+	for _, r := range refs {
+		if err := r.validate(t.vals[r]); err != nil {
+			return nil, err
+		}
+	}
+	for _, r := range refs {
+		r.lock.Lock()
+	}
+	point := atomic.AddInt64(&stmClock, 1)
+	changes := make([]refChange, len(refs))
+	for i, r := range refs {
+		changes[i] = refChange{ref: r, old: r.history[0].val, new: t.vals[r]}
+		r.push(t.vals[r], point)
+	}
+	for _, r := range refs {
+		r.lock.Unlock()
+	}
+	atomic.StoreInt32(&t.state, txDone)
+	return changes, nil
+}
+
+// release gives up ownership of all refs claimed by t
+func (t *Transaction) release() {
+	for r := range t.owned {
+		r.lock.Lock()
+		if r.owner == t {
+			r.owner = nil
+		}
+		r.lock.Unlock()
+	}
+	t.owned = map[*Ref]bool{}
+}
+
+func notifyRefWatches(changes []refChange) error {
+	for _, c := range changes {
+		if err := c.ref.notifyWatches(c.ref, c.old, c.new); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -73,3 +73,14 @@ func (l String) Unbox() interface{} {
 func (l String) String() string {
 	return fmt.Sprintf("%q", string(l))
 }
+
+// Hash implements Hasher
+func (l String) Hash() uint32 {
+	return hashString(string(l))
+}
+
+// Equals implements Hasher
+func (l String) Equals(o Value) bool {
+	s, ok := o.(String)
+	return ok && s == l
+}
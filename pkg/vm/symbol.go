@@ -64,9 +64,94 @@ func (l Symbol) String() string {
 }
 
 func (l Symbol) Namespaced() (Value, Value) {
-	x := strings.Split(string(l), "/")
-	if len(x) == 2 {
-		return Symbol(x[0]), Symbol(x[1])
+	s := string(l)
+	i := strings.IndexRune(s, '/')
+	if i <= 0 || i == len(s)-1 {
+		return NIL, l
 	}
-	return NIL, Symbol(x[0])
+	return Symbol(s[:i]), Symbol(s[i+1:])
+}
+
+// Hash implements Hasher
+func (l Symbol) Hash() uint32 {
+	return hashString("'" + string(l))
+}
+
+// Equals implements Hasher
+func (l Symbol) Equals(o Value) bool {
+	switch s := o.(type) {
+	case Symbol:
+		return s == l
+	case *MetaSymbol:
+		return s.sym == l
+	}
+	return false
+}
+
+// Meta implements IMeta, bare symbols have no metadata
+func (l Symbol) Meta() *Map {
+	return nil
+}
+
+// WithMeta implements IObj
+func (l Symbol) WithMeta(meta *Map) IObj {
+	if meta == nil {
+		return l
+	}
+	return &MetaSymbol{sym: l, meta: meta}
+}
+
+// MetaSymbol is a Symbol carrying metadata, it's equal to the bare Symbol.
+// The compiler strips metadata off symbols it compiles, see Symbol() and SymbolOf.
+type MetaSymbol struct {
+	sym  Symbol
+	meta *Map
+}
+
+// Type implements Value
+func (l *MetaSymbol) Type() ValueType { return SymbolType }
+
+// Unbox implements Value
+func (l *MetaSymbol) Unbox() interface{} {
+	return string(l.sym)
+}
+
+func (l *MetaSymbol) String() string {
+	return string(l.sym)
+}
+
+// Symbol returns the bare symbol
+func (l *MetaSymbol) Symbol() Symbol {
+	return l.sym
+}
+
+// Hash implements Hasher
+func (l *MetaSymbol) Hash() uint32 {
+	return l.sym.Hash()
+}
+
+// Equals implements Hasher
+func (l *MetaSymbol) Equals(o Value) bool {
+	return l.sym.Equals(o)
+}
+
+// Meta implements IMeta
+func (l *MetaSymbol) Meta() *Map {
+	return l.meta
+}
+
+// WithMeta implements IObj
+func (l *MetaSymbol) WithMeta(meta *Map) IObj {
+	return l.sym.WithMeta(meta)
+}
+
+// SymbolOf returns v as a bare symbol if it's a Symbol or a MetaSymbol
+func SymbolOf(v Value) (Symbol, bool) {
+	switch s := v.(type) {
+	case Symbol:
+		return s, true
+	case *MetaSymbol:
+		return s.sym, true
+	}
+	return "", false
 }
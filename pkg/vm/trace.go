@@ -0,0 +1,114 @@
+/*
+ * Copyright (c) 2021 Marcin Gasperowicz <xnooga@gmail.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+ * documentation files (the "Software"), to deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit
+ * persons to whom the Software is furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+ * Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+ * WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+ * OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package vm
+
+import (
+	"fmt"
+	"github.com/nooga/let-go/pkg/errors"
+	"strings"
+)
+
+// SourceInfo points at a place in let-go source code, lines and columns are 1-based
+type SourceInfo struct {
+	File   string
+	Line   int
+	Column int
+}
+
+func (s SourceInfo) String() string {
+	file := s.File
+	if file == "" {
+		file = "<unknown>"
+	}
+	return fmt.Sprintf("%s:%d:%d", file, s.Line, s.Column)
+}
+
+// lineInfo maps all code starting at ip to a source position
+type lineInfo struct {
+	ip   int
+	info SourceInfo
+}
+
+// StackFrame is a single entry of a let-go stack trace
+type StackFrame struct {
+	Fn     string
+	Source SourceInfo
+	Known  bool
+}
+
+func (s StackFrame) String() string {
+	if !s.Known {
+		return s.Fn
+	}
+	return fmt.Sprintf("%s (%s)", s.Fn, s.Source)
+}
+
+// RuntimeError carries a let-go stack trace of an error which escaped let-go code.
+// Frames are ordered from the innermost one outwards.
+type RuntimeError struct {
+	cause error
+	trace []StackFrame
+}
+
+// Error implements error
+func (e *RuntimeError) Error() string {
+	b := strings.Builder{}
+	b.WriteString(e.cause.Error())
+	for i := range e.trace {
+		b.WriteString("\n\tat ")
+		b.WriteString(e.trace[i].String())
+	}
+	return b.String()
+}
+
+func (e *RuntimeError) Wrap(err error) errors.Error {
+	e.cause = err
+	return e
+}
+
+func (e *RuntimeError) GetCause() error {
+	return e.cause
+}
+
+// Trace returns the let-go stack trace, innermost frame first
+func (e *RuntimeError) Trace() []StackFrame {
+	return e.trace
+}
+
+// addTrace records frame f in err's stack trace
+func addTrace(err error, f *Frame) error {
+	re, ok := err.(*RuntimeError)
+	if !ok {
+		re = &RuntimeError{cause: err}
+	}
+	sf := StackFrame{Fn: "<toplevel>"}
+	if f.fn != nil {
+		sf.Fn = f.fn.Name()
+	}
+	sf.Source, sf.Known = f.code.SourceInfo(f.ip)
+	re.trace = append(re.trace, sf)
+	return re
+}
+
+// unwrapRuntimeError strips the stack trace off err
+func unwrapRuntimeError(err error) error {
+	if re, ok := err.(*RuntimeError); ok {
+		return re.cause
+	}
+	return err
+}
@@ -19,6 +19,7 @@ package vm
 
 import (
 	"fmt"
+	"math/big"
 	"reflect"
 )
 
@@ -53,9 +54,10 @@ type Collection interface {
 	Empty() Collection
 }
 
+// Fn is implemented by all invokable values
 type Fn interface {
 	Value
-	Invoke([]Value) Value
+	Invoke([]Value) (Value, error)
 	Arity() int
 }
 
@@ -71,9 +73,15 @@ type Lookup interface {
 	ValueAtOr(Value, Value) Value
 }
 
+// Keyed is implemented by collections which can tell if they hold a key, it backs contains?
+type Keyed interface {
+	Value
+	Contains(Value) bool
+}
+
 type Receiver interface {
 	Value
-	InvokeMethod(Symbol, []Value) Value
+	InvokeMethod(Symbol, []Value) (Value, error)
 }
 
 type theTypeType struct{}
@@ -101,9 +109,23 @@ func BoxValue(v reflect.Value) (Value, error) {
 			return rv, nil
 		}
 	}
+	if v.CanInterface() {
+		switch n := v.Interface().(type) {
+		case *big.Int:
+			if n != nil {
+				return NewBigInt(n), nil
+			}
+		case *big.Rat:
+			if n != nil {
+				return NewRatio(n), nil
+			}
+		}
+	}
 	switch v.Type().Kind() {
 	case reflect.Int:
 		return IntType.Box(v.Interface())
+	case reflect.Float64, reflect.Float32:
+		return Float(v.Float()), nil
 	case reflect.String:
 		return StringType.Box(v.Interface())
 	case reflect.Bool:
@@ -154,7 +176,7 @@ func BoxValue(v reflect.Value) (Value, error) {
 		if v.IsNil() {
 			return NIL, nil
 		}
-		return NIL, NewTypeError(v, "is not boxable", nil)
+		return ChanType.Box(v.Interface())
 	default:
 		if v.CanInterface() {
 			return NewBoxed(v.Interface()), nil
@@ -17,26 +17,48 @@
 
 package vm
 
-import "fmt"
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
 
+// Var is a named reference living in a namespace, its root can be read and set from many goroutines.
+// Dynamic vars can also be bound per goroutine with PushBindings.
 type Var struct {
-	root    Value
-	nsref   *Namespace
-	ns      string
-	name    string
-	isMacro bool
+	root      atomic.Value // holds varRoot
+	rootMu    sync.Mutex   // serializes AlterRoot
+	nsref     *Namespace
+	ns        string
+	name      string
+	isMacro   int32
+	isDynamic int32
+	bound     int32        // number of live bindings of this var, lets Deref skip looking them up
+	meta      atomic.Value // holds varMeta
+	metaMu    sync.Mutex   // serializes AlterMeta
 }
 
-func (v *Var) Invoke(values []Value) Value {
-	f, ok := v.root.(Fn)
+// varMeta wraps var metadata for the same reason as varRoot
+type varMeta struct {
+	m *Map
+}
+
+// varRoot wraps var roots because atomic.Value requires all stored values to be of the same type
+type varRoot struct {
+	val Value
+}
+
+func (v *Var) Invoke(values []Value) (Value, error) {
+	root := v.Deref()
+	f, ok := root.(Fn)
 	if !ok {
-		return NIL // FIXME this should be an error
+		return NIL, NewTypeError(root, "is not a function", nil)
 	}
 	return f.Invoke(values)
 }
 
 func (v *Var) Arity() int {
-	f, ok := v.root.(Fn)
+	f, ok := v.Deref().(Fn)
 	if !ok {
 		return 0 // FIXME this should be an error
 	}
@@ -44,22 +66,64 @@ func (v *Var) Arity() int {
 }
 
 func NewVar(nsref *Namespace, ns string, name string) *Var {
-	return &Var{
-		nsref:   nsref,
-		ns:      ns,
-		name:    name,
-		root:    NIL,
-		isMacro: false,
+	va := &Var{
+		nsref: nsref,
+		ns:    ns,
+		name:  name,
 	}
+	va.root.Store(varRoot{NIL})
+	va.meta.Store(varMeta{EmptyMap})
+	return va
 }
 
 func (v *Var) SetRoot(val Value) *Var {
-	v.root = val
+	v.root.Store(varRoot{val})
 	return v
 }
 
+// AlterRoot sets the root of v to the result of f applied to its current root
+func (v *Var) AlterRoot(f func(Value) (Value, error)) (Value, error) {
+	v.rootMu.Lock()
+	defer v.rootMu.Unlock()
+	val, err := f(v.root.Load().(varRoot).val)
+	if err != nil {
+		return NIL, err
+	}
+	v.SetRoot(val)
+	return val, nil
+}
+
 func (v *Var) Deref() Value {
-	return v.root
+	if b := v.binding(); b != nil {
+		return b.get()
+	}
+	return v.root.Load().(varRoot).val
+}
+
+// binding returns the binding of v visible on the calling goroutine, if any
+func (v *Var) binding() *varBinding {
+	if atomic.LoadInt32(&v.bound) == 0 {
+		return nil
+	}
+	b := currentBindings()
+	if b == nil {
+		return nil
+	}
+	return b.vals[v]
+}
+
+// Set changes the value v is bound to on the calling goroutine, or its root if it's not bound
+func (v *Var) Set(val Value) {
+	if b := v.binding(); b != nil {
+		b.set(val)
+		return
+	}
+	v.SetRoot(val)
+}
+
+// IsThreadBound checks if v is bound on the calling goroutine
+func (v *Var) IsThreadBound() bool {
+	return v.binding() != nil
 }
 
 func (v *Var) Type() ValueType {
@@ -70,14 +134,91 @@ func (v *Var) Unbox() interface{} {
 	return v.Deref().Unbox()
 }
 
+// Namespace returns the name of the namespace this var was defined in
+func (v *Var) Namespace() string {
+	return v.ns
+}
+
+func (v *Var) Name() string {
+	return v.name
+}
+
 func (v *Var) String() string {
 	return fmt.Sprintf("#'%s/%s", v.ns, v.name)
 }
 
 func (v *Var) IsMacro() bool {
-	return v.isMacro
+	return atomic.LoadInt32(&v.isMacro) != 0
 }
 
 func (v *Var) SetMacro() {
-	v.isMacro = true
+	atomic.StoreInt32(&v.isMacro, 1)
+}
+
+func (v *Var) IsDynamic() bool {
+	return atomic.LoadInt32(&v.isDynamic) != 0
+}
+
+func (v *Var) SetDynamic() *Var {
+	atomic.StoreInt32(&v.isDynamic, 1)
+	return v
+}
+
+// Meta implements IMeta, the metadata always has :ns and :name, :macro and :dynamic are present when set
+func (v *Var) Meta() *Map {
+	m := v.meta.Load().(varMeta).m
+	var ns Value = Symbol(v.ns)
+	if v.nsref != nil {
+		ns = v.nsref
+	}
+	ret := m.Assoc(Keyword("ns"), ns).Assoc(Keyword("name"), Symbol(v.name))
+	if v.IsMacro() {
+		ret = ret.Assoc(Keyword("macro"), TRUE)
+	}
+	if v.IsDynamic() {
+		ret = ret.Assoc(Keyword("dynamic"), TRUE)
+	}
+	return ret.(*Map)
+}
+
+// SetMeta replaces metadata of v, :macro and :dynamic in m decide if v is a macro and if it's dynamic
+func (v *Var) SetMeta(m *Map) *Var {
+	var macro, dynamic int32
+	if IsTruthy(m.ValueAtOr(Keyword("macro"), NIL)) {
+		macro = 1
+	}
+	if IsTruthy(m.ValueAtOr(Keyword("dynamic"), NIL)) {
+		dynamic = 1
+	}
+	v.meta.Store(varMeta{m})
+	atomic.StoreInt32(&v.isMacro, macro)
+	atomic.StoreInt32(&v.isDynamic, dynamic)
+	return v
+}
+
+// AlterMeta sets metadata of v to the result of f applied to its current metadata
+func (v *Var) AlterMeta(f func(*Map) (*Map, error)) (*Map, error) {
+	v.metaMu.Lock()
+	defer v.metaMu.Unlock()
+	m, err := f(v.Meta())
+	if err != nil {
+		return nil, err
+	}
+	v.SetMeta(m)
+	return m, nil
+}
+
+// metaFlag checks if key is set to a truthy value in metadata of v
+func (v *Var) metaFlag(key Keyword) bool {
+	return IsTruthy(v.meta.Load().(varMeta).m.ValueAtOr(key, NIL))
+}
+
+// IsPrivate checks if v is marked with :private, private vars are only visible in their own namespace
+func (v *Var) IsPrivate() bool {
+	return v.metaFlag(Keyword("private"))
+}
+
+// IsConst checks if v is marked with :const, the compiler inlines values of such vars
+func (v *Var) IsConst() bool {
+	return v.metaFlag(Keyword("const"))
 }
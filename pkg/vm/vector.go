@@ -52,6 +52,16 @@ type ArrayVector []Value
 // Type implements Value
 func (l ArrayVector) Type() ValueType { return ArrayVectorType }
 
+// Meta implements IMeta, ArrayVectors never carry metadata
+func (l ArrayVector) Meta() *Map {
+	return nil
+}
+
+// WithMeta implements IObj by turning l into a PersistentVector
+func (l ArrayVector) WithMeta(meta *Map) IObj {
+	return NewPersistentVector(l).WithMeta(meta)
+}
+
 // Unbox implements Value
 func (l ArrayVector) Unbox() interface{} {
 	return []Value(l)
@@ -81,7 +91,7 @@ func (l ArrayVector) Next() Seq {
 
 // Cons implements Seq
 func (l ArrayVector) Cons(val Value) Seq {
-	newl, _ := ListType.Box(l[1:])
+	newl, _ := ListType.Box([]Value(l))
 	return newl.(*List).Cons(val)
 }
 
@@ -99,8 +109,14 @@ func (l ArrayVector) Empty() Collection {
 	return make(ArrayVector, 0)
 }
 
-func NewArrayVector(v []Value) Value {
-	return ArrayVector(v)
+// Contains implements Keyed, vectors are keyed by indices
+func (l ArrayVector) Contains(k Value) bool {
+	i, ok := k.(Int)
+	return ok && i >= 0 && int(i) < len(l)
+}
+
+func NewArrayVector(v []Value) (Value, error) {
+	return ArrayVector(v), nil
 }
 
 func (l ArrayVector) String() string {
@@ -116,3 +132,17 @@ func (l ArrayVector) String() string {
 	b.WriteRune(']')
 	return b.String()
 }
+
+func (l ArrayVector) sequentialValues() []Value {
+	return l
+}
+
+// Hash implements Hasher
+func (l ArrayVector) Hash() uint32 {
+	return hashSequential(l)
+}
+
+// Equals implements Hasher, vectors are equal to other sequential collections with equal elements
+func (l ArrayVector) Equals(o Value) bool {
+	return equalsSequential(l, o)
+}
@@ -20,6 +20,7 @@ package vm
 import (
 	"encoding/binary"
 	"fmt"
+	"sort"
 )
 
 // Opcodes
@@ -47,8 +48,14 @@ const (
 	OPLDK // load closed over LDK (index int32)
 	OPPAK // push closed over value to a closure
 
-	OPREC // loop recurse REC (offset int32, argc int32)
+	OPREC // loop recurse REC (offset int32, argc int32, depth int32)
 	OPREF // function recurse REF (argc int32)
+
+	OPTHR // throw value from the top of the stack
+	OPLDS // load the fn being run
+
+	OPDPC // duplicate nth value from the stack and clear its slot DPC (n int32)
+	OPLAC // load argument and clear it LAC (index int32)
 )
 
 func OpcodeToString(op uint8) string {
@@ -71,6 +78,10 @@ func OpcodeToString(op uint8) string {
 		"PAK",
 		"REC",
 		"REF",
+		"THR",
+		"LDS",
+		"DPC",
+		"LAC",
 	}
 	if int(op) < len(ops) {
 		return ops[op]
@@ -78,15 +89,26 @@ func OpcodeToString(op uint8) string {
 	return "???"
 }
 
+// handler describes a protected region of code, errors raised between start and end
+// unwind the stack to sp, push the exception and continue at target
+type handler struct {
+	start  int
+	end    int
+	target int
+	sp     int
+}
+
 // CodeChunk holds bytecode and provides facilities for reading and writing it
 type CodeChunk struct {
 	maxStack int
-	consts   *[]Value
+	consts   *Consts
 	code     []uint8
 	length   int
+	handlers []handler
+	lines    []lineInfo
 }
 
-func NewCodeChunk(consts *[]Value) *CodeChunk {
+func NewCodeChunk(consts *Consts) *CodeChunk {
 	return &CodeChunk{
 		consts: consts,
 		code:   []uint8{},
@@ -96,7 +118,7 @@ func NewCodeChunk(consts *[]Value) *CodeChunk {
 
 func (c *CodeChunk) Debug() {
 	//fmt.Println("consts:")
-	consts := *c.consts
+	consts := c.consts.Values()
 	//for i := range consts {
 	//	fmt.Println("  [", i, "] =", consts[i])
 	//}
@@ -108,9 +130,10 @@ func (c *CodeChunk) Debug() {
 		case OPREC:
 			arg, _ := c.Get32(i + 1)
 			arg2, _ := c.Get32(i + 5)
-			fmt.Println("  ", i, ":", OpcodeToString(op), arg, arg2)
-			i += 9
-		case OPLDA, OPBRT, OPBRF, OPJMP, OPPON, OPDPN, OPINV, OPLDK, OPREF, OPMKC:
+			arg3, _ := c.Get32(i + 9)
+			fmt.Println("  ", i, ":", OpcodeToString(op), arg, arg2, arg3)
+			i += 13
+		case OPLDA, OPBRT, OPBRF, OPJMP, OPPON, OPDPN, OPINV, OPLDK, OPREF, OPDPC, OPLAC:
 			arg, _ := c.Get32(i + 1)
 			fmt.Println("  ", i, ":", OpcodeToString(op), arg)
 			i += 5
@@ -145,6 +168,18 @@ func (c *CodeChunk) AppendChunk(o *CodeChunk) {
 	if o.maxStack > c.maxStack {
 		c.maxStack = o.maxStack
 	}
+	offset := c.length
+	for _, h := range o.handlers {
+		c.handlers = append(c.handlers, handler{
+			start:  h.start + offset,
+			end:    h.end + offset,
+			target: h.target + offset,
+			sp:     h.sp,
+		})
+	}
+	for _, l := range o.lines {
+		c.addLine(l.ip+offset, l.info)
+	}
 	c.code = append(c.code, o.code...)
 	c.length += len(o.code)
 }
@@ -163,6 +198,11 @@ func (c *CodeChunk) Get32(idx int) (int, error) {
 	return int(binary.LittleEndian.Uint32(c.code[idx:])), nil
 }
 
+// Update replaces the opcode at address, the new one has to take the same arguments
+func (c *CodeChunk) Update(address int, op uint8) {
+	c.code[address] = op
+}
+
 func (c *CodeChunk) Update32(address int, value int) {
 	binary.LittleEndian.PutUint32(c.code[address:address+4], uint32(value))
 }
@@ -171,6 +211,56 @@ func (c *CodeChunk) SetMaxStack(max int) {
 	c.maxStack = max
 }
 
+// AddHandler protects code between start and end with a handler at target.
+// Handlers of nested regions must be added before the handlers of the regions enclosing them.
+func (c *CodeChunk) AddHandler(start int, end int, target int, sp int) {
+	c.handlers = append(c.handlers, handler{
+		start:  start,
+		end:    end,
+		target: target,
+		sp:     sp,
+	})
+}
+
+func (c *CodeChunk) findHandler(ip int) *handler {
+	for i := range c.handlers {
+		h := &c.handlers[i]
+		if ip >= h.start && ip < h.end {
+			return h
+		}
+	}
+	return nil
+}
+
+// AddSourceInfo marks code appended from now on as coming from position info in the source
+func (c *CodeChunk) AddSourceInfo(info SourceInfo) {
+	c.addLine(c.length, info)
+}
+
+func (c *CodeChunk) addLine(ip int, info SourceInfo) {
+	n := len(c.lines)
+	if n > 0 {
+		last := &c.lines[n-1]
+		if last.info == info {
+			return
+		}
+		if last.ip == ip {
+			last.info = info
+			return
+		}
+	}
+	c.lines = append(c.lines, lineInfo{ip: ip, info: info})
+}
+
+// SourceInfo returns position in the source of the code at ip
+func (c *CodeChunk) SourceInfo(ip int) (SourceInfo, bool) {
+	i := sort.Search(len(c.lines), func(i int) bool { return c.lines[i].ip > ip })
+	if i == 0 {
+		return SourceInfo{}, false
+	}
+	return c.lines[i-1].info, true
+}
+
 // Frame is a single interpreter context
 type Frame struct {
 	stack       []Value
@@ -180,17 +270,23 @@ type Frame struct {
 	consts      []Value
 	constsc     int
 	code        *CodeChunk
+	fn          *Func
+	self        Fn
 	ip          int
 	sp          int
 }
 
 func NewFrame(code *CodeChunk, args []Value) *Frame {
+	consts := code.consts.Values()
+	// the frame owns its args since LAC and REF overwrite them
+	buf := make([]Value, code.maxStack+len(args))
+	copy(buf[code.maxStack:], args)
 	return &Frame{
-		stack:   make([]Value, code.maxStack),
-		args:    args,
+		stack:   buf[:code.maxStack:code.maxStack],
+		args:    buf[code.maxStack:],
 		argc:    len(args),
-		consts:  *code.consts,
-		constsc: len(*code.consts),
+		consts:  consts,
+		constsc: len(consts),
 		code:    code,
 		ip:      0,
 		sp:      0,
@@ -255,6 +351,9 @@ func (f *Frame) mult(start int, count int) ([]Value, error) {
 }
 
 func (f *Frame) drop(n int) error {
+	if n == 0 {
+		return nil
+	}
 	top := f.sp - 1
 	if top < 0 {
 		f.stackDbg()
@@ -279,7 +378,36 @@ func (f *Frame) stackDbg() {
 	fmt.Println()
 }
 
+// Run executes the frame until it returns, errors not handled by the code are returned to the caller
 func (f *Frame) Run() (Value, error) {
+	for {
+		v, err := f.run()
+		if err == nil {
+			return v, nil
+		}
+		if !f.unwind(err) {
+			return NIL, addTrace(err, f)
+		}
+	}
+}
+
+// unwind transfers control to the innermost handler covering current ip
+func (f *Frame) unwind(err error) bool {
+	h := f.code.findHandler(f.ip)
+	if h == nil {
+		return false
+	}
+	f.sp = h.sp
+	if perr := f.push(ExceptionValue(err)); perr != nil {
+		return false
+	}
+	f.ip = h.target
+	return true
+}
+
+func (f *Frame) run() (ret Value, err error) {
+	// errors raised while realizing lazy seqs are turned into regular errors so that they can be caught
+	defer recoverSeqError(&err)
 	//fmt.Print("run")
 	//f.code.Debug()
 	for {
@@ -320,6 +448,22 @@ func (f *Frame) Run() (Value, error) {
 			}
 			f.ip += 5
 
+		case OPLAC:
+			idx, err := f.code.Get32(f.ip + 1)
+			if err != nil {
+				return NIL, NewExecutionError("get argument index failed").Wrap(err)
+			}
+			if idx >= f.argc {
+				return NIL, NewExecutionError("argument lookup out of bounds")
+			}
+			err = f.push(f.args[idx])
+			if err != nil {
+				return NIL, NewExecutionError("argument push failed").Wrap(err)
+			}
+			// this was the last use, don't keep the value alive for the rest of the call
+			f.args[idx] = NIL
+			f.ip += 5
+
 		case OPRET:
 			v, err := f.pop()
 			if err != nil {
@@ -346,7 +490,15 @@ func (f *Frame) Run() (Value, error) {
 			}
 			args := make([]Value, len(a))
 			copy(args, a)
-			out := fn.Invoke(args)
+			// args are dropped after the call anyway, clearing them lets callees
+			// walk lazy seqs passed as arguments without holding on to their heads
+			for i := range a {
+				a[i] = NIL
+			}
+			out, err := fn.Invoke(args)
+			if err != nil {
+				return NIL, err
+			}
 			err = f.drop(arity + 1)
 			if err != nil {
 				return NIL, NewExecutionError("cleaning stack after call").Wrap(err)
@@ -435,6 +587,23 @@ func (f *Frame) Run() (Value, error) {
 			}
 			f.ip += 5
 
+		case OPDPC:
+			num, err := f.code.Get32(f.ip + 1)
+			if err != nil {
+				return NIL, NewExecutionError("DPC get argument").Wrap(err)
+			}
+			val, err := f.nth(num)
+			if err != nil {
+				return NIL, NewExecutionError("DPC get nth").Wrap(err)
+			}
+			// this was the last use of a local, don't keep the value alive for the rest of its scope
+			f.stack[f.sp-1-num] = NIL
+			err = f.push(val)
+			if err != nil {
+				return NIL, NewExecutionError("DPC push").Wrap(err)
+			}
+			f.ip += 5
+
 		case OPSTV:
 			val, err := f.pop()
 			if err != nil {
@@ -538,11 +707,16 @@ func (f *Frame) Run() (Value, error) {
 			if err != nil {
 				return NIL, NewExecutionError("REC reading argc").Wrap(err)
 			}
+			depth, err := f.code.Get32(f.ip + 9)
+			if err != nil {
+				return NIL, NewExecutionError("REC reading depth").Wrap(err)
+			}
 			a, err := f.mult(0, argc)
 			if err != nil {
 				return NIL, NewExecutionError("REC popping arguments failed").Wrap(err)
 			}
-			err = f.drop(argc * 2)
+			// drop new values along with everything pushed since the loop started
+			err = f.drop(argc + depth)
 			if err != nil {
 				return NIL, NewExecutionError("REC popping old locals").Wrap(err)
 			}
@@ -553,6 +727,24 @@ func (f *Frame) Run() (Value, error) {
 
 			f.ip -= offset
 
+		case OPLDS:
+			var self Value = NIL
+			if f.self != nil {
+				self = f.self
+			}
+			err := f.push(self)
+			if err != nil {
+				return NIL, NewExecutionError("LDS push failed").Wrap(err)
+			}
+			f.ip++
+
+		case OPTHR:
+			v, err := f.pop()
+			if err != nil {
+				return NIL, NewExecutionError("THR pop value").Wrap(err)
+			}
+			return NIL, Throwable(v)
+
 		default:
 			return NIL, NewExecutionError("unknown instruction")
 		}
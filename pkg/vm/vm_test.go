@@ -18,8 +18,14 @@
 package vm
 
 import (
+	"errors"
+	"math"
 	"math/rand"
+	"reflect"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -166,7 +172,7 @@ func TestSimpleCall(t *testing.T) {
 	plus, err := NativeFnType.Box(func(a int, b int) int { return b + a })
 	assert.NoError(t, err)
 
-	c := NewCodeChunk(&[]Value{forty, two, plus})
+	c := NewCodeChunk(NewConsts(forty, two, plus))
 	c.maxStack = 4
 	c.Append(OPLDC)
 	c.Append32(2)
@@ -189,3 +195,803 @@ func TestSimpleCall(t *testing.T) {
 
 	assert.Equal(t, 42, out.Unbox())
 }
+
+func TestNativeFnError(t *testing.T) {
+	boom := errors.New("boom")
+	failing, err := NativeFnType.Box(func(a int) (int, error) {
+		if a > 1 {
+			return 0, boom
+		}
+		return a, nil
+	})
+	assert.NoError(t, err)
+
+	out, err := failing.(Fn).Invoke([]Value{Int(1)})
+	assert.NoError(t, err)
+	assert.Equal(t, Int(1), out)
+
+	_, err = failing.(Fn).Invoke([]Value{Int(1), Int(2)})
+	assert.Error(t, err)
+
+	c := NewCodeChunk(NewConsts(failing, Int(2)))
+	c.maxStack = 2
+	c.Append(OPLDC)
+	c.Append32(0)
+	c.Append(OPLDC)
+	c.Append32(1)
+	c.Append(OPINV)
+	c.Append32(1)
+	c.Append(OPRET)
+
+	_, err = NewFrame(c, nil).Run()
+	rerr, ok := err.(*RuntimeError)
+	assert.True(t, ok)
+	assert.Equal(t, boom, rerr.GetCause())
+}
+
+func TestExInfo(t *testing.T) {
+	data, _ := NewMap([]Value{Keyword("a"), Int(1)})
+	assert.Equal(t, "ExceptionInfo: boom {:a 1}", NewExInfo("boom", data, nil).Error())
+
+	// data that can't be printed doesn't garble the message
+	bad := NewLazySeq(func() (Value, error) { return NIL, NewExecutionError("bad") })
+	data, _ = NewMap([]Value{Keyword("s"), bad})
+	ex := NewExInfo("boom", data, nil)
+	assert.Equal(t, "ExceptionInfo: boom", ex.Error())
+	_, err := PrintString(ex)
+	assert.Error(t, err)
+}
+
+func TestCodeChunkSourceInfo(t *testing.T) {
+	c := NewCodeChunk(NewConsts())
+	c.Append(OPNOP)
+	c.AddSourceInfo(SourceInfo{File: "a.lg", Line: 1, Column: 1})
+	c.Append(OPNOP, OPNOP)
+	c.AddSourceInfo(SourceInfo{File: "a.lg", Line: 2, Column: 3})
+	c.Append(OPNOP)
+
+	_, ok := c.SourceInfo(0)
+	assert.False(t, ok)
+	info, ok := c.SourceInfo(2)
+	assert.True(t, ok)
+	assert.Equal(t, 1, info.Line)
+
+	o := NewCodeChunk(NewConsts())
+	o.Append(OPNOP)
+	o.AppendChunk(c)
+	info, ok = o.SourceInfo(4)
+	assert.True(t, ok)
+	assert.Equal(t, SourceInfo{File: "a.lg", Line: 2, Column: 3}, info)
+	assert.Equal(t, "a.lg:2:3", info.String())
+}
+
+func TestMultiArityFn(t *testing.T) {
+	consts := NewConsts(Int(1))
+	// fn [] returns 1
+	zero := NewCodeChunk(consts)
+	zero.maxStack = 1
+	zero.Append(OPLDC)
+	zero.Append32(0)
+	zero.Append(OPRET)
+	// fn [& xs] returns the fn being run
+	rest := NewCodeChunk(consts)
+	rest.maxStack = 1
+	rest.Append(OPLDS, OPRET)
+
+	m, err := NewMultiArityFn([]Value{MakeFunc(0, false, zero), MakeFunc(1, true, rest)})
+	assert.NoError(t, err)
+
+	out, err := m.(Fn).Invoke(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, Int(1), out)
+
+	out, err = m.(Fn).Invoke([]Value{Int(1), Int(2)})
+	assert.NoError(t, err)
+	assert.Equal(t, m, out)
+
+	_, err = NewMultiArityFn([]Value{MakeFunc(1, false, zero), MakeFunc(1, false, zero)})
+	assert.Error(t, err)
+
+	_, err = NewMultiArityFn([]Value{MakeFunc(2, false, zero), MakeFunc(1, true, rest)})
+	assert.Error(t, err)
+}
+
+func TestSymbolNamespaced(t *testing.T) {
+	ns, name := Symbol("foo/bar").Namespaced()
+	assert.Equal(t, Symbol("foo"), ns)
+	assert.Equal(t, Symbol("bar"), name)
+
+	ns, name = Symbol("/").Namespaced()
+	assert.Equal(t, NIL, ns)
+	assert.Equal(t, Symbol("/"), name)
+
+	ns, name = Symbol("core//").Namespaced()
+	assert.Equal(t, Symbol("core"), ns)
+	assert.Equal(t, Symbol("/"), name)
+}
+
+// collider is a test value whose hashes always collide
+type collider int
+
+func (c collider) Type() ValueType    { return IntType }
+func (c collider) Unbox() interface{} { return int(c) }
+func (c collider) String() string     { return "collider" }
+func (c collider) Hash() uint32       { return 42 }
+func (c collider) Equals(o Value) bool {
+	oc, ok := o.(collider)
+	return ok && oc == c
+}
+
+func TestMap(t *testing.T) {
+	var m Associative = EmptyMap
+	for i := 0; i < 2000; i++ {
+		m = m.Assoc(Int(i), Int(i*2))
+	}
+	assert.Equal(t, 2000, m.(*Map).RawCount())
+	assert.Equal(t, Int(3998), m.(Lookup).ValueAt(Int(1999)))
+
+	half := m
+	for i := 0; i < 2000; i += 2 {
+		half = half.Dissoc(Int(i))
+	}
+	assert.Equal(t, 1000, half.(*Map).RawCount())
+	assert.Equal(t, NIL, half.(Lookup).ValueAt(Int(10)))
+	assert.Equal(t, Int(22), half.(Lookup).ValueAt(Int(11)))
+	assert.Equal(t, Int(20), m.(Lookup).ValueAt(Int(10)))
+
+	vk, _ := NewArrayVector([]Value{Int(1), Int(2)})
+	lk, _ := NewList([]Value{Int(1), Int(2)})
+	m = EmptyMap.Assoc(vk, String("vec"))
+	assert.Equal(t, String("vec"), m.(Lookup).ValueAt(lk))
+
+	var c Associative = EmptyMap
+	for i := 0; i < 5; i++ {
+		c = c.Assoc(collider(i), Int(i))
+	}
+	c = c.Assoc(Int(42), Int(42))
+	assert.Equal(t, 6, c.(*Map).RawCount())
+	assert.Equal(t, Int(3), c.(Lookup).ValueAt(collider(3)))
+	c = c.Dissoc(collider(3))
+	assert.Equal(t, NIL, c.(Lookup).ValueAt(collider(3)))
+	assert.Equal(t, Int(4), c.(Lookup).ValueAt(collider(4)))
+	assert.Equal(t, 5, c.(*Map).RawCount())
+
+	a, _ := NewMap([]Value{Keyword("a"), Int(1), Keyword("b"), vk})
+	b, _ := NewMap([]Value{Keyword("b"), lk, Keyword("a"), Int(1)})
+	assert.True(t, Equals(a, b))
+	assert.Equal(t, Hash(a), Hash(b))
+}
+
+func TestPersistentVector(t *testing.T) {
+	n := 40000
+	v := EmptyPersistentVector
+	for i := 0; i < n; i++ {
+		v = v.Conj(Int(i))
+	}
+	assert.Equal(t, n, v.RawCount())
+	for i := 0; i < n; i++ {
+		e, ok := v.Nth(i)
+		assert.True(t, ok)
+		if e != Int(i) {
+			t.Fatalf("expected %d at %d, got %s", i, i, e)
+		}
+	}
+	_, ok := v.Nth(n)
+	assert.False(t, ok)
+
+	u, err := v.AssocN(1100, String("x"))
+	assert.NoError(t, err)
+	assert.Equal(t, String("x"), u.ValueAt(Int(1100)))
+	assert.Equal(t, Int(1100), v.ValueAt(Int(1100)))
+	_, err = v.AssocN(n+1, NIL)
+	assert.Error(t, err)
+
+	p := v
+	for i := n - 1; i >= 0; i-- {
+		assert.Equal(t, Int(i), p.Peek())
+		p, err = p.Pop()
+		assert.NoError(t, err)
+		assert.Equal(t, i, p.RawCount())
+	}
+	_, err = p.Pop()
+	assert.Error(t, err)
+	assert.Equal(t, Int(n-1), v.Peek())
+
+	out, err := v.Invoke([]Value{Int(33)})
+	assert.NoError(t, err)
+	assert.Equal(t, Int(33), out)
+	assert.Equal(t, "[1 2 3]", NewPersistentVector([]Value{Int(1), Int(2), Int(3)}).String())
+
+	av := ArrayVector{Int(1), Int(2)}
+	assert.Equal(t, []Value{Int(0), Int(1), Int(2)}, av.Cons(Int(0)).Unbox())
+	assert.True(t, Equals(av, NewPersistentVector(av)))
+	assert.Equal(t, Hash(av), Hash(NewPersistentVector(av)))
+}
+
+func TestEquals(t *testing.T) {
+	type point struct{ x, y int }
+	a, b := NewBoxed(point{1, 2}), NewBoxed(point{1, 2})
+	assert.True(t, Equals(a, b))
+	assert.Equal(t, Hash(a), Hash(b))
+	assert.False(t, Equals(a, NewBoxed(point{2, 1})))
+
+	s := []int{1, 2}
+	assert.True(t, Equals(NewBoxed(s), NewBoxed(s)))
+	assert.False(t, Equals(NewBoxed(s), NewBoxed([]int{1, 2})))
+
+	assert.True(t, Equals(NIL, NIL))
+	assert.False(t, Equals(NIL, FALSE))
+	assert.False(t, Equals(FALSE, NIL))
+
+	l, _ := NewList([]Value{Int(1), Keyword("a")})
+	assert.True(t, Equals(l, NewPersistentVector([]Value{Int(1), Keyword("a")})))
+	assert.Equal(t, Hash(l), Hash(ArrayVector{Int(1), Keyword("a")}))
+	assert.False(t, Equals(l, EmptyMap))
+}
+
+func TestNumbers(t *testing.T) {
+	_, err := Add(Int(maxInt), Int(1))
+	assert.Error(t, err)
+	_, err = Mul(Int(minInt), Int(-1))
+	assert.Error(t, err)
+	_, err = Sub(Int(minInt), Int(1))
+	assert.Error(t, err)
+
+	out, err := AddP(Int(maxInt), Int(1))
+	assert.NoError(t, err)
+	assert.Equal(t, "9223372036854775808N", out.String())
+	out, err = Sub(out, Int(1))
+	assert.NoError(t, err)
+	assert.True(t, Equals(Int(maxInt), out))
+	assert.Equal(t, Hash(Int(maxInt)), Hash(out))
+
+	out, err = Div(Int(1), Int(3))
+	assert.NoError(t, err)
+	assert.Equal(t, RatioType, out.Type())
+	out, err = Mul(out, Int(3))
+	assert.NoError(t, err)
+	assert.Equal(t, Int(1), out)
+
+	_, ok, err := Compare(Float(math.NaN()), Int(1))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	c, ok, err := Compare(Int(1), Float(1.5))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, -1, c)
+	_, _, err = Compare(Int(1), String("1"))
+	assert.Error(t, err)
+
+	assert.Equal(t, "1.0", Float(1).String())
+	assert.True(t, Equals(Float(0), Float(math.Copysign(0, -1))))
+	assert.Equal(t, Hash(Float(0)), Hash(Float(math.Copysign(0, -1))))
+}
+
+func TestLazySeq(t *testing.T) {
+	calls := 0
+	var upTo func(i int, n int) Seq
+	upTo = func(i int, n int) Seq {
+		return NewLazySeq(func() (Value, error) {
+			calls++
+			if i == n {
+				return NIL, nil
+			}
+			return NewCons(Int(i), upTo(i+1, n)), nil
+		})
+	}
+	s := upTo(0, 3)
+	assert.Equal(t, 0, calls)
+	assert.Equal(t, Int(0), s.First())
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, []Value{Int(0), Int(1), Int(2)}, s.Unbox())
+	assert.Equal(t, 4, calls)
+	assert.Equal(t, 3, s.(Collection).RawCount())
+	assert.True(t, Equals(s, ArrayVector{Int(0), Int(1), Int(2)}))
+	assert.Equal(t, "(0 1 2)", s.String())
+
+	// deeply nested lazy seqs are unwrapped without recursion
+	var nested Seq = NewLazySeq(func() (Value, error) { return ArrayVector{Int(1)}, nil })
+	for i := 0; i < 100000; i++ {
+		inner := nested
+		nested = NewLazySeq(func() (Value, error) { return inner, nil })
+	}
+	assert.Equal(t, Int(1), nested.First())
+
+	boom := NewLazySeq(func() (Value, error) { return NIL, NewExecutionError("boom") })
+	_, err := ToSeq(boom)
+	assert.Error(t, err)
+	_, err = PrintString(NewCons(Int(1), boom))
+	assert.Error(t, err)
+	assert.False(t, boom.IsRealized())
+
+	// fn is gone once it ran so a seq that failed keeps failing
+	tries := 0
+	flaky := NewLazySeq(func() (Value, error) {
+		tries++
+		if tries == 1 {
+			return NIL, NewExecutionError("flaky")
+		}
+		return ArrayVector{Int(1)}, nil
+	})
+	_, err = ToSeq(flaky)
+	assert.Error(t, err)
+	_, err = ToSeq(flaky)
+	assert.Error(t, err)
+	assert.Equal(t, 1, tries)
+
+	count, _ := NativeFnType.Wrap(func(vs []Value) (Value, error) {
+		return vs[0].(Collection).Count(), nil
+	})
+	out, err := count.(Fn).Invoke([]Value{boom})
+	assert.Error(t, err)
+	assert.Equal(t, NIL, out)
+
+	// collections invoked as functions hash their argument, which realizes it
+	m, _ := NewMap([]Value{Keyword("a"), Int(1)})
+	for _, f := range []Fn{m.(*Map), NewSet([]Value{Int(1)})} {
+		_, err = f.Invoke([]Value{boom})
+		assert.Error(t, err)
+	}
+}
+
+func TestAtom(t *testing.T) {
+	inc, _ := NativeFnType.Wrap(func(vs []Value) (Value, error) {
+		return vs[0].(Int) + 1, nil
+	})
+	a := NewAtom(Int(0))
+	var watched int64
+	a.AddWatch(Keyword("w"), inc.(Fn))
+	counter, _ := NativeFnType.Wrap(func(vs []Value) (Value, error) {
+		atomic.AddInt64(&watched, 1)
+		return NIL, nil
+	})
+	a.AddWatch(Keyword("w"), counter.(Fn))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				_, _, err := a.Swap(inc.(Fn), nil)
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, Int(8000), a.Deref())
+
+	ok, err := a.CompareAndSet(Int(1), Int(0))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = a.CompareAndSet(Int(8000), Int(0))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	a.RemoveWatch(Keyword("w"))
+	_, err = a.Reset(Int(1))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(8001), watched)
+
+	positive, _ := NativeFnType.Wrap(func(vs []Value) (Value, error) {
+		return Boolean(vs[0].(Int) > 0), nil
+	})
+	assert.NoError(t, a.SetValidator(positive))
+	_, err = a.Reset(Int(-1))
+	assert.Error(t, err)
+	assert.Equal(t, Int(1), a.Deref())
+	_, err = a.Reset(Int(0))
+	assert.Error(t, err)
+	assert.NoError(t, a.SetValidator(NIL))
+	_, err = a.Reset(Int(-1))
+	assert.NoError(t, err)
+	assert.Error(t, a.SetValidator(positive))
+	assert.Equal(t, NIL, a.Validator())
+}
+
+func TestChan(t *testing.T) {
+	goch := make(chan int, 1)
+	v, err := BoxValue(reflect.ValueOf(goch))
+	assert.NoError(t, err)
+	c := v.(*Chan)
+	ok, err := c.Put(Int(42))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 42, <-goch)
+	goch <- 7
+	out, err := c.Take()
+	assert.NoError(t, err)
+	assert.Equal(t, Int(7), out)
+	_, err = c.Put(String("nope"))
+	assert.Error(t, err)
+	close(goch)
+	out, err = c.Take()
+	assert.NoError(t, err)
+	assert.Equal(t, NIL, out)
+	ok, err = c.Put(Int(1))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	var recvOnly <-chan int = make(chan int)
+	ro, err := WrapChan(recvOnly)
+	assert.NoError(t, err)
+	_, err = ro.Put(Int(1))
+	assert.Error(t, err)
+
+	lc := NewChan(NewBuffer(1, BlockingBuffer))
+	values := lc.Unbox().(chan Value)
+	go func() { values <- Keyword("from-go") }()
+	out, err = lc.Take()
+	assert.NoError(t, err)
+	assert.Equal(t, Keyword("from-go"), out)
+
+	_, idx, err := Alts([]ChanOp{{Chan: NewChan(nil)}}, false, false)
+	assert.NoError(t, err)
+	assert.Equal(t, -1, idx)
+	out, idx, err = Alts([]ChanOp{{Chan: NewChan(nil)}, {Chan: NewTimeout(time.Millisecond)}}, false, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, idx)
+	assert.Equal(t, NIL, out)
+}
+
+func TestFuture(t *testing.T) {
+	p := NewPromise()
+	wait, _ := NativeFnType.Wrap(func(vs []Value) (Value, error) {
+		v, _, err := p.Await(-1)
+		return v, err
+	})
+	f := NewFuture(wait.(Fn))
+	_, ok, err := f.Await(time.Millisecond)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.False(t, f.IsRealized())
+	p.Deliver(Int(1))
+	assert.False(t, p.Deliver(Int(2)))
+	v, ok, err := f.Await(-1)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, Int(1), v)
+	assert.False(t, f.Cancel())
+
+	calls := 0
+	count, _ := NativeFnType.Wrap(func(vs []Value) (Value, error) {
+		calls++
+		return NIL, errors.New("failed")
+	})
+	d := NewDelay(count.(Fn))
+	_, err = d.Force()
+	assert.Error(t, err)
+	_, err = d.Force()
+	assert.Error(t, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestSTM(t *testing.T) {
+	a, b := NewRef(Int(1)), NewRef(Int(1))
+	read := make(chan struct{})
+	written := make(chan struct{})
+	attempts := 0
+	body, _ := NativeFnType.Wrap(func(vs []Value) (Value, error) {
+		attempts++
+		x, err := a.Get()
+		if err != nil {
+			return NIL, err
+		}
+		if attempts == 1 {
+			read <- struct{}{}
+			<-written
+		}
+		// b was changed by another transaction but the snapshot must stay consistent
+		y, err := b.Get()
+		if err != nil {
+			return NIL, err
+		}
+		if _, err := a.Set(x.(Int) + y.(Int)); err != nil {
+			return NIL, err
+		}
+		return x.(Int) + y.(Int), nil
+	})
+	done := make(chan Value)
+	go func() {
+		v, err := RunInTransaction(body.(Fn))
+		assert.NoError(t, err)
+		done <- v
+	}()
+	<-read
+	other, _ := NativeFnType.Wrap(func(vs []Value) (Value, error) {
+		if _, err := a.Set(Int(10)); err != nil {
+			return NIL, err
+		}
+		return b.Set(Int(10))
+	})
+	_, err := RunInTransaction(other.(Fn))
+	assert.NoError(t, err)
+	close(written)
+	// the first attempt read a before it was changed so it has to retry
+	assert.Equal(t, Int(20), <-done)
+	assert.Equal(t, 2, attempts)
+	assert.Equal(t, Int(20), a.Deref())
+
+	assert.False(t, IsInstance(ExceptionType, ExceptionValue(errRetry)))
+	_, err = a.Set(Int(1))
+	assert.Error(t, err)
+}
+
+func TestAgent(t *testing.T) {
+	a := NewAgent(Int(0))
+	inc, _ := NativeFnType.Wrap(func(vs []Value) (Value, error) {
+		return vs[0].(Int) + 1, nil
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				assert.NoError(t, a.Send(SendPool, inc.(Fn), nil))
+			}
+		}()
+	}
+	wg.Wait()
+	ok, err := AwaitAgents([]*Agent{a}, -1)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, Int(800), a.Deref())
+
+	fail, _ := NativeFnType.Wrap(func(vs []Value) (Value, error) {
+		return NIL, errors.New("failed")
+	})
+	assert.NoError(t, a.Send(SendOffPool, fail.(Fn), nil))
+	_, err = AwaitAgents([]*Agent{a}, time.Second)
+	assert.NoError(t, err)
+	assert.Error(t, a.Error())
+	assert.Error(t, a.Send(SendPool, inc.(Fn), nil))
+	assert.NoError(t, a.Restart(Int(0), true))
+	assert.NoError(t, a.Send(SendPool, inc.(Fn), nil))
+	_, err = AwaitAgents([]*Agent{a}, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, Int(1), a.Deref())
+}
+
+func TestBinding(t *testing.T) {
+	v := NewVar(nil, "user", "*x*").SetRoot(Int(1))
+	assert.Error(t, PushBindings(map[*Var]Value{v: Int(2)}))
+	v.SetDynamic()
+	assert.NoError(t, PushBindings(map[*Var]Value{v: Int(2)}))
+	assert.Equal(t, Int(2), v.Deref())
+	get, _ := NativeFnType.Wrap(func(vs []Value) (Value, error) {
+		return v.Deref(), nil
+	})
+	bound := BoundFn(get.(Fn))
+	done := make(chan Value)
+	go func() {
+		unbound, _ := get.(Fn).Invoke(nil)
+		conveyed, _ := bound.Invoke(nil)
+		done <- unbound
+		done <- conveyed
+	}()
+	assert.Equal(t, Int(1), <-done)
+	assert.Equal(t, Int(2), <-done)
+	v.Set(Int(3))
+	assert.Equal(t, Int(3), v.Deref())
+	assert.NoError(t, PopBindings())
+	assert.Equal(t, Int(1), v.Deref())
+	assert.Error(t, PopBindings())
+}
+
+func TestProtocol(t *testing.T) {
+	p := NewProtocol("user/Sizer", []Symbol{"size"})
+	size, err := p.Method("size")
+	assert.NoError(t, err)
+	_, err = p.Method("nope")
+	assert.Error(t, err)
+
+	strSize, _ := NativeFnType.Wrap(func(vs []Value) (Value, error) {
+		return Int(len(vs[0].(String))), nil
+	})
+	assert.NoError(t, p.Extend(StringType, map[Symbol]Fn{"size": strSize.(Fn)}))
+	assert.Error(t, p.Extend(StringType, map[Symbol]Fn{"nope": strSize.(Fn)}))
+
+	out, err := size.Invoke([]Value{String("four")})
+	assert.NoError(t, err)
+	assert.Equal(t, Int(4), out)
+	_, err = size.Invoke([]Value{Int(1)})
+	assert.Error(t, err)
+
+	// boxed Go types are keyed by their reflected type
+	tm := time.Now()
+	timeType := BoxedTypeOf(tm)
+	zero, _ := NativeFnType.Wrap(func(vs []Value) (Value, error) {
+		return Int(0), nil
+	})
+	assert.NoError(t, p.Extend(timeType, map[Symbol]Fn{"size": zero.(Fn)}))
+	assert.True(t, p.Satisfies(NewBoxed(tm)))
+	found, ok := FindBoxedType("time.Time")
+	assert.True(t, ok)
+	assert.Equal(t, timeType, found)
+	assert.Equal(t, []ValueType{timeType, StringType}, p.Extenders())
+}
+
+func TestRecord(t *testing.T) {
+	pt := NewRecordType("user.Point", []Symbol{"x", "y"}, true)
+	_, err := pt.New([]Value{Int(1)})
+	assert.Error(t, err)
+	v, err := pt.New([]Value{Int(1), Int(2)})
+	assert.NoError(t, err)
+	p := v.(*Record)
+	assert.Equal(t, pt, p.Type())
+	assert.Equal(t, Int(2), p.ValueAt(Keyword("y")))
+
+	moved := p.Assoc(Keyword("x"), Int(5)).(*Record)
+	assert.Equal(t, Int(1), p.ValueAt(Keyword("x")))
+	assert.Equal(t, Int(5), moved.ValueAt(Keyword("x")))
+	assert.False(t, Equals(p, moved))
+
+	m, _ := NewMap([]Value{Keyword("x"), Int(1), Keyword("y"), Int(2)})
+	fromMap, err := pt.FromMap(m.(*Map))
+	assert.NoError(t, err)
+	assert.True(t, Equals(p, fromMap))
+	assert.Equal(t, Hash(p), Hash(fromMap))
+	assert.False(t, Equals(p, m))
+	assert.True(t, Equals(m, p.ToMap()))
+	assert.Equal(t, "#user.Point{:x 1 :y 2}", p.String())
+
+	_, isMap := p.Dissoc(Keyword("x")).(*Map)
+	assert.True(t, isMap)
+
+	tt := NewRecordType("user.Box", []Symbol{"v"}, false)
+	b, err := tt.New([]Value{Int(3)})
+	assert.NoError(t, err)
+	out, err := b.(Receiver).InvokeMethod("v", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, Int(3), out)
+	_, err = b.(Receiver).InvokeMethod("w", nil)
+	assert.Error(t, err)
+}
+
+func TestMultiFn(t *testing.T) {
+	h := MakeHierarchy()
+	h, err := Derive(h, Keyword("square"), Keyword("rect"))
+	assert.NoError(t, err)
+	h, err = Derive(h, Keyword("rect"), Keyword("shape"))
+	assert.NoError(t, err)
+	_, err = Derive(h, Keyword("shape"), Keyword("square"))
+	assert.Error(t, err)
+	assert.True(t, Isa(h, Keyword("square"), Keyword("shape")))
+	assert.False(t, Isa(h, Keyword("shape"), Keyword("square")))
+	assert.True(t, Isa(h, ArrayVector{Keyword("square"), Int(1)}, ArrayVector{Keyword("rect"), Int(1)}))
+	assert.Equal(t, 2, HierarchyAncestors(h, Keyword("square")).RawCount())
+	assert.Equal(t, 2, HierarchyDescendants(h, Keyword("shape")).RawCount())
+	assert.True(t, HierarchyParents(h, Keyword("square")).Equals(NewSet([]Value{Keyword("rect")})))
+
+	first, _ := NativeFnType.Wrap(func(vs []Value) (Value, error) { return vs[0], nil })
+	named := func(s string) Fn {
+		f, _ := NativeFnType.Wrap(func(vs []Value) (Value, error) { return String(s), nil })
+		return f.(Fn)
+	}
+	m := NewMultiFn("user/kind", first.(Fn), Keyword("default"), NewAtom(h))
+	_, err = m.Invoke([]Value{Keyword("square")})
+	assert.Error(t, err)
+
+	m.AddMethod(Keyword("shape"), named("shape"))
+	out, err := m.Invoke([]Value{Keyword("square")})
+	assert.NoError(t, err)
+	assert.Equal(t, String("shape"), out)
+
+	m.AddMethod(Keyword("rect"), named("rect"))
+	out, _ = m.Invoke([]Value{Keyword("square")})
+	assert.Equal(t, String("rect"), out)
+
+	m.AddMethod(Keyword("default"), named("default"))
+	out, _ = m.Invoke([]Value{Keyword("circle")})
+	assert.Equal(t, String("default"), out)
+
+	m.RemoveMethod(Keyword("rect"))
+	out, _ = m.Invoke([]Value{Keyword("square")})
+	assert.Equal(t, String("shape"), out)
+	assert.Equal(t, 2, m.Methods().RawCount())
+}
+
+func TestVarMeta(t *testing.T) {
+	lib := NewNamespace("meta.lib")
+	secret := lib.Def("secret", Int(1))
+	lib.Def("open", Int(2))
+	m, _ := NewMap([]Value{Keyword("private"), TRUE, Keyword("dynamic"), TRUE, Keyword("doc"), String("shh")})
+	secret.SetMeta(m.(*Map))
+	assert.True(t, secret.IsPrivate())
+	assert.True(t, secret.IsDynamic())
+	assert.False(t, secret.IsMacro())
+	meta := secret.Meta()
+	assert.Equal(t, String("shh"), meta.ValueAt(Keyword("doc")))
+	assert.Equal(t, Symbol("secret"), meta.ValueAt(Keyword("name")))
+	assert.Equal(t, lib, meta.ValueAt(Keyword("ns")))
+
+	user := NewNamespace("meta.user")
+	user.Refer(lib, "", true)
+	assert.Equal(t, NIL, user.Lookup("secret"))
+	assert.NotEqual(t, NIL, user.Lookup("open"))
+	assert.Error(t, user.ReferVars(lib, []Symbol{"secret"}))
+	assert.NoError(t, user.ReferVars(lib, []Symbol{"open"}))
+}
+
+func TestMeta(t *testing.T) {
+	m, _ := NewMap([]Value{Keyword("a"), Int(1)})
+	meta := m.(*Map)
+
+	v, err := WithMeta(NewPersistentVector([]Value{Int(1)}), meta)
+	assert.NoError(t, err)
+	assert.Equal(t, meta, v.(IMeta).Meta())
+	assert.True(t, Equals(v, ArrayVector{Int(1)}))
+	assert.Equal(t, meta, v.(*PersistentVector).Conj(Int(2)).Meta())
+
+	av, err := WithMeta(ArrayVector{Int(1)}, meta)
+	assert.NoError(t, err)
+	assert.Equal(t, meta, MetaOf(av))
+
+	s, err := WithMeta(Symbol("x"), meta)
+	assert.NoError(t, err)
+	assert.True(t, Equals(s, Symbol("x")))
+	assert.True(t, Equals(Symbol("x"), s))
+	assert.Equal(t, Hash(Symbol("x")), Hash(s))
+	bare, ok := SymbolOf(s)
+	assert.True(t, ok)
+	assert.Equal(t, Symbol("x"), bare)
+
+	l, err := WithMeta(EmptyList.Cons(Int(1)), meta)
+	assert.NoError(t, err)
+	assert.Equal(t, meta, l.(*List).Cons(Int(0)).(*List).Meta())
+	assert.Equal(t, NIL, MetaOf(EmptyList.Cons(Int(1))))
+
+	inc, _ := NativeFnType.Wrap(func(vs []Value) (Value, error) { return vs[0].(Int) + 1, nil })
+	f, err := WithMeta(inc, meta)
+	assert.NoError(t, err)
+	out, err := f.(Fn).Invoke([]Value{Int(1)})
+	assert.NoError(t, err)
+	assert.Equal(t, Int(2), out)
+	assert.Equal(t, meta, MetaOf(f))
+
+	_, err = WithMeta(Int(1), meta)
+	assert.Error(t, err)
+	_, err = WithMeta(Keyword("kw"), meta)
+	assert.Error(t, err)
+	_, err = WithMeta(NewVar(nil, "user", "x"), meta)
+	assert.Error(t, err)
+
+	consts := NewConsts()
+	assert.NotEqual(t, consts.Intern(NewPersistentVector([]Value{Int(1)})), consts.Intern(v))
+}
+
+func TestSet(t *testing.T) {
+	s := NewSet([]Value{Int(1), Int(2), Int(1)})
+	assert.Equal(t, 2, s.RawCount())
+	assert.True(t, s.Contains(Int(2)))
+	assert.False(t, s.Contains(Int(3)))
+	assert.Same(t, s, s.Conj(Int(1)))
+	assert.Same(t, s, s.Disj(Int(3)))
+	assert.Equal(t, 1, s.Disj(Int(1)).RawCount())
+	assert.Equal(t, 2, s.RawCount())
+
+	other := NewSet([]Value{Int(2), Int(1)})
+	assert.True(t, s.Equals(other))
+	assert.Equal(t, s.Hash(), other.Hash())
+	assert.False(t, s.Equals(EmptySet))
+	assert.Equal(t, "#{}", EmptySet.String())
+
+	out, err := s.Invoke([]Value{Int(2)})
+	assert.NoError(t, err)
+	assert.Equal(t, Int(2), out)
+	out, err = s.Invoke([]Value{Int(5)})
+	assert.NoError(t, err)
+	assert.Equal(t, NIL, out)
+	_, err = s.Invoke(nil)
+	assert.Error(t, err)
+
+	elems, err := SeqValues(s)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []Value{Int(1), Int(2)}, elems)
+
+	m, _ := NewMap([]Value{Keyword("a"), Int(1)})
+	ws := s.WithMeta(m.(*Map)).(*Set)
+	assert.Equal(t, m, ws.Conj(Int(3)).Meta())
+	assert.True(t, ws.Equals(s))
+}